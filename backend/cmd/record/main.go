@@ -0,0 +1,264 @@
+// Command record converts and checks game records (see models/record.go).
+// No database or server is needed.
+//
+//	go run ./cmd/record convert actions.json > game.json  # GET /api/game/:id/actions -> record
+//	go run ./cmd/record check game.json other.json        # play records through the rules engine
+//	go run ./cmd/record text game.json                    # print a readable transcript
+//	go run ./cmd/record analyze -min 30 game.json         # flag costly plays (see models/analysis.go)
+//	go run ./cmd/record sim -seed 42 -seats hard,normal > game.json
+//
+// A file argument of - reads stdin. convert accepts the response of the actions
+// endpoint or a bare array of actions. check exits with 1 if any record is refused.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"leve_up/models"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "convert":
+		convert(args)
+	case "check":
+		check(args)
+	case "text":
+		text(args)
+	case "analyze":
+		analyze(args)
+	case "sim":
+		sim(args)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: record convert|check|text|analyze|sim [arguments]")
+	os.Exit(2)
+}
+
+func convert(args []string) {
+	if len(args) != 1 {
+		fail("usage: record convert actions.json")
+	}
+	raw := readFile(args[0])
+
+	var actions []models.GameActionLog
+	if err := json.Unmarshal(raw, &actions); err != nil {
+		var response struct {
+			Actions []models.GameActionLog `json:"actions"`
+		}
+		if err := json.Unmarshal(raw, &response); err != nil {
+			fail("%s: not an action log: %v", args[0], err)
+		}
+		actions = response.Actions
+	}
+
+	record, err := models.RecordFromActions(actions)
+	if err != nil {
+		fail("%s: %v", args[0], err)
+	}
+	printJSON(os.Stdout, record)
+}
+
+func check(args []string) {
+	if len(args) == 0 {
+		fail("usage: record check game.json...")
+	}
+	out := silenceEngine()
+	failed := false
+	for _, path := range args {
+		played, err := models.PlayGameRecord(readRecord(path))
+		if err != nil {
+			fmt.Fprintf(out, "%s: refused: %v\n", path, err)
+			failed = true
+			continue
+		}
+		r := played.Result
+		fmt.Fprintf(out, "%s: ok, dealer seat %d, %d points, %s wins", path, played.Table.DealerSeat, r.Points, r.WinnerTeam)
+		if r.SoloMode {
+			fmt.Fprintln(out, " (1v4)")
+		} else {
+			fmt.Fprintf(out, " (friend seat %d)\n", r.FriendSeat)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func text(args []string) {
+	if len(args) != 1 {
+		fail("usage: record text game.json")
+	}
+	r := readRecord(args[0])
+	w := os.Stdout
+
+	fmt.Fprintf(w, "%s %q played %s\n", r.GameID, r.Name, r.PlayedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "level %s, starting dealer seat %d", r.Level, r.StartingDealer)
+	if r.Seed != nil {
+		fmt.Fprintf(w, ", seed %d", *r.Seed)
+	}
+	fmt.Fprintln(w)
+	for _, p := range r.Players {
+		fmt.Fprintf(w, "seat %d %s (level %s): %s\n", p.Seat, playerName(p), p.Level, strings.Join(p.Hand, " "))
+	}
+	fmt.Fprintf(w, "bottom: %s\n\n", strings.Join(r.Bottom, " "))
+
+	for _, bid := range r.Bids {
+		fmt.Fprintf(w, "bid: seat %d %s %s\n", bid.Seat, bid.Suit, strings.Join(bid.Cards, " "))
+	}
+	if len(r.Flips) > 0 {
+		fmt.Fprintf(w, "flipped: %s\n", strings.Join(r.Flips, " "))
+	}
+	fmt.Fprintf(w, "dealer: seat %d, trump %s %s\n", r.Dealer, r.Trump.Suit, r.Trump.Rank)
+	fmt.Fprintf(w, "discards: %s\n", strings.Join(r.Discards, " "))
+	if r.Friend != nil {
+		fmt.Fprintf(w, "friend: %s #%d\n", r.Friend.Card, r.Friend.Position)
+	}
+	fmt.Fprintln(w)
+
+	for i, trick := range r.Tricks {
+		plays := make([]string, len(trick.Plays))
+		for j, play := range trick.Plays {
+			plays[j] = fmt.Sprintf("%d:%s", play.Seat, strings.Join(play.Cards, " "))
+		}
+		fmt.Fprintf(w, "trick %2d: %s -> seat %d\n", i+1, strings.Join(plays, " | "), trick.Winner)
+	}
+
+	res := r.Result
+	fmt.Fprintf(w, "\nresult: %d points, %s wins", res.Points, res.WinnerTeam)
+	if res.SoloMode {
+		fmt.Fprintln(w, " (1v4)")
+	} else {
+		fmt.Fprintf(w, " (friend seat %d)\n", res.FriendSeat)
+	}
+	for _, level := range res.Levels {
+		fmt.Fprintf(w, "seat %d: %s -> %s\n", level.Seat, level.From, level.To)
+	}
+}
+
+func analyze(args []string) {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	minLoss := flags.Int("min", models.DefaultMistakeLoss, "points a play must cost to be listed")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fail("usage: record analyze [-min points] game.json")
+	}
+
+	out := silenceEngine()
+	played, err := models.PlayGameRecord(readRecord(flags.Arg(0)))
+	if err != nil {
+		fail("%s: refused: %v", flags.Arg(0), err)
+	}
+	analysis, err := models.AnalyzeActions(flags.Arg(0), played.Actions)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	fmt.Fprintf(out, "%d plays with a choice analysed in %s\n\n", len(analysis.Plays), analysis.Duration.Round(1e6))
+	for _, m := range analysis.Mistakes(*minLoss) {
+		fmt.Fprintf(out, "trick %2d seat %d: played %s (%d points), %s was better (%d points): costs %d\n",
+			m.Trick, m.Seat, strings.Join(models.CardCodes(m.Played), " "), m.PlayedPoints,
+			strings.Join(models.CardCodes(m.Best), " "), m.BestPoints, m.Loss)
+	}
+	fmt.Fprintln(out)
+	for _, s := range analysis.Seats(*minLoss) {
+		side := "defender"
+		if s.HostTeam {
+			side = "dealer side"
+		}
+		fmt.Fprintf(out, "seat %d (%s): %d mistakes in %d plays, %d points lost\n", s.Seat, side, s.Mistakes, s.Plays, s.PointsLost)
+	}
+}
+
+func sim(args []string) {
+	flags := flag.NewFlagSet("sim", flag.ExitOnError)
+	seed := flags.Int64("seed", 1, "seed of the hand")
+	seats := flags.String("seats", models.AIDifficultyNormal, "comma separated difficulty[/personality] per seat, from seat 1")
+	think := flags.Int("think", 0, "hard: search budget per play in ms (0 = AI_THINK_MS)")
+	flags.Parse(args)
+
+	settings := make(map[int]models.AISettings, 5)
+	parts := strings.Split(*seats, ",")
+	if len(parts) > 5 {
+		fail("-seats lists %d seats, at most 5", len(parts))
+	}
+	for seat := 1; seat <= 5; seat++ {
+		part := parts[len(parts)-1] // 未列出的座位沿用最后一个
+		if seat <= len(parts) {
+			part = parts[seat-1]
+		}
+		difficulty, personality, _ := strings.Cut(strings.TrimSpace(part), "/")
+		if !models.IsValidAIDifficulty(difficulty) || !models.IsValidAIPersonality(personality) {
+			fail("seat %d: unknown strategy %q", seat, part)
+		}
+		settings[seat] = models.AISettings{Difficulty: difficulty, Personality: personality, ThinkMs: *think}
+	}
+
+	out := silenceEngine()
+	record, _, err := models.RecordSimulatedHand(*seed, settings)
+	if err != nil {
+		fail("%v", err)
+	}
+	printJSON(out, record)
+}
+
+func playerName(p models.RecordPlayer) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.ID
+}
+
+func readFile(path string) []byte {
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+	return raw
+}
+
+func readRecord(path string) *models.GameRecord {
+	var record models.GameRecord
+	if err := json.Unmarshal(readFile(path), &record); err != nil {
+		fail("%s: not a game record: %v", path, err)
+	}
+	return &record
+}
+
+// silenceEngine discards the engine's debug log and returns where the output goes
+func silenceEngine() io.Writer {
+	log.SetOutput(io.Discard)
+	return os.Stdout
+}
+
+func printJSON(w io.Writer, v interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fail("failed to encode record: %v", err)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "record: "+format+"\n", args...)
+	os.Exit(2)
+}
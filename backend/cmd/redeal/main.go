@@ -0,0 +1,64 @@
+// Command redeal re-deals a game from its seed and prints the deal as JSON.
+//
+//	go run ./cmd/redeal -seed 42            # any seed, no database needed
+//	go run ./cmd/redeal -seed 42 -single    # same cards, dealer fixed to seat 1
+//	go run ./cmd/redeal -game <gameID>      # finished game, checked against its log
+//
+// With -game the database is read from the usual DATABASE_URL / DB_* settings,
+// and the exit code is 1 if the re-deal does not match the logged deal.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"leve_up/models"
+	"os"
+)
+
+func main() {
+	gameID := flag.String("game", "", "finished game to re-deal from its revealed seed")
+	seed := flag.Int64("seed", 0, "seed to deal from")
+	single := flag.Bool("single", false, "single player deal (starting dealer is seat 1)")
+	flag.Parse()
+
+	seedSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
+		}
+	})
+
+	switch {
+	case *gameID != "":
+		if err := models.InitDB(); err != nil {
+			fail("failed to initialize database: %v", err)
+		}
+		result, err := models.RedealGame(*gameID)
+		if err != nil {
+			fail("%v", err)
+		}
+		printJSON(result)
+		if !result.MatchesLog {
+			fail("re-deal of %s does not match the logged deal", *gameID)
+		}
+	case seedSet:
+		printJSON(models.DealFromSeed(*seed, *single))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fail("failed to encode output: %v", err)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "redeal: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,281 @@
+// Command simulate plays all-AI hands through the rules engine in-process and
+// reports how the strategies did. No database or server is needed.
+//
+//	go run ./cmd/simulate -hands 5000                       # five normal AIs
+//	go run ./cmd/simulate -hands 1000 -seats hard,normal,normal,normal,normal -think 50
+//	go run ./cmd/simulate -hands 2000 -seats normal/aggressive,normal -json
+//	go run ./cmd/simulate -bot mybot=http://127.0.0.1:9000/play -seats mybot,normal
+//	go run ./cmd/simulate -tournament -hands 500 -seats hard,normal,easy -think 50 -csv ranking.csv
+//
+// -seats lists each seat's difficulty, optionally with /personality; seats left
+// out repeat the last one. -bot registers an external bot (see models/ai_bot.go)
+// under a difficulty name and may be given more than once. Hand i is dealt from
+// seed+i, so a run is reproducible except with easy seats (random plays) and hard
+// ones (search bounded by time). The exit code is 1 if any hand failed with an
+// engine error or panic.
+//
+// With -tournament, -seats lists the strategies to rank instead (2 to 5 of them)
+// and -hands the number of deals. Every deal is played five times, shifting the
+// line-up one seat each time, or 5×entrants times when the entrants don't divide
+// the five seats evenly, as they also swap places (see models/tournament.go).
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"leve_up/models"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	hands := flag.Int("hands", 1000, "number of hands to play")
+	seed := flag.Int64("seed", 1, "seed of the first hand")
+	seats := flag.String("seats", models.AIDifficultyNormal, "comma separated difficulty[/personality] per seat, from seat 1")
+	think := flag.Int("think", 0, "hard: search budget per play in ms (0 = AI_THINK_MS)")
+	workers := flag.Int("workers", 0, "hands played in parallel (0 = number of CPUs)")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	tournament := flag.Bool("tournament", false, "rank the -seats strategies against each other")
+	csvPath := flag.String("csv", "", "tournament: also write the ranking as CSV to this file (- for stdout)")
+	verbose := flag.Bool("v", false, "list failed hands and keep the engine's own output")
+	flag.Func("bot", "register an external bot as name=url", func(value string) error {
+		name, url, _ := strings.Cut(value, "=")
+		return models.RegisterExternalBot(name, url)
+	})
+	flag.Parse()
+
+	if *hands <= 0 {
+		fail("-hands must be positive")
+	}
+	if *tournament {
+		runTournament(*seats, *think, *hands, *seed, *workers, *asJSON, *csvPath, *verbose)
+		return
+	}
+	settings, err := parseSeats(*seats, *think)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	out := silenceEngine(*verbose)
+	report := models.Simulate(models.SimulationOptions{
+		Hands:    *hands,
+		BaseSeed: *seed,
+		Seats:    settings,
+		Workers:  *workers,
+	})
+
+	if *asJSON {
+		printJSON(out, report)
+	} else {
+		printSummary(out, report, *verbose)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// silenceEngine discards the engine's log unless verbose and returns where the
+// report goes; 引擎自己的调试输出会淹没报告
+func silenceEngine(verbose bool) io.Writer {
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+	return os.Stdout
+}
+
+func runTournament(spec string, thinkMs, deals int, seed int64, workers int, asJSON bool, csvPath string, verbose bool) {
+	var entrants []models.AISettings
+	for i, part := range strings.Split(spec, ",") {
+		settings, err := parseSettings(part, thinkMs)
+		if err != nil {
+			fail("strategy %d: %v", i+1, err)
+		}
+		entrants = append(entrants, settings)
+	}
+
+	out := silenceEngine(verbose)
+	report, err := models.RunTournament(models.TournamentOptions{
+		Entrants: entrants,
+		Deals:    deals,
+		BaseSeed: seed,
+		Workers:  workers,
+	})
+	if err != nil {
+		fail("%v", err)
+	}
+
+	if csvPath != "" {
+		w := out
+		if csvPath != "-" {
+			f, err := os.Create(csvPath)
+			if err != nil {
+				fail("%v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := writeRankingCSV(w, report); err != nil {
+			fail("failed to write CSV: %v", err)
+		}
+	}
+	switch {
+	case asJSON:
+		printJSON(out, report)
+	case csvPath != "-":
+		printRanking(out, report, verbose)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseSeats turns "hard/aggressive,normal" into settings for seats 1-5
+func parseSeats(spec string, thinkMs int) (map[int]models.AISettings, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) > 5 {
+		return nil, fmt.Errorf("-seats lists %d seats, at most 5", len(parts))
+	}
+
+	settings := make(map[int]models.AISettings, 5)
+	var last models.AISettings
+	for seat := 1; seat <= 5; seat++ {
+		if seat <= len(parts) {
+			s, err := parseSettings(parts[seat-1], thinkMs)
+			if err != nil {
+				return nil, fmt.Errorf("seat %d: %v", seat, err)
+			}
+			last = s
+		}
+		settings[seat] = last
+	}
+	return settings, nil
+}
+
+// parseSettings turns "difficulty[/personality]" into settings
+func parseSettings(spec string, thinkMs int) (models.AISettings, error) {
+	difficulty, personality, _ := strings.Cut(strings.TrimSpace(spec), "/")
+	if !models.IsValidAIDifficulty(difficulty) {
+		return models.AISettings{}, fmt.Errorf("unknown difficulty %q", difficulty)
+	}
+	if !models.IsValidAIPersonality(personality) {
+		return models.AISettings{}, fmt.Errorf("unknown personality %q", personality)
+	}
+	return models.AISettings{Difficulty: difficulty, Personality: personality, ThinkMs: thinkMs}, nil
+}
+
+func printJSON(w io.Writer, v interface{}) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fail("failed to encode report: %v", err)
+	}
+}
+
+func printSummary(w io.Writer, r *models.SimulationReport, verbose bool) {
+	fmt.Fprintf(w, "hands: %d played, %d failed (seeds %d-%d) in %s\n",
+		r.Hands, r.Failed, r.BaseSeed, r.BaseSeed+int64(r.Hands+r.Failed)-1, r.Duration.Round(1e6))
+	fmt.Fprintf(w, "seats: 1=%s 2=%s 3=%s 4=%s 5=%s\n", r.Seats[1], r.Seats[2], r.Seats[3], r.Seats[4], r.Seats[5])
+	fmt.Fprintf(w, "dealer side won %d, defenders won %d\n", r.HostWins, r.GuestWins)
+	fmt.Fprintf(w, "average defender points: %.1f\n", r.AveragePoints)
+	fmt.Fprintf(w, "1v4 hands: %d (%.1f%%)\n", r.SoloHands, percent(r.SoloRate))
+	fmt.Fprintf(w, "bottom taken (抠底): %d (%.1f%%)\n", r.BottomTaken, percent(r.BottomRate))
+	fmt.Fprintf(w, "refused plays: %d\n\n", r.Violations)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "strategy\tseat-hands\twin%\tdealer side\twin%\tdefending\twin%\trefused\t")
+	for _, s := range r.Strategies {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%.1f\t%d\t%.1f\t%d\t\n", s.Strategy, s.Hands, percent(s.WinRate),
+			s.DealerHands, percent(ratio(s.DealerWins, s.DealerHands)),
+			s.DefenderHands, percent(ratio(s.DefenderWins, s.DefenderHands)), s.Violations)
+	}
+	tw.Flush()
+
+	printErrors(w, r.Errors, verbose)
+}
+
+func printRanking(w io.Writer, r *models.TournamentReport, verbose bool) {
+	fmt.Fprintf(w, "tournament: %d deals x %d rotations, %d hands played, %d failed (seeds %d-%d) in %s\n",
+		r.Deals, r.Rotations, r.Hands, r.Failed, r.BaseSeed, r.BaseSeed+int64(r.Deals)-1, r.Duration.Round(1e6))
+	fmt.Fprintf(w, "average seat win rate %.1f%% (rating 1500), intervals clustered by deal\n\n", percent(r.BaselineWinRate))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "#\tstrategy\tseat-hands\twin%\t95% CI\trating\t95% CI\tdealer win%\tdefending win%\trefused\t")
+	for _, e := range r.Ranking {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%.1f\t%.1f-%.1f\t%.0f\t%.0f-%.0f\t%.1f\t%.1f\t%d\t\n", e.Rank, e.Strategy, e.Hands,
+			percent(e.WinRate), percent(e.WinRateLow), percent(e.WinRateHigh), e.Rating, e.RatingLow, e.RatingHigh,
+			percent(ratio(e.DealerWins, e.DealerHands)), percent(ratio(e.DefenderWins, e.DefenderHands)), e.Violations)
+	}
+	tw.Flush()
+
+	// 相邻两名的置信区间不重叠才算分出高下
+	for i := 0; i+1 < len(r.Ranking); i++ {
+		a, b := r.Ranking[i], r.Ranking[i+1]
+		if a.WinRateLow > b.WinRateHigh {
+			fmt.Fprintf(w, "%s is stronger than %s (95%% intervals do not overlap)\n", a.Strategy, b.Strategy)
+		} else {
+			fmt.Fprintf(w, "%s and %s are not separated yet, play more deals\n", a.Strategy, b.Strategy)
+		}
+	}
+
+	printErrors(w, r.Errors, verbose)
+}
+
+func writeRankingCSV(w io.Writer, r *models.TournamentReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"rank", "strategy", "hands", "wins", "win_rate", "win_rate_low", "win_rate_high",
+		"rating", "rating_low", "rating_high", "dealer_hands", "dealer_wins", "defender_hands", "defender_wins", "violations"})
+	for _, e := range r.Ranking {
+		cw.Write([]string{
+			strconv.Itoa(e.Rank), e.Strategy, strconv.Itoa(e.Hands), strconv.Itoa(e.Wins),
+			formatFloat(e.WinRate), formatFloat(e.WinRateLow), formatFloat(e.WinRateHigh),
+			formatFloat(e.Rating), formatFloat(e.RatingLow), formatFloat(e.RatingHigh),
+			strconv.Itoa(e.DealerHands), strconv.Itoa(e.DealerWins),
+			strconv.Itoa(e.DefenderHands), strconv.Itoa(e.DefenderWins), strconv.Itoa(e.Violations),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+func printErrors(w io.Writer, errors []string, verbose bool) {
+	if len(errors) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	for i, e := range errors {
+		if !verbose && i == 5 {
+			fmt.Fprintf(w, "... %d more failed hands, run with -v or -json to see all\n", len(errors)-i)
+			break
+		}
+		if !verbose {
+			e, _, _ = strings.Cut(e, "\n") // 不带调用栈
+		}
+		fmt.Fprintln(w, "failed:", e)
+	}
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func percent(rate float64) float64 {
+	return rate * 100
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "simulate: "+format+"\n", args...)
+	os.Exit(2)
+}
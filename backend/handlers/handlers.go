@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"leve_up/middleware"
 	"leve_up/models"
@@ -10,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -63,6 +66,72 @@ func parseCardIndices(data map[string]string, keys ...string) ([]int, error) {
 	return nil, fmt.Errorf("no card indices provided")
 }
 
+// parseSeed reads the optional deal seed from a create form
+// Returns nil when no seed is given, so the server draws one.
+func parseSeed(data map[string]string) (*int64, error) {
+	raw := strings.TrimSpace(data["seed"])
+	if raw == "" {
+		return nil, nil
+	}
+	seed, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed: %s", raw)
+	}
+	return &seed, nil
+}
+
+// maxThinkMs caps the hard AI's think time per play, which delays the seat's turn;
+// it thinks on a snapshot of the table, not under the table lock
+const maxThinkMs = 10000
+
+// parseAISettings reads the AI seats' settings from a single player form
+// difficulty / personality / think_ms apply to every AI seat, difficulty_N /
+// personality_N / think_ms_N (N = 2..5) to seat N only. Returns nil when nothing
+// is set, so the defaults apply.
+func parseAISettings(data map[string]string) (map[int]models.AISettings, error) {
+	settings := make(map[int]models.AISettings)
+	for seat := 2; seat <= 5; seat++ {
+		var s models.AISettings
+		for _, suffix := range []string{"", fmt.Sprintf("_%d", seat)} {
+			if err := readAISettings(data, suffix, &s); err != nil {
+				return nil, err
+			}
+		}
+		if s != (models.AISettings{}) {
+			settings[seat] = s
+		}
+	}
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	return settings, nil
+}
+
+// readAISettings sets the fields of s found in the form under difficulty /
+// personality / think_ms followed by suffix
+func readAISettings(data map[string]string, suffix string, s *models.AISettings) error {
+	if raw := strings.TrimSpace(data["difficulty"+suffix]); raw != "" {
+		if !models.IsValidAIDifficulty(raw) {
+			return fmt.Errorf("invalid difficulty: %s", raw)
+		}
+		s.Difficulty = raw
+	}
+	if raw := strings.TrimSpace(data["personality"+suffix]); raw != "" {
+		if !models.IsValidAIPersonality(raw) {
+			return fmt.Errorf("invalid personality: %s", raw)
+		}
+		s.Personality = raw
+	}
+	if raw := strings.TrimSpace(data["think_ms"+suffix]); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 || ms > maxThinkMs {
+			return fmt.Errorf("invalid think_ms: %s", raw)
+		}
+		s.ThinkMs = ms
+	}
+	return nil
+}
+
 // Register handles user registration
 func Register(c *gin.Context) {
 	data, ok := middleware.ParseForm(c)
@@ -78,6 +147,11 @@ func Register(c *gin.Context) {
 	username := data["username"]
 	password := data["password"]
 
+	if models.IsReservedUsername(username) {
+		middleware.SendError(c, http.StatusBadRequest, "Username is reserved")
+		return
+	}
+
 	// Check password length
 	if len(password) < 4 {
 		middleware.SendError(c, http.StatusBadRequest, "Password must be at least 4 characters")
@@ -142,7 +216,7 @@ func Login(c *gin.Context) {
 	}
 
 	// Check password (in production, use bcrypt)
-	if user.Password != password {
+	if user.LoginDisabled || user.Password != password {
 		middleware.SendError(c, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
@@ -197,7 +271,18 @@ func CreateGame(c *gin.Context) {
 		gameName = data["name"]
 	}
 
-	game, err := models.CreateGame(gameName, user.ID)
+	// 多人房间的种子由服务器抽取，只有测试服务器允许指定
+	seed, err := parseSeed(data)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	game, err := models.CreateGame(gameName, user.ID, seed)
+	if errors.Is(err, models.ErrFixedSeedNotAllowed) {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		middleware.SendError(c, http.StatusInternalServerError, "Failed to create game")
 		return
@@ -271,6 +356,46 @@ func JoinGame(c *gin.Context) {
 	})
 }
 
+// AddAIPlayerHandler seats an AI (built-in or external bot) in a waiting room
+// Takes difficulty / personality / think_ms like the single player form.
+func AddAIPlayerHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+	gameID := c.Param("id")
+
+	data, ok := middleware.ParseForm(c)
+	if !ok {
+		return
+	}
+
+	var settings models.AISettings
+	if err := readAISettings(data, "", &settings); err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	seat, err := models.AddAIPlayer(gameID, user.ID, settings)
+	if err == models.ErrGameNotFound {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return
+	}
+	if err == models.ErrGameFull {
+		middleware.SendError(c, http.StatusConflict, "Game is full")
+		return
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	game, _ := models.GetGame(gameID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"seat":    seat,
+		"game":    game,
+	})
+}
+
 // PlayCard handles playing a card or multiple cards
 func PlayCard(c *gin.Context) {
 	user, _ := middleware.GetCurrentUser(c)
@@ -289,34 +414,11 @@ func PlayCard(c *gin.Context) {
 
 	result, err := models.PlayCardsGame(gameID, user.ID, cardIndices)
 	if err != nil {
-		fmt.Printf("PlayCardsGame error: %v, cardIndices: %v\n", err, cardIndices)
+		log.Printf("PlayCardsGame error: %v, cardIndices: %v", err, cardIndices)
 		middleware.SendError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Log the play action
-	game, _ := models.GetGame(gameID)
-	if game != nil {
-		playerSeat := 0
-		for i, id := range game.PlayerIDs {
-			if id == user.ID {
-				playerSeat = i + 1
-				break
-			}
-		}
-
-		models.LogGameAction(models.GameActionLogRequest{
-			GameID:     gameID,
-			ActionType: "play_cards",
-			PlayerSeat: playerSeat,
-			PlayerID:   user.ID,
-			ActionData: map[string]interface{}{
-				"cardIndices": cardIndices,
-			},
-			ResultData: result,
-		})
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"result":  result,
@@ -336,7 +438,7 @@ func StartGameHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
+		"table":   table.ViewForUser(user.ID),
 		"message": "游戏开始，请抢庄",
 	})
 }
@@ -373,31 +475,6 @@ func CallFriendHandler(c *gin.Context) {
 		return
 	}
 
-	// Log the call friend action
-	game, _ := models.GetGame(gameID)
-	if game != nil {
-		playerSeat := 0
-		for i, id := range game.PlayerIDs {
-			if id == user.ID {
-				playerSeat = i + 1
-				break
-			}
-		}
-
-		models.LogGameAction(models.GameActionLogRequest{
-			GameID:     gameID,
-			ActionType: "call_friend",
-			PlayerSeat: playerSeat,
-			PlayerID:   user.ID,
-			ActionData: map[string]interface{}{
-				"suit":     suit,
-				"value":    value,
-				"position": position,
-			},
-			ResultData: nil,
-		})
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Friend card called",
@@ -434,39 +511,16 @@ func CallDealerHandler(c *gin.Context) {
 		return
 	}
 
-	// Log the call dealer action
-	game, _ := models.GetGame(gameID)
-	if game != nil {
-		playerSeat := 0
-		for i, id := range game.PlayerIDs {
-			if id == user.ID {
-				playerSeat = i + 1
-				break
-			}
-		}
-
-		models.LogGameAction(models.GameActionLogRequest{
-			GameID:     gameID,
-			ActionType: "call_dealer",
-			PlayerSeat: playerSeat,
-			PlayerID:   user.ID,
-			ActionData: map[string]interface{}{
-				"suit":        suit,
-				"cardIndices": cardIndices,
-			},
-			ResultData: table,
-		})
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
+		"table":   table.ViewForUser(user.ID),
 		"message": "抢庄成功",
 	})
 }
 
 // FlipBottomCardHandler handles flipping a card from the bottom
 func FlipBottomCardHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
 	gameID := c.Param("id")
 
 	table, err := models.FlipBottomCard(gameID)
@@ -477,7 +531,7 @@ func FlipBottomCardHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
+		"table":   table.ViewForUser(user.ID),
 	})
 }
 
@@ -503,33 +557,38 @@ func DiscardBottomCardsHandler(c *gin.Context) {
 		return
 	}
 
-	// Log the discard action
-	game, _ := models.GetGame(gameID)
-	if game != nil {
-		playerSeat := 0
-		for i, id := range game.PlayerIDs {
-			if id == user.ID {
-				playerSeat = i + 1
-				break
-			}
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"table":   table.ViewForUser(user.ID),
+		"message": "扣牌成功",
+	})
+}
 
-		models.LogGameAction(models.GameActionLogRequest{
-			GameID:     gameID,
-			ActionType: "discard_bottom",
-			PlayerSeat: playerSeat,
-			PlayerID:   user.ID,
-			ActionData: map[string]interface{}{
-				"cardIndices": cardIndices,
-			},
-			ResultData: table,
-		})
+// TrusteeshipHandler lets a player hand their seat to the AI (托管) or take it back
+func TrusteeshipHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+	gameID := c.Param("id")
+
+	data, ok := middleware.ParseForm(c)
+	if !ok {
+		return
+	}
+
+	enabled, err := strconv.ParseBool(data["enabled"])
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, "enabled must be true or false")
+		return
+	}
+
+	table, err := models.SetTrusteeship(gameID, user.ID, enabled)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
-		"message": "扣牌成功",
+		"table":   table.ViewForUser(user.ID),
 	})
 }
 
@@ -547,7 +606,19 @@ func CreateSinglePlayerGame(c *gin.Context) {
 		gameName = data["name"]
 	}
 
-	game, err := models.CreateSinglePlayerGame(gameName, user.ID)
+	seed, err := parseSeed(data)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	aiSettings, err := parseAISettings(data)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	game, err := models.CreateSinglePlayerGame(gameName, user.ID, seed, aiSettings)
 	if err != nil {
 		log.Println("CreateSinglePlayerGame error:", err)
 		middleware.SendError(c, http.StatusInternalServerError, err.Error())
@@ -572,21 +643,15 @@ func StartSinglePlayerGame(c *gin.Context) {
 		return
 	}
 
-	// 自动为玩家抢庄（单人模式）
-	table, err = models.AutoCallForDealer(gameID)
-	if err != nil {
-		middleware.SendError(c, http.StatusBadRequest, err.Error())
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
+		"table":   table.ViewForUser(user.ID),
 	})
 }
 
 // AIPlayHandler makes AI players play automatically
 func AIPlayHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
 	gameID := c.Param("id")
 
 	table, err := models.AIPlayTurn(gameID)
@@ -597,7 +662,7 @@ func AIPlayHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"table":   table,
+		"table":   table.ViewForUser(user.ID),
 	})
 }
 
@@ -612,94 +677,106 @@ func GetGameTableHandler(c *gin.Context) {
 		return
 	}
 
+	response := buildTableResponse(table, user.ID)
+	response["success"] = true
+	c.JSON(http.StatusOK, response)
+}
+
+// buildTableResponse builds the table state as seen by the given user
+// Shared by the polling endpoint and the WebSocket snapshot. Only the redacted
+// TableView is used, so other seats' hands never leave the server.
+func buildTableResponse(table *models.GameTable, userID string) gin.H {
+	view := table.ViewForUser(userID)
+
 	players := make([]map[string]interface{}, 0)
 	scores := make(map[int]int)
-	myPosition := 0
-	myHand := make([]models.Card, 0)
-
-	for seat := 1; seat <= 5; seat++ {
-		if hand, ok := table.PlayerHands[seat]; ok {
-			username := fmt.Sprintf("玩家%d", seat)
-			isAI := strings.HasPrefix(hand.UserID, "ai_")
-			if isAI {
-				username = fmt.Sprintf("AI-%d", seat)
-			} else if u, err := models.GetUserByID(hand.UserID); err == nil {
-				username = u.Username
-			}
 
-			playerInfo := map[string]interface{}{
-				"id":        seat,
-				"userId":    hand.UserID,
-				"position":  seat,
-				"username":  username,
-				"isReady":   table.Status != "waiting",
-				"isAI":      isAI,
-				"cardCount": len(hand.Cards),
-				"isFriend":  hand.IsFriend,
-			}
-			players = append(players, playerInfo)
+	for _, seatView := range view.Seats {
+		seat := seatView.SeatNumber
+		username := fmt.Sprintf("玩家%d", seat)
+		isAI := strings.HasPrefix(seatView.UserID, "ai_")
+		if isAI {
+			username = fmt.Sprintf("AI-%d", seat)
+		} else if u, err := models.GetUserByID(seatView.UserID); err == nil {
+			username = u.Username
+		}
 
-			scores[seat] = hand.Score
-			if hand.UserID == user.ID {
-				myPosition = seat
-				myHand = hand.Cards
-			}
+		playerInfo := map[string]interface{}{
+			"id":                seat,
+			"userId":            seatView.UserID,
+			"position":          seat,
+			"username":          username,
+			"isReady":           view.Status != "waiting",
+			"isAI":              isAI,
+			"cardCount":         seatView.CardCount,
+			"isFriend":          seatView.IsFriend,
+			"trusteeship":       seatView.Trusteeship,
+			"trusteeshipReason": seatView.TrusteeshipReason,
 		}
+		players = append(players, playerInfo)
+
+		scores[seat] = seatView.Score
 	}
 
-	currentTrick := make([]map[string]interface{}, 0, len(table.CurrentTrick))
-	for _, trick := range table.CurrentTrick {
+	currentTrick := make([]map[string]interface{}, 0, len(view.TrickPlays))
+	for _, play := range view.TrickPlays {
 		currentTrick = append(currentTrick, map[string]interface{}{
-			"playerId": trick.Seat,
-			"cards":    []models.Card{trick.Card},
+			"playerId": play.Seat,
+			"cards":    play.Cards,
 		})
 	}
 
 	dealerTeam := make([]int, 0, 2)
-	if table.DealerSeat > 0 {
-		dealerTeam = append(dealerTeam, table.DealerSeat)
+	if view.DealerSeat > 0 {
+		dealerTeam = append(dealerTeam, view.DealerSeat)
 	}
-	if table.FriendRevealed && table.FriendSeat > 0 {
-		dealerTeam = append(dealerTeam, table.FriendSeat)
+	if view.FriendRevealed && view.FriendSeat > 0 {
+		dealerTeam = append(dealerTeam, view.FriendSeat)
 	}
 
 	var trumpSuit interface{}
-	if table.TrumpSuit != "" {
-		trumpSuit = table.TrumpSuit
+	if view.TrumpSuit != "" {
+		trumpSuit = view.TrumpSuit
 	}
 
-	gamePayload := map[string]interface{}{
-		"id":           table.GameID,
-		"status":       table.Status,
-		"currentLevel": table.CurrentLevel,
-		"currentPlayer": table.CurrentPlayer,
-		"dealerTeam":   dealerTeam,
-		"currentTrick": currentTrick,
-		"players":      players,
-		"myHand":       myHand,
-		"myPosition":   myPosition,
-		"trumpSuit":    trumpSuit,
-		"bottomCards":  table.BottomCards,
-		"scores":       scores,
+	bottomCards := view.BottomCards
+	if bottomCards == nil {
+		bottomCards = make([]models.Card, 0)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"game":    gamePayload,
+	gamePayload := map[string]interface{}{
+		"id":            view.GameID,
+		"status":        view.Status,
+		"currentLevel":  view.CurrentLevel,
+		"currentPlayer": view.CurrentPlayer,
+		"dealerTeam":    dealerTeam,
+		"currentTrick":  currentTrick,
+		"players":       players,
+		"myHand":        view.MyHand,
+		"myPosition":    view.ViewerSeat,
+		"trumpSuit":     trumpSuit,
+		"bottomCards":   bottomCards,
+		"scores":        scores,
+		"deadline":      view.Deadline,
+	}
+
+	return gin.H{
+		"game":  gamePayload,
+		"table": view,
 		// Backward-compatible fields
-		"gameId":         table.GameID,
-		"status":         table.Status,
-		"currentLevel":   table.CurrentLevel,
-		"trumpSuit":      table.TrumpSuit,
-		"hostCalledCard": table.HostCalledCard,
-		"friendRevealed": table.FriendRevealed,
-		"friendSeat":     table.FriendSeat,
-		"currentPlayer":  table.CurrentPlayer,
-		"currentTrick":   table.CurrentTrick,
-		"lastPlay":       table.LastPlay,
+		"gameId":         view.GameID,
+		"status":         view.Status,
+		"currentLevel":   view.CurrentLevel,
+		"trumpSuit":      view.TrumpSuit,
+		"hostCalledCard": view.HostCalledCard,
+		"friendRevealed": view.FriendRevealed,
+		"friendSeat":     view.FriendSeat,
+		"currentPlayer":  view.CurrentPlayer,
+		"currentTrick":   view.CurrentTrick,
+		"lastPlay":       view.LastPlay,
 		"players":        players,
-		"myHand":         myHand,
-	})
+		"myHand":         view.MyHand,
+	}
 }
 
 // Page handlers
@@ -795,9 +872,20 @@ func ReplayPageHandler(c *gin.Context) {
 }
 
 // GetGameReplayHandler retrieves the replay data for a specific game
+// With ?step=N it rebuilds the table after the first N logged actions instead.
 func GetGameReplayHandler(c *gin.Context) {
 	gameID := c.Param("id")
 
+	if stepStr := strings.TrimSpace(c.Query("step")); stepStr != "" {
+		step, err := strconv.Atoi(stepStr)
+		if err != nil {
+			middleware.SendError(c, http.StatusBadRequest, "step must be a number")
+			return
+		}
+		getReplayStep(c, gameID, step)
+		return
+	}
+
 	replay, err := models.GetGameReplay(gameID)
 	if err != nil {
 		middleware.SendError(c, http.StatusNotFound, "Replay not found for this game")
@@ -810,10 +898,313 @@ func GetGameReplayHandler(c *gin.Context) {
 	})
 }
 
+// getReplayStep responds with the table rebuilt from the action log at a step
+// Finished games are shown with every hand open; while a game is still running,
+// players only get their own seat's view of past states.
+func getReplayStep(c *gin.Context, gameID string, step int) {
+	user, _ := middleware.GetCurrentUser(c)
+
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return
+	}
+
+	table, total, err := models.BuildTableAtStep(gameID, step)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var tableData interface{}
+	if table != nil {
+		if game.Status == "finished" {
+			tableData = table.PostGameView()
+		} else {
+			tableData = table.ViewForUser(user.ID)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"step":       step,
+		"totalSteps": total,
+		"table":      tableData,
+	})
+}
+
+// GetReplayFramesHandler returns the replay frames from..to (default: all)
+// Each frame is the table after one action with derived data (running defender
+// points, current trick winner); tricks lists where every trick starts and ends.
+func GetReplayFramesHandler(c *gin.Context) {
+	replay, ok := loadReplay(c)
+	if !ok {
+		return
+	}
+	sendReplayFrames(c, replay)
+}
+
+// sendReplayFrames answers a frames request (?from=&to=) from a built replay
+func sendReplayFrames(c *gin.Context, replay *models.Replay) {
+	from, to := 1, replay.TotalSteps
+	for key, value := range map[string]*int{"from": &from, "to": &to} {
+		if raw := strings.TrimSpace(c.Query(key)); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				middleware.SendError(c, http.StatusBadRequest, key+" must be a number")
+				return
+			}
+			*value = n
+		}
+	}
+	if from < 1 || to > replay.TotalSteps || from > to+1 {
+		middleware.SendError(c, http.StatusBadRequest, fmt.Sprintf("steps must be within 1..%d", replay.TotalSteps))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"open":       replay.Open,
+		"totalSteps": replay.TotalSteps,
+		"tricks":     replay.Tricks,
+		"frames":     replay.Frames[from-1 : to],
+	})
+}
+
+// SeekReplayHandler returns one replay frame, by ?step=N (actions applied) or
+// ?trick=N (the table once trick N is complete, 0 = before the first lead)
+func SeekReplayHandler(c *gin.Context) {
+	replay, ok := loadReplay(c)
+	if !ok {
+		return
+	}
+	sendReplaySeek(c, replay)
+}
+
+// sendReplaySeek answers a seek request (?step= or ?trick=) from a built replay
+func sendReplaySeek(c *gin.Context, replay *models.Replay) {
+	var frame *models.ReplayFrame
+	var err error
+	stepStr, trickStr := strings.TrimSpace(c.Query("step")), strings.TrimSpace(c.Query("trick"))
+	switch {
+	case stepStr != "":
+		var step int
+		if step, err = strconv.Atoi(stepStr); err == nil {
+			frame, err = replay.FrameAt(step)
+		}
+	case trickStr != "":
+		var trick int
+		if trick, err = strconv.Atoi(trickStr); err == nil {
+			frame, err = replay.TrickFrame(trick)
+		}
+	default:
+		err = fmt.Errorf("step or trick is required")
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"open":       replay.Open,
+		"totalSteps": replay.TotalSteps,
+		"tricks":     replay.Tricks,
+		"frame":      frame,
+	})
+}
+
+// loadReplay rebuilds the replay of the game in the URL
+// Finished games are replayed open (all five hands); while a game is running,
+// players only get their own seat's view of past states.
+func loadReplay(c *gin.Context) (*models.Replay, bool) {
+	user, _ := middleware.GetCurrentUser(c)
+	gameID := c.Param("id")
+
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return nil, false
+	}
+
+	open := game.Status == "finished"
+	view := func(table *models.GameTable) *models.TableView {
+		return table.ViewForUser(user.ID)
+	}
+	if open {
+		view = (*models.GameTable).PostGameView
+	}
+
+	replay, err := models.BuildReplay(gameID, view, open)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return replay, true
+}
+
+// CreateReplayShareHandler makes a share link for a finished game
+// Only players of the game (or its host) can share it; anonymize=true shows
+// seat labels instead of usernames.
+func CreateReplayShareHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+	gameID := c.Param("id")
+
+	game, ok := loadSharableGame(c, gameID, user.ID)
+	if !ok {
+		return
+	}
+
+	data, ok := middleware.ParseForm(c)
+	if !ok {
+		return
+	}
+	anonymize := false
+	if raw := strings.TrimSpace(data["anonymize"]); raw != "" {
+		var err error
+		if anonymize, err = strconv.ParseBool(raw); err != nil {
+			middleware.SendError(c, http.StatusBadRequest, "anonymize must be true or false")
+			return
+		}
+	}
+
+	share, err := models.CreateReplayShare(game.ID, user.ID, anonymize)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"share":   share,
+		"url":     "/api/share/" + share.Token,
+	})
+}
+
+// ListReplaySharesHandler lists the share links the user made of a game, revoked ones included
+func ListReplaySharesHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+
+	shares, err := models.ListReplayShares(c.Param("id"), user.ID)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to list share links")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"shares":  shares,
+	})
+}
+
+// RevokeReplayShareHandler stops a share link from working
+func RevokeReplayShareHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+
+	err := models.RevokeReplayShare(c.Param("id"), c.Param("token"), user.ID)
+	if errors.Is(err, models.ErrShareNotFound) {
+		middleware.SendError(c, http.StatusNotFound, "Share link not found")
+		return
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to revoke share link")
+		return
+	}
+
+	middleware.SendSuccess(c, "Share link revoked")
+}
+
+// loadSharableGame returns the game if it is finished and the user played in or hosted it
+func loadSharableGame(c *gin.Context, gameID, userID string) (*models.GameState, bool) {
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return nil, false
+	}
+	if game.Status != "finished" {
+		middleware.SendError(c, http.StatusForbidden, "对局结束后才能分享回放")
+		return nil, false
+	}
+
+	allowed := game.HostID == userID
+	for _, playerID := range game.PlayerIDs {
+		if playerID == userID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		middleware.SendError(c, http.StatusForbidden, "只有参加对局的玩家才能分享回放")
+		return nil, false
+	}
+	return game, true
+}
+
+// GetSharedReplayHandler serves the overview of a shared replay, no login needed
+func GetSharedReplayHandler(c *gin.Context) {
+	replay, ok := loadSharedReplay(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"anonymize":  replay.Anonymize,
+		"players":    replay.Players,
+		"totalSteps": replay.TotalSteps,
+		"tricks":     replay.Tricks,
+	})
+}
+
+// GetSharedReplayFramesHandler is GetReplayFramesHandler for a share token
+func GetSharedReplayFramesHandler(c *gin.Context) {
+	if replay, ok := loadSharedReplay(c); ok {
+		sendReplayFrames(c, replay.Replay)
+	}
+}
+
+// SeekSharedReplayHandler is SeekReplayHandler for a share token
+func SeekSharedReplayHandler(c *gin.Context) {
+	if replay, ok := loadSharedReplay(c); ok {
+		sendReplaySeek(c, replay.Replay)
+	}
+}
+
+// loadSharedReplay rebuilds the replay of the share token in the URL
+// Unknown and revoked tokens get the same 404.
+func loadSharedReplay(c *gin.Context) (*models.SharedReplay, bool) {
+	share, err := models.GetReplayShare(c.Param("token"))
+	if errors.Is(err, models.ErrShareNotFound) {
+		middleware.SendError(c, http.StatusNotFound, "Share link not found")
+		return nil, false
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to load share link")
+		return nil, false
+	}
+
+	replay, err := models.BuildSharedReplay(share)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to build replay")
+		return nil, false
+	}
+	return replay, true
+}
+
 // GetGameActionsHandler retrieves all action logs for a specific game
+// The log holds the whole deal and the seed, so it is only served once the game is over.
 func GetGameActionsHandler(c *gin.Context) {
 	gameID := c.Param("id")
 
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return
+	}
+	if game.Status != "finished" {
+		middleware.SendError(c, http.StatusForbidden, "对局结束后才能查看动作日志")
+		return
+	}
+
 	actions, err := models.GetGameActionLogs(gameID)
 	if err != nil {
 		middleware.SendError(c, http.StatusInternalServerError, "Failed to retrieve game actions")
@@ -826,3 +1217,257 @@ func GetGameActionsHandler(c *gin.Context) {
 		"count":   len(actions),
 	})
 }
+
+// ListReplaysHandler searches the replays of finished games, newest first
+// Filters: player, dealer (user id or "me"), won (with player), from/to
+// (YYYY-MM-DD, to is inclusive, or RFC3339), winner (host|guest), solo, bottom
+// (抠底), minScore/maxScore, level. Pages by ?cursor= (nextCursor) and ?limit=.
+func ListReplaysHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+	userParam := func(key string) string {
+		value := strings.TrimSpace(c.Query(key))
+		if value == "me" && user != nil {
+			return user.ID
+		}
+		return value
+	}
+
+	filter := models.ReplayFilter{
+		PlayerID:   userParam("player"),
+		DealerID:   userParam("dealer"),
+		WinnerTeam: strings.TrimSpace(c.Query("winner")),
+		Level:      strings.ToUpper(strings.TrimSpace(c.Query("level"))),
+		Cursor:     strings.TrimSpace(c.Query("cursor")),
+	}
+	if filter.WinnerTeam != "" && filter.WinnerTeam != "host" && filter.WinnerTeam != "guest" {
+		middleware.SendError(c, http.StatusBadRequest, "winner must be host or guest")
+		return
+	}
+
+	for key, value := range map[string]**bool{"won": &filter.PlayerWon, "solo": &filter.SoloMode, "bottom": &filter.Bottom} {
+		if raw := strings.TrimSpace(c.Query(key)); raw != "" {
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				middleware.SendError(c, http.StatusBadRequest, key+" must be true or false")
+				return
+			}
+			*value = &b
+		}
+	}
+	if filter.PlayerWon != nil && filter.PlayerID == "" {
+		middleware.SendError(c, http.StatusBadRequest, "won needs player")
+		return
+	}
+
+	for key, value := range map[string]**int{"minScore": &filter.MinScore, "maxScore": &filter.MaxScore} {
+		if raw := strings.TrimSpace(c.Query(key)); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				middleware.SendError(c, http.StatusBadRequest, key+" must be a number")
+				return
+			}
+			*value = &n
+		}
+	}
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > models.MaxReplayPageSize {
+			middleware.SendError(c, http.StatusBadRequest, fmt.Sprintf("limit must be within 1..%d", models.MaxReplayPageSize))
+			return
+		}
+		filter.Limit = n
+	}
+
+	for key, value := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		raw := strings.TrimSpace(c.Query(key))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			day, dayErr := time.Parse("2006-01-02", raw)
+			if dayErr != nil {
+				middleware.SendError(c, http.StatusBadRequest, key+" must be YYYY-MM-DD or RFC3339")
+				return
+			}
+			t = day
+			if key == "to" {
+				t = day.AddDate(0, 0, 1) // 按天查询时包含当天
+			}
+		}
+		*value = &t
+	}
+
+	page, err := models.SearchGameReplays(filter)
+	if errors.Is(err, models.ErrInvalidReplayCursor) {
+		middleware.SendError(c, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to search replays")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"replays":    page.Replays,
+		"count":      len(page.Replays),
+		"nextCursor": page.NextCursor,
+	})
+}
+
+// GetGameAnalysisHandler returns the post-game analysis of a finished game
+// The first request starts the analysis and answers 202 with status "running";
+// ask again until it is "done". ?min=N lists the plays that cost at least N
+// points (default models.DefaultMistakeLoss), ?all=true adds every analysed play.
+// Only card plays are analysed, not the bid, the discard or the friend call.
+func GetGameAnalysisHandler(c *gin.Context) {
+	gameID := c.Param("id")
+
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return
+	}
+	if game.Status != "finished" {
+		middleware.SendError(c, http.StatusForbidden, "对局结束后才能分析")
+		return
+	}
+
+	minLoss := models.DefaultMistakeLoss
+	if raw := strings.TrimSpace(c.Query("min")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			middleware.SendError(c, http.StatusBadRequest, "min must be a non-negative number")
+			return
+		}
+		minLoss = n
+	}
+
+	analysis, err := models.GetGameAnalysis(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to get analysis")
+		return
+	}
+	if analysis == nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"status":  "running",
+		})
+		return
+	}
+	if analysis.Error != "" {
+		middleware.SendError(c, http.StatusUnprocessableEntity, "Analysis failed: "+analysis.Error)
+		return
+	}
+
+	response := gin.H{
+		"success":   true,
+		"status":    "done",
+		"gameId":    gameID,
+		"minLoss":   minLoss,
+		"analysed":  len(analysis.Plays),
+		"points":    analysis.Points,
+		"mistakes":  analysis.Mistakes(minLoss),
+		"seats":     analysis.Seats(minLoss),
+		"duration":  analysis.Duration,
+		"createdAt": analysis.CreatedAt,
+	}
+	if c.Query("all") == "true" {
+		response["plays"] = analysis.Plays
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportGameRecordHandler downloads a finished game as a game record (README "对局记录")
+// The record holds the whole deal, so like the action log it is only served once the game is over.
+func ExportGameRecordHandler(c *gin.Context) {
+	gameID := c.Param("id")
+
+	game, err := models.GetGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, "Game not found")
+		return
+	}
+	if game.Status != "finished" {
+		middleware.SendError(c, http.StatusForbidden, "对局结束后才能导出对局记录")
+		return
+	}
+
+	record, err := models.ExportGameRecord(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to export game: "+err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="game-%s.json"`, gameID))
+	c.JSON(http.StatusOK, record)
+}
+
+// maxRecordSize bounds an uploaded game record
+const maxRecordSize = 1 << 20
+
+// ImportGameRecordHandler stores a game record as a finished game of the current user
+// The body is the record itself. It is played through the rules engine first
+// and refused if any bid, discard, friend call or play breaks the rules, or if
+// the recorded result is not what the rules give.
+func ImportGameRecordHandler(c *gin.Context) {
+	user, _ := middleware.GetCurrentUser(c)
+
+	var record models.GameRecord
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxRecordSize))
+	if err := decoder.Decode(&record); err != nil {
+		middleware.SendError(c, http.StatusBadRequest, "Invalid game record: "+err.Error())
+		return
+	}
+
+	gameID, result, err := models.ImportGameRecord(&record, user.ID)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"gameId":  gameID,
+		"result":  result,
+	})
+}
+
+// RedealGameHandler deals a finished game again from its revealed seed
+// The response includes the seed hash published at creation and whether the
+// re-deal matches the logged deal, so players can verify the shuffle.
+func RedealGameHandler(c *gin.Context) {
+	gameID := c.Param("id")
+
+	result, err := models.RedealGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"redeal":  result,
+	})
+}
+
+// GetMatchHandler returns a match: seats, carried levels, finished hands and the champion
+func GetMatchHandler(c *gin.Context) {
+	matchID := c.Param("id")
+
+	match, err := models.GetMatch(matchID)
+	if err == models.ErrMatchNotFound {
+		middleware.SendError(c, http.StatusNotFound, "Match not found")
+		return
+	}
+	if err != nil {
+		middleware.SendError(c, http.StatusInternalServerError, "Failed to get match")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"match":   match,
+	})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"leve_up/middleware"
+	"leve_up/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is the time allowed to write a message to the client
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is the time allowed to read the next pong from the client
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be shorter than wsPongWait
+	wsPingPeriod = (wsPongWait * 9) / 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkWebSocketOrigin,
+}
+
+// wsAllowedOrigins are the frontend origins besides the server's own host
+var wsAllowedOrigins = map[string]bool{}
+
+// SetWebSocketOrigins sets the frontend origins (scheme://host[:port]) allowed to
+// open a game WebSocket, e.g. the dev server at http://localhost:5173.
+func SetWebSocketOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed[strings.ToLower(origin)] = true
+		}
+	}
+	wsAllowedOrigins = allowed
+}
+
+// checkWebSocketOrigin refuses handshakes from other sites: the socket accepts the
+// login cookie, which a browser sends along from any page (cross-site WebSocket
+// hijacking). Clients without an Origin header are not browsers and must bring
+// their own token.
+func checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return wsAllowedOrigins[strings.ToLower(u.Scheme+"://"+u.Host)]
+}
+
+// GameWebSocketHandler streams table updates for a game
+// Browsers cannot set the Authorization header on a WebSocket handshake, so the
+// token may also be passed as ?token=... or via the login cookie.
+// On connect the client receives a "snapshot" message with its own view of the
+// table, then one message per game event.
+func GameWebSocketHandler(c *gin.Context) {
+	gameID := c.Param("id")
+
+	user, ok := resolveOptionalUser(c)
+	if !ok {
+		if token := c.Query("token"); token != "" {
+			user, ok = tryGetUserFromToken(token)
+		}
+	}
+	if !ok {
+		middleware.SendError(c, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	// Subscribe before reading the table for the snapshot, so no event is lost in
+	// between; an event already in the snapshot is just sent again
+	sub := models.SubscribeGameEvents(gameID)
+	defer sub.Close()
+
+	table, err := models.GetTableGame(gameID)
+	if err != nil {
+		middleware.SendError(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	seat := 0
+	for s, hand := range table.PlayerHands {
+		if hand.UserID == user.ID {
+			seat = s
+			break
+		}
+	}
+
+	// Seated players take their seat back from trusteeship when they reconnect,
+	// and go into trusteeship if they stay away
+	if seat > 0 {
+		if reconnected, err := models.PlayerConnected(gameID, user.ID); err == nil {
+			table = reconnected
+		}
+		defer models.PlayerDisconnected(gameID, user.ID)
+	}
+
+	snapshot := buildTableResponse(table, user.ID)
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := conn.WriteJSON(gin.H{"type": "snapshot", "gameId": gameID, "seat": seat, "data": snapshot}); err != nil {
+		return
+	}
+
+	// Reader: the protocol is push-only, we only need to process control frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			return nil
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evt, ok := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// Dropped for lagging behind; the client should reconnect for a fresh snapshot
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "resync required"))
+				return
+			}
+			if err := conn.WriteJSON(evt.ForSeat(seat)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
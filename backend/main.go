@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/cors"
@@ -19,6 +20,13 @@ func main() {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	// Recover games that were in progress before the restart
+	if recovered, err := models.RecoverActiveGames(); err != nil {
+		log.Println("Warning: failed to recover active games:", err)
+	} else if recovered > 0 {
+		log.Printf("Recovered %d active games", recovered)
+	}
+
 	// Create Gin router
 	r := gin.Default()
 
@@ -38,6 +46,12 @@ func main() {
 	handlers.SetFrontendDistDir(frontendDistDir)
 	r.Static("/assets", filepath.Join(frontendDistDir, "assets"))
 
+	// Game WebSockets accept the login cookie, so only the frontend may open them:
+	// the server's own host, plus FRONTEND_ORIGINS when the frontend is served elsewhere
+	if origins := os.Getenv("FRONTEND_ORIGINS"); origins != "" {
+		handlers.SetWebSocketOrigins(strings.Split(origins, ","))
+	}
+
 	// Public routes
 	r.GET("/", handlers.IndexHandler)
 	r.GET("/login", handlers.LoginPageHandler)
@@ -56,6 +70,19 @@ func main() {
 		api.POST("/login", handlers.Login)
 		api.POST("/logout", handlers.Logout)
 
+		// WebSocket authenticates itself (header, cookie or ?token=)
+		api.GET("/game/:id/ws", handlers.GameWebSocketHandler)
+
+		// Shared replays: the token is the only credential, so every client IP
+		// gets SHARE_RATE_LIMIT requests a minute to guess or rebuild with
+		shared := api.Group("/share")
+		shared.Use(middleware.RateLimit(shareRateLimit()))
+		{
+			shared.GET("/:token", handlers.GetSharedReplayHandler)
+			shared.GET("/:token/frames", handlers.GetSharedReplayFramesHandler)
+			shared.GET("/:token/seek", handlers.SeekSharedReplayHandler)
+		}
+
 		// Protected routes
 		protected := api.Group("")
 		protected.Use(middleware.AuthMiddleware())
@@ -66,6 +93,7 @@ func main() {
 			protected.GET("/game/:id", handlers.GetGame)
 			protected.GET("/game/:id/table", handlers.GetGameTableHandler)
 			protected.POST("/game/:id/join", handlers.JoinGame)
+			protected.POST("/game/:id/add-ai", handlers.AddAIPlayerHandler)
 			protected.POST("/game/:id/start", handlers.StartGameHandler)
 			protected.POST("/game/:id/start-single", handlers.StartSinglePlayerGame)
 			protected.POST("/game/:id/call-friend", handlers.CallFriendHandler)
@@ -74,9 +102,21 @@ func main() {
 			protected.POST("/game/:id/discard-bottom", handlers.DiscardBottomCardsHandler)
 			protected.POST("/game/:id/play", handlers.PlayCard)
 			protected.POST("/game/:id/ai-play", handlers.AIPlayHandler)
+			protected.POST("/game/:id/trusteeship", handlers.TrusteeshipHandler)
 			// Replay APIs
 			protected.GET("/game/:id/replay", handlers.GetGameReplayHandler)
+			protected.GET("/game/:id/replay/frames", handlers.GetReplayFramesHandler)
+			protected.GET("/game/:id/replay/seek", handlers.SeekReplayHandler)
+			protected.POST("/game/:id/share", handlers.CreateReplayShareHandler)
+			protected.GET("/game/:id/shares", handlers.ListReplaySharesHandler)
+			protected.DELETE("/game/:id/share/:token", handlers.RevokeReplayShareHandler)
 			protected.GET("/game/:id/actions", handlers.GetGameActionsHandler)
+			protected.GET("/game/:id/analysis", handlers.GetGameAnalysisHandler)
+			protected.GET("/replays", handlers.ListReplaysHandler)
+			protected.GET("/game/:id/deal", handlers.RedealGameHandler)
+			protected.GET("/game/:id/export", handlers.ExportGameRecordHandler)
+			protected.POST("/games/import", handlers.ImportGameRecordHandler)
+			protected.GET("/match/:id", handlers.GetMatchHandler)
 		}
 	}
 
@@ -93,3 +133,11 @@ func main() {
 	log.Println("Server starting on :8080")
 	r.Run(":8080")
 }
+
+// shareRateLimit is the per-IP limit of the public share routes, requests a minute
+func shareRateLimit() int {
+	if n, err := strconv.Atoi(os.Getenv("SHARE_RATE_LIMIT")); err == nil && n > 0 {
+		return n
+	}
+	return 60
+}
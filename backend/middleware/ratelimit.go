@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit lets each client IP make at most perMinute requests a minute
+// The count starts over every minute, so the map of clients never outgrows the
+// callers of the last minute. Requests over the limit get 429.
+func RateLimit(perMinute int) gin.HandlerFunc {
+	var mu sync.Mutex
+	window := time.Now().Truncate(time.Minute)
+	counts := make(map[string]int)
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+
+		mu.Lock()
+		if current := now.Truncate(time.Minute); current.After(window) {
+			window = current
+			counts = make(map[string]int)
+		}
+		counts[ip]++
+		over := counts[ip] > perMinute
+		retry := window.Add(time.Minute).Sub(now)
+		mu.Unlock()
+
+		if over {
+			c.Header("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			SendError(c, http.StatusTooManyRequests, "Too many requests")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
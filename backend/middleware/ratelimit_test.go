@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", RateLimit(2), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := get("10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("request %d: %d", i+1, code)
+		}
+	}
+	if code := get("10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("third request: %d, want 429", code)
+	}
+	// 别的地址不受影响
+	if code := get("10.0.0.2"); code != http.StatusOK {
+		t.Errorf("other client: %d", code)
+	}
+}
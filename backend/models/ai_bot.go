@@ -0,0 +1,179 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// External bots: AIs running in their own process, reached over a local HTTP
+// callback (see README "外部机器人协议"). Each bot is registered as a difficulty
+// name, so any seat whose settings name it (single player seats, bot seats added
+// to a room, cmd/simulate) is played by it through the Strategy interface.
+//
+// For every play the engine POSTs a BotRequest to the bot's URL and expects a
+// BotResponse within the seat's think time. If the bot cannot be reached, times
+// out, or answers with a play the rules refuse, the seat plays the built-in
+// AIPlayer's choice instead, so a broken bot never stalls a table. The bot is
+// asked on a copy of the table without holding its lock (see AIPlayTurn and
+// expireTurn), and the play is checked again when it is made.
+// Only plays are delegated: calling the dealer, burying the bottom and calling
+// the friend stay with the built-in AI.
+
+// BotRequest is what an external bot is asked to decide
+type BotRequest struct {
+	Type   string `json:"type"` // always "play" for now
+	GameID string `json:"gameId"`
+	Seat   int    `json:"seat"`
+	// View is the table as the seat sees it (own hand in myHand, others as counts)
+	View *TableView `json:"view"`
+	// LegalPlays lists plays the rules accept as indices into view.myHand; throws
+	// and tractors are not listed when leading but may still be played
+	LegalPlays [][]int `json:"legalPlays"`
+	TimeoutMs  int     `json:"timeoutMs"` // the bot's answer is ignored after this long
+}
+
+// BotResponse is an external bot's decision
+type BotResponse struct {
+	CardIndices []int `json:"cardIndices"`
+}
+
+// maxBotLegalPlays bounds the legal plays sent to a bot
+const maxBotLegalPlays = 500
+
+// defaultBotTimeoutMs is how long a bot may think when its seat sets no ThinkMs
+var defaultBotTimeoutMs = getEnvInt("BOT_TIMEOUT_MS", 2000)
+
+func init() {
+	// EXTERNAL_BOTS=name=url,name=url registers the bots at startup
+	for _, entry := range strings.Split(getEnv("EXTERNAL_BOTS", ""), ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if err := RegisterExternalBot(name, url); err != nil {
+			log.Printf("Warning: external bot %q not registered: %v", name, err)
+		}
+	}
+}
+
+// RegisterExternalBot registers the bot answering at url under the difficulty name
+// Call it before any game is played (see RegisterStrategy). The built-in
+// difficulties cannot be replaced by a bot.
+func RegisterExternalBot(name, url string) error {
+	name = strings.TrimSpace(name)
+	url = strings.TrimSpace(url)
+	switch {
+	case name == "" || url == "":
+		return fmt.Errorf("bot name and url are required")
+	case name == AIDifficultyEasy || name == AIDifficultyNormal || name == AIDifficultyHard:
+		return fmt.Errorf("%s is a built-in difficulty", name)
+	case !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://"):
+		return fmt.Errorf("bot url must be http(s): %s", url)
+	}
+
+	RegisterStrategy(name, func(settings AISettings) Strategy {
+		fallback := settings
+		fallback.Difficulty = AIDifficultyNormal
+		return externalBotStrategy{
+			name:     name,
+			url:      url,
+			timeout:  botTimeout(settings),
+			fallback: newHeuristicStrategy(fallback),
+		}
+	})
+	return nil
+}
+
+// botTimeout is how long a bot seat may think: its ThinkMs, or BOT_TIMEOUT_MS
+func botTimeout(settings AISettings) time.Duration {
+	ms := settings.ThinkMs
+	if ms <= 0 {
+		ms = defaultBotTimeoutMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// externalBotStrategy asks an external bot, falling back to the built-in AI
+type externalBotStrategy struct {
+	name     string
+	url      string
+	timeout  time.Duration
+	fallback Strategy
+}
+
+func (s externalBotStrategy) DecidePlay(table *GameTable, seat int) []int {
+	hand := table.PlayerHands[seat]
+	plays := legalPlays(table, hand.Cards, nil, maxBotLegalPlays)
+
+	indices, err := s.ask(BotRequest{
+		Type:       "play",
+		GameID:     table.GameID,
+		Seat:       seat,
+		View:       table.ViewFor(seat),
+		LegalPlays: plays,
+		TimeoutMs:  int(s.timeout / time.Millisecond),
+	})
+	if err == nil {
+		err = checkBotPlay(table, seat, indices)
+	}
+	if err != nil {
+		log.Printf("Warning: bot %s (game %s seat %d): %v, playing the built-in AI's choice", s.name, table.GameID, seat, err)
+		return s.fallback.DecidePlay(table, seat)
+	}
+	return indices
+}
+
+// ask posts the request to the bot and decodes its answer
+func (s externalBotStrategy) ask(req BotRequest) ([]int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	client := &http.Client{Timeout: s.timeout}
+	resp, err := client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bot answered %s", resp.Status)
+	}
+
+	var answer BotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid bot response: %w", err)
+	}
+	return answer.CardIndices, nil
+}
+
+// checkBotPlay makes sure a bot's play is one the rules accept (after the throw
+// check, like playCardsGame), so a refused play falls back to the built-in AI
+// instead of failing the turn
+func checkBotPlay(table *GameTable, seat int, indices []int) error {
+	hand := table.PlayerHands[seat].Cards
+	if len(indices) == 0 {
+		return fmt.Errorf("no cards selected")
+	}
+	used := make(map[int]bool, len(indices))
+	for _, idx := range indices {
+		if idx < 0 || idx >= len(hand) || used[idx] {
+			return fmt.Errorf("invalid card index: %d", idx)
+		}
+		used[idx] = true
+	}
+
+	cards := make([]Card, 0, len(indices))
+	for _, idx := range indices {
+		cards = append(cards, hand[idx])
+	}
+	if len(table.TrickPlays) == 0 && len(indices) >= 2 {
+		_, cards, _ = resolveThrow(table, seat, indices)
+	}
+	return validateCardPlay(cards, table)
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestBotAskedWithoutLock lets a bot seat time out and checks that the bot is
+// asked while the table stays unlocked, and that its play is made
+func TestBotAskedWithoutLock(t *testing.T) {
+	store := NewGameStore(nil, nil)
+	useTestStore(t, store)
+
+	const gameID = "bot_test_unlocked"
+	table := playingTestTable(t, gameID, 11)
+	seat := table.CurrentPlayer
+	table.PlayerHands[seat].AI = &AISettings{Difficulty: "test_bot", ThinkMs: 3000}
+	store.PutIfAbsent(table, nil)
+
+	unlocked := make(chan bool, 1)
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req BotRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		// 牌桌锁着的话这里读不到快照
+		read := make(chan struct{})
+		go func() {
+			store.Snapshot(gameID)
+			close(read)
+		}()
+		select {
+		case <-read:
+			unlocked <- true
+		case <-time.After(time.Second):
+			unlocked <- false
+		}
+		json.NewEncoder(w).Encode(BotResponse{CardIndices: req.LegalPlays[0]})
+	}))
+	defer bot.Close()
+	if err := RegisterExternalBot("test_bot", bot.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	expireTurn(gameID, table.Deadline)
+	select {
+	case ok := <-unlocked:
+		if !ok {
+			t.Error("the table was locked while the bot was thinking")
+		}
+	default:
+		t.Fatal("the bot was never asked")
+	}
+	after, _, _ := store.Snapshot(gameID)
+	if len(after.TrickPlays) != 1 || after.TrickPlays[0].Seat != seat {
+		t.Errorf("seat %d did not play: %+v", seat, after.TrickPlays)
+	}
+}
@@ -0,0 +1,321 @@
+package models
+
+// CardMemory is what one seat can know from public play (记牌)
+// Built from the trick history and the current trick: which cards are gone,
+// which seats have shown a void (failed to follow), and the teams.
+// The seat's own hand (and the bottom, for the dealer who buried it) counts as
+// seen, so Unseen tells how many copies may still be in other hands.
+type CardMemory struct {
+	Seat       int
+	TrumpSuit  string
+	TrumpRank  string
+	DealerSeat int
+	// FriendSeat is 0 until the friend is revealed, or this seat itself when it
+	// holds enough copies of the called card to become the friend
+	FriendSeat int
+
+	Played map[Card]int            // copies played so far
+	Voids  map[int]map[string]bool // seat -> suit groups ("trump" or a side suit) it is out of
+	seen   map[Card]int            // own hand (+ bottom for the dealer)
+
+	// friendOdds guesses who the hidden friend is (seat -> probability), see inferFriend
+	friendOdds map[int]float64
+}
+
+// partnerConfidence is how likely a seat must be on this seat's team before the
+// AI plays it as a partner (feeds it points, lets it win the trick)
+const partnerConfidence = 0.75
+
+// deckCopies is how many copies of every card are in play (three decks)
+const deckCopies = 3
+
+// NewCardMemory builds the knowledge of seat from the table's public history
+func NewCardMemory(table *GameTable, seat int, hand []Card) *CardMemory {
+	m := &CardMemory{
+		Seat:       seat,
+		TrumpSuit:  table.TrumpSuit,
+		TrumpRank:  table.TrumpRank,
+		DealerSeat: table.DealerSeat,
+		Played:     make(map[Card]int),
+		Voids:      make(map[int]map[string]bool),
+		seen:       make(map[Card]int),
+	}
+	if table.FriendRevealed {
+		m.FriendSeat = table.FriendSeat
+	}
+
+	for _, card := range hand {
+		m.seen[card]++
+	}
+	if seat == table.DealerSeat && table.Status == "playing" {
+		for _, card := range table.BottomCards {
+			m.seen[card]++
+		}
+	}
+
+	if len(table.TrickHistory) >= len(table.TricksWon) {
+		for _, trick := range table.TrickHistory {
+			m.observe(trick.Plays)
+		}
+	} else {
+		// 旧快照没有出牌记录，只能数出过的牌
+		for _, trick := range table.TricksWon {
+			for _, card := range trick {
+				m.Played[card]++
+			}
+		}
+	}
+	m.observe(table.TrickPlays)
+	m.inferFriend(table, hand)
+
+	return m
+}
+
+// observe records the plays of one trick and the voids they reveal
+// A follower who plays fewer cards of the led suit group than were led is out of it.
+func (m *CardMemory) observe(plays []TrickPlay) {
+	if len(plays) == 0 || len(plays[0].Cards) == 0 {
+		return
+	}
+	leadGroup := m.Group(plays[0].Cards[0])
+	for _, play := range plays {
+		for _, card := range play.Cards {
+			m.Played[card]++
+		}
+		if play.IsLead {
+			continue
+		}
+		following := 0
+		for _, card := range play.Cards {
+			if m.Group(card) == leadGroup {
+				following++
+			}
+		}
+		if following < len(plays[0].Cards) {
+			if m.Voids[play.Seat] == nil {
+				m.Voids[play.Seat] = make(map[string]bool)
+			}
+			m.Voids[play.Seat][leadGroup] = true
+		}
+	}
+}
+
+// Group is the suit a card follows as: "trump" for jokers, level cards and the
+// trump suit, otherwise its own suit
+func (m *CardMemory) Group(card Card) string {
+	if isTrumpCard(card, m.TrumpSuit, m.TrumpRank) {
+		return "trump"
+	}
+	return card.Suit
+}
+
+// Unseen is how many copies of card may still be in other seats' hands
+func (m *CardMemory) Unseen(card Card) int {
+	n := deckCopies - m.Played[card] - m.seen[card]
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// IsVoid reports whether seat has shown it is out of the suit group
+func (m *CardMemory) IsVoid(seat int, group string) bool {
+	return m.Voids[seat][group]
+}
+
+// inferFriend works out the teams while the friend is hidden (找朋友)
+// The friend is whoever plays the Nth copy of the called card. A non-dealer
+// holding enough copies to play it counts itself as the friend. Otherwise every
+// other non-dealer seat that may still hold a copy (no void in its suit group) is
+// a candidate, more likely if it fed points (送分) to tricks the dealer was winning
+// and less likely if it beat the dealer's play.
+func (m *CardMemory) inferFriend(table *GameTable, hand []Card) {
+	called := table.HostCalledCard
+	if table.FriendRevealed || called == nil {
+		return
+	}
+	card := Card{Suit: called.Suit, Value: called.Value, Type: "normal"}
+	if called.Suit == "joker" {
+		card.Type = "joker"
+	}
+	if m.Seat != m.DealerSeat && called.Count+countCard(hand, card) >= called.Position {
+		m.FriendSeat = m.Seat
+		return
+	}
+
+	weights := make(map[int]float64)
+	for seat, h := range table.PlayerHands {
+		if seat != m.DealerSeat && seat != m.Seat && len(h.Cards) > 0 && !m.IsVoid(seat, m.Group(card)) {
+			weights[seat] = 1
+		}
+	}
+	if len(weights) == 0 || m.Unseen(card) == 0 {
+		return // 第N张打不出来了：庄家一打四
+	}
+
+	judge := func(plays []TrickPlay) {
+		for i := 1; i < len(plays); i++ {
+			seat := plays[i].Seat
+			if _, ok := weights[seat]; !ok {
+				continue
+			}
+			if determineTrickWinner(plays[:i], m.TrumpSuit, m.TrumpRank) != m.DealerSeat {
+				continue
+			}
+			if determineTrickWinner(plays[:i+1], m.TrumpSuit, m.TrumpRank) != m.DealerSeat {
+				weights[seat] *= 0.5 // 管了庄家的牌
+			} else if cardPoints(plays[i].Cards) > 0 {
+				weights[seat] *= 2 // 给庄家送分
+			}
+		}
+	}
+	for _, trick := range table.TrickHistory {
+		judge(trick.Plays)
+	}
+	judge(table.TrickPlays)
+
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	m.friendOdds = make(map[int]float64, len(weights))
+	for seat, w := range weights {
+		m.friendOdds[seat] = w / total
+	}
+}
+
+// cardPoints sums the points of cards
+func cardPoints(cards []Card) int {
+	points := 0
+	for _, card := range cards {
+		points += getCardPoints(card)
+	}
+	return points
+}
+
+// DealerTeamOdds is the probability that seat is on the dealer's side
+func (m *CardMemory) DealerTeamOdds(seat int) float64 {
+	switch {
+	case seat == m.DealerSeat:
+		return 1
+	case m.FriendSeat != 0:
+		if seat == m.FriendSeat {
+			return 1
+		}
+		return 0
+	}
+	return m.friendOdds[seat]
+}
+
+// PartnerOdds is the probability that seat is on this seat's team
+func (m *CardMemory) PartnerOdds(seat int) float64 {
+	if seat == m.Seat {
+		return 1
+	}
+	if m.DealerTeamOdds(m.Seat) == 1 {
+		return m.DealerTeamOdds(seat)
+	}
+	return 1 - m.DealerTeamOdds(seat)
+}
+
+// IsPartner reports whether seat is on this seat's team as far as it can tell:
+// certain once the friend is revealed, before that if PartnerOdds is high enough
+func (m *CardMemory) IsPartner(seat int) bool {
+	return m.PartnerOdds(seat) >= partnerConfidence
+}
+
+// OpponentVoid reports whether a seat that is not a known partner is out of the
+// side suit group, i.e. could ruff a lead in it. Always false for trump.
+func (m *CardMemory) OpponentVoid(group string) bool {
+	if group == "trump" {
+		return false
+	}
+	for seat, voids := range m.Voids {
+		if voids[group] && !m.IsPartner(seat) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLargestLeft reports whether size identical copies of card (a single, pair or
+// triple) cannot be beaten by a group of the same size that is still unseen
+// Equal groups do not beat it: ties go to the earlier play.
+func (m *CardMemory) IsLargestLeft(card Card, size int) bool {
+	group := m.Group(card)
+	rank := getCardRank(card, m.TrumpSuit, m.TrumpRank)
+	for _, other := range distinctCards() {
+		if m.Group(other) != group || getCardRank(other, m.TrumpSuit, m.TrumpRank) <= rank {
+			continue
+		}
+		if m.Unseen(other) >= size {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSafeLead reports whether leading cards wins the trick for sure as far as this
+// seat can tell: every identical group is the largest left, no opponent has shown
+// a void to ruff with, and a single's other copies are all gone (e.g. a side-suit
+// A is led only once the other two As have been played)
+func (m *CardMemory) IsSafeLead(cards []Card) bool {
+	if len(cards) == 0 {
+		return false
+	}
+	group := m.Group(cards[0])
+	for _, card := range cards {
+		if m.Group(card) != group {
+			return false
+		}
+	}
+	if m.OpponentVoid(group) {
+		return false
+	}
+	for _, g := range identicalGroups(cards) {
+		if !m.IsLargestLeft(g[0], len(g)) {
+			return false
+		}
+		if m.Unseen(g[0]) > 0 && len(g) == 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// CanThrow reports whether a throw (甩牌) of cards is provably the largest left:
+// each of its singles, pairs and triples is unbeatable and nobody can ruff
+func (m *CardMemory) CanThrow(cards []Card) bool {
+	if len(cards) < 2 {
+		return false
+	}
+	group := m.Group(cards[0])
+	for _, card := range cards {
+		if m.Group(card) != group {
+			return false
+		}
+	}
+	if m.OpponentVoid(group) {
+		return false
+	}
+	for _, g := range identicalGroups(cards) {
+		if !m.IsLargestLeft(g[0], len(g)) {
+			return false
+		}
+	}
+	return true
+}
+
+// distinctCards lists every distinct card of one deck
+func distinctCards() []Card {
+	cards := make([]Card, 0, 54)
+	for _, suit := range []string{"spades", "hearts", "diamonds", "clubs"} {
+		for _, value := range []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K", "A"} {
+			cards = append(cards, Card{Suit: suit, Value: value, Type: "normal"})
+		}
+	}
+	cards = append(cards,
+		Card{Suit: "joker", Value: "small", Type: "joker"},
+		Card{Suit: "joker", Value: "big", Type: "joker"})
+	return cards
+}
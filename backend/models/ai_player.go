@@ -2,7 +2,10 @@ package models
 
 import (
 	"fmt"
+	"log"
+	"slices"
 	"sort"
+	"time"
 )
 
 // AIPlayer represents an AI player with decision-making capabilities
@@ -10,7 +13,16 @@ type AIPlayer struct {
 	UserID     string
 	SeatNumber int
 	Hand       []Card
-	IsFriend   bool
+	IsFriend   bool // set by DecidePlay: the revealed friend, or about to become it
+
+	// Difficulty is AIDifficultyNormal (heuristics, the default) or AIDifficultyHard
+	// (search within ThinkBudget), see ai_search.go
+	Difficulty  string
+	ThinkBudget time.Duration
+	// Personality tweaks the heuristics: AIPersonalityAggressive or AIPersonalityConservative
+	Personality string
+
+	memory *CardMemory // 记牌：rebuilt from the table on every DecidePlay
 }
 
 // CardStrength represents the strength of a card for AI decision making
@@ -26,14 +38,22 @@ func (ai *AIPlayer) DecidePlay(table *GameTable) []int {
 	if len(ai.Hand) == 0 {
 		return []int{0}
 	}
-
-	// If leading (first to play in this trick)
-	if len(table.CurrentTrick) == 0 {
-		return ai.decideLeadCards(table)
+	ai.memory = NewCardMemory(table, ai.SeatNumber, ai.Hand)
+	ai.IsFriend = ai.SeatNumber != table.DealerSeat && ai.memory.FriendSeat == ai.SeatNumber
+
+	var indices []int
+	if len(table.TrickPlays) == 0 {
+		// If leading (first to play in this trick)
+		indices = ai.decideLeadCards(table)
+	} else {
+		// If following, must follow suit if possible
+		indices = ai.decideFollowCards(table)
 	}
 
-	// If following, must follow suit if possible
-	return ai.decideFollowCards(table)
+	if ai.Difficulty == AIDifficultyHard {
+		return ai.decideBySearch(table, indices)
+	}
+	return indices
 }
 
 // decideLeadCards chooses cards when leading a trick
@@ -43,6 +63,16 @@ func (ai *AIPlayer) decideLeadCards(table *GameTable) []int {
 		return throwIndices
 	}
 
+	// 手里的朋友牌够第N张：打出来亮明身份，让庄家知道谁是队友
+	if revealIndices := ai.findFriendReveal(table); len(revealIndices) > 0 {
+		return revealIndices
+	}
+
+	// Lead a card or group nobody can beat any more (e.g. an A once the other two are gone)
+	if safeIndices := ai.findSafeLead(); len(safeIndices) > 0 {
+		return safeIndices
+	}
+
 	// Try to lead with a pair or triple if we have one
 	if pairIndices := ai.findStrongestPair(); len(pairIndices) > 0 {
 		return pairIndices
@@ -51,17 +81,25 @@ func (ai *AIPlayer) decideLeadCards(table *GameTable) []int {
 	// Strategy: Lead with a low card from a long suit to drain opponents
 	// Or lead with a strong card if we want to win the trick
 
-	// Count cards by suit
+	// Count cards by suit (suits in hand order, so ties always break the same way)
 	suitCounts := make(map[string]int)
+	var suits []string
 	for _, card := range ai.Hand {
+		if suitCounts[card.Suit] == 0 {
+			suits = append(suits, card.Suit)
+		}
 		suitCounts[card.Suit]++
 	}
 
-	// Find longest suit
+	// Find longest suit, avoiding suits an opponent is known to be out of (they would ruff)
 	longestSuit := ""
 	maxCount := 0
-	for suit, count := range suitCounts {
-		if count > maxCount {
+	for _, suit := range suits {
+		count := suitCounts[suit]
+		if ai.memory != nil && ai.memory.OpponentVoid(suit) {
+			count -= 100
+		}
+		if longestSuit == "" || count > maxCount {
 			maxCount = count
 			longestSuit = suit
 		}
@@ -86,14 +124,88 @@ func (ai *AIPlayer) decideLeadCards(table *GameTable) []int {
 	}
 
 	// Sort candidates by card value (ascending for lowest first)
+	// 保守：分牌排到最后，尽量不先出分
 	sort.Slice(candidates, func(i, j int) bool {
-		return getCardBaseValue(ai.Hand[candidates[i]]) < getCardBaseValue(ai.Hand[candidates[j]])
+		return ai.keepValue(ai.Hand[candidates[i]]) < ai.keepValue(ai.Hand[candidates[j]])
 	})
 
+	// 激进：先出最大的牌抢墩
+	if ai.Personality == AIPersonalityAggressive {
+		return []int{candidates[len(candidates)-1]}
+	}
+
 	// Play lowest card from the longest suit (conservative strategy)
 	return []int{candidates[0]}
 }
 
+// findFriendReveal returns the copies of the called card that make this seat
+// the friend when led together (a single, pair or triple), or nil
+func (ai *AIPlayer) findFriendReveal(table *GameTable) []int {
+	called := table.HostCalledCard
+	if !ai.IsFriend || table.FriendRevealed || called == nil {
+		return nil
+	}
+	need := called.Position - called.Count
+	var indices []int
+	for i, card := range ai.Hand {
+		if card.Suit == called.Suit && card.Value == called.Value && len(indices) < need {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) < need || need > 3 {
+		return nil
+	}
+	return indices
+}
+
+// keepValue is how much the AI wants to keep a card: its face value, and for a
+// conservative AI point cards above everything else
+func (ai *AIPlayer) keepValue(card Card) int {
+	value := getCardBaseValue(card)
+	if ai.Personality == AIPersonalityConservative && isScoringCard(card) {
+		value += 100
+	}
+	return value
+}
+
+// findSafeLead finds the biggest identical group (triple, pair, single) that
+// the card memory says wins the trick; side suits before trump to save trumps
+func (ai *AIPlayer) findSafeLead() []int {
+	if ai.memory == nil {
+		return nil
+	}
+
+	groups := make(map[Card][]int)
+	for i, card := range ai.Hand {
+		groups[card] = append(groups[card], i)
+	}
+
+	var best []int
+	bestIsTrump := true
+	for card, indices := range groups {
+		if len(indices) > 3 {
+			indices = indices[:3]
+		}
+		cards := make([]Card, len(indices))
+		for i := range cards {
+			cards[i] = card
+		}
+		if !ai.memory.IsSafeLead(cards) {
+			continue
+		}
+		isTrump := ai.memory.Group(card) == "trump"
+		better := best == nil ||
+			(bestIsTrump && !isTrump) ||
+			(bestIsTrump == isTrump && len(indices) > len(best)) ||
+			(bestIsTrump == isTrump && len(indices) == len(best) && indices[0] < best[0])
+		if better {
+			best = indices
+			bestIsTrump = isTrump
+		}
+	}
+	return best
+}
+
 // findStrongestPair finds the strongest pair or triple to lead with
 func (ai *AIPlayer) findStrongestPair() []int {
 	// Count cards by suit and value
@@ -102,22 +214,26 @@ func (ai *AIPlayer) findStrongestPair() []int {
 		value string
 	}
 	cardGroups := make(map[CardKey][]int)
+	var keys []CardKey
 
 	for i, card := range ai.Hand {
 		key := CardKey{suit: card.Suit, value: card.Value}
+		if cardGroups[key] == nil {
+			keys = append(keys, key)
+		}
 		cardGroups[key] = append(cardGroups[key], i)
 	}
 
 	// Find triples first (priority)
-	for _, indices := range cardGroups {
-		if len(indices) >= 3 {
+	for _, key := range keys {
+		if indices := cardGroups[key]; len(indices) >= 3 {
 			return indices[:3]
 		}
 	}
 
 	// Find pairs
-	for _, indices := range cardGroups {
-		if len(indices) >= 2 {
+	for _, key := range keys {
+		if indices := cardGroups[key]; len(indices) >= 2 {
 			return indices[:2]
 		}
 	}
@@ -127,15 +243,21 @@ func (ai *AIPlayer) findStrongestPair() []int {
 
 // tryThrowCards attempts to find a valid throw (甩牌)
 // Returns card indices if a throw is possible, empty otherwise
+// A whole side suit is thrown only when every part of it is provably the largest left.
 func (ai *AIPlayer) tryThrowCards(table *GameTable) []int {
 	// Group cards by suit
 	suitCards := make(map[string][]int)
+	var suits []string
 	for i, card := range ai.Hand {
+		if suitCards[card.Suit] == nil {
+			suits = append(suits, card.Suit)
+		}
 		suitCards[card.Suit] = append(suitCards[card.Suit], i)
 	}
 
 	// For each suit, check if we can throw
-	for suit, indices := range suitCards {
+	for _, suit := range suits {
+		indices := suitCards[suit]
 		if len(indices) < 2 {
 			continue // Need at least 2 cards to throw
 		}
@@ -151,10 +273,13 @@ func (ai *AIPlayer) tryThrowCards(table *GameTable) []int {
 			cards = append(cards, ai.Hand[idx])
 		}
 
-		// Validate throw
-		result := ValidateThrowCards(cards, table, ai.SeatNumber)
-		if result.IsValid {
-			// Return indices of cards to throw
+		// 只甩记牌能证明最大的组合（不偷看别人的手牌）
+		if ai.memory != nil && ai.memory.CanThrow(cards) {
+			return indices
+		}
+		// 激进：最大的一组稳赢、没人能毙就甩，甩不出也只是出最小的一组
+		if ai.Personality == AIPersonalityAggressive && ai.memory != nil && len(cards) >= 3 &&
+			!ai.memory.OpponentVoid(suit) && ai.topGroupIsLargest(cards) {
 			return indices
 		}
 	}
@@ -163,22 +288,27 @@ func (ai *AIPlayer) tryThrowCards(table *GameTable) []int {
 	return nil
 }
 
+// topGroupIsLargest reports whether the highest identical group of cards is the
+// largest left of its size
+func (ai *AIPlayer) topGroupIsLargest(cards []Card) bool {
+	var top []Card
+	topRank := -1
+	for _, g := range identicalGroups(cards) {
+		if rank := getCardRank(g[0], ai.memory.TrumpSuit, ai.memory.TrumpRank); rank > topRank {
+			top, topRank = g, rank
+		}
+	}
+	return top != nil && ai.memory.IsLargestLeft(top[0], len(top))
+}
+
 // decideFollowCards chooses cards when following a lead
 // Must respect the lead card type (pair, triple, etc.)
 func (ai *AIPlayer) decideFollowCards(table *GameTable) []int {
-	leadSuit := table.CurrentTrick[0].Card.Suit
+	leadCards := table.TrickPlays[0].Cards
+	leadSuit := leadCards[0].Suit
 	trumpSuit := table.TrumpSuit
 
 	// Count how many cards the leader played
-	leadSeat := table.CurrentTrick[0].Seat
-	var leadCards []Card
-	for _, pc := range table.CurrentTrick {
-		if pc.Seat == leadSeat {
-			leadCards = append(leadCards, pc.Card)
-		} else {
-			break
-		}
-	}
 	leadCount := len(leadCards)
 
 	// Determine lead play type
@@ -212,11 +342,23 @@ func (ai *AIPlayer) decideFollowWithSuit(table *GameTable, followCards []int, le
 		}
 	}
 
+	// 没有三张时，有对子必须跟对子，再配一张最小的
+	var selectedIndices []int
 	if isLeadTriple {
 		// Try to find a triple in the lead suit
 		if tripleIndices := ai.findTripleInSuit(leadSuit); len(tripleIndices) >= 3 {
 			return tripleIndices[:3]
 		}
+		if pairIndices := ai.findPairInSuit(leadSuit); len(pairIndices) >= 2 {
+			selectedIndices = append(selectedIndices, pairIndices[:2]...)
+		}
+	}
+
+	// 单张跟牌：用记牌判断能否稳赢，或者给赢牌的队友送分
+	if leadCount == 1 && ai.memory != nil {
+		if indices := ai.decideSingleFollow(table, followCards); indices != nil {
+			return indices
+		}
 	}
 
 	// Can't match the exact type, play leadCount cards from the suit
@@ -229,6 +371,9 @@ func (ai *AIPlayer) decideFollowWithSuit(table *GameTable, followCards []int, le
 	for i, cardIdx := range followCards {
 		card := ai.Hand[cardIdx]
 		strength := getCardValue(card, leadSuit, table.TrumpSuit)
+		if ai.Personality == AIPersonalityConservative && isScoringCard(card) {
+			strength += 1000 // 保守：能不跟分就不跟分
+		}
 		strengths[i] = struct {
 			index    int
 			strength int
@@ -241,9 +386,10 @@ func (ai *AIPlayer) decideFollowWithSuit(table *GameTable, followCards []int, le
 	})
 
 	// Select leadCount cards
-	selectedIndices := make([]int, 0, leadCount)
-	for i := 0; i < leadCount && i < len(strengths); i++ {
-		selectedIndices = append(selectedIndices, strengths[i].index)
+	for i := 0; len(selectedIndices) < leadCount && i < len(strengths); i++ {
+		if !slices.Contains(selectedIndices, strengths[i].index) {
+			selectedIndices = append(selectedIndices, strengths[i].index)
+		}
 	}
 
 	// If we don't have enough cards in the suit, add more cards from other suits
@@ -256,19 +402,96 @@ func (ai *AIPlayer) decideFollowWithSuit(table *GameTable, followCards []int, le
 	return selectedIndices
 }
 
+// decideSingleFollow picks the card to follow a single lead with
+// If a known partner is winning for sure, it feeds them the biggest point card;
+// otherwise it plays the cheapest card that wins and can no longer be beaten by
+// the seats still to play. Returns nil to fall back to the lowest card.
+func (ai *AIPlayer) decideSingleFollow(table *GameTable, followCards []int) []int {
+	m := ai.memory
+	last := len(table.TrickPlays) == len(table.PlayerHands)-1
+	winner := getCurrentWinnerSeat(table)
+
+	if winner != ai.SeatNumber && m.IsPartner(winner) {
+		if ai.partnerWinsForSure(table, winner) {
+			best := -1
+			for _, idx := range followCards {
+				if points := getCardPoints(ai.Hand[idx]); points > 0 && (best < 0 || points > getCardPoints(ai.Hand[best])) {
+					best = idx
+				}
+			}
+			if best >= 0 {
+				return []int{best}
+			}
+		}
+		return nil
+	}
+
+	candidates := append([]int(nil), followCards...)
+	sort.Slice(candidates, func(i, j int) bool {
+		return getCardRank(ai.Hand[candidates[i]], table.TrumpSuit, table.TrumpRank) <
+			getCardRank(ai.Hand[candidates[j]], table.TrumpSuit, table.TrumpRank)
+	})
+	for _, idx := range candidates {
+		card := ai.Hand[idx]
+		plays := append(copyTrickPlays(table.TrickPlays), TrickPlay{Seat: ai.SeatNumber, Cards: []Card{card}})
+		if determineTrickWinner(plays, table.TrumpSuit, table.TrumpRank) != ai.SeatNumber {
+			continue
+		}
+		if last || (m.IsLargestLeft(card, 1) && !m.OpponentVoid(m.Group(card))) {
+			return []int{idx}
+		}
+	}
+	return nil
+}
+
+// partnerWinsForSure reports whether the trick is safe to feed points (送分) to:
+// the winner is a partner and nobody after this seat can beat its play
+// A conservative AI only trusts this when it plays last.
+func (ai *AIPlayer) partnerWinsForSure(table *GameTable, winner int) bool {
+	m := ai.memory
+	if winner == ai.SeatNumber || !m.IsPartner(winner) {
+		return false
+	}
+	if len(table.TrickPlays) == len(table.PlayerHands)-1 {
+		return true
+	}
+	if ai.Personality == AIPersonalityConservative {
+		return false
+	}
+	winning := table.TrickPlays[0].Cards
+	for _, play := range table.TrickPlays {
+		if play.Seat == winner {
+			winning = play.Cards
+		}
+	}
+	if m.OpponentVoid(m.Group(winning[0])) {
+		return false
+	}
+	for _, g := range identicalGroups(winning) {
+		if !m.IsLargestLeft(g[0], len(g)) {
+			return false
+		}
+	}
+	return true
+}
+
 // findPairInSuit finds a pair in the specified suit
 func (ai *AIPlayer) findPairInSuit(suit string) []int {
 	// Count cards by value in the suit
 	valueIndices := make(map[string][]int)
+	var values []string
 	for i, card := range ai.Hand {
 		if card.Suit == suit {
+			if valueIndices[card.Value] == nil {
+				values = append(values, card.Value)
+			}
 			valueIndices[card.Value] = append(valueIndices[card.Value], i)
 		}
 	}
 
 	// Find pairs
-	for _, indices := range valueIndices {
-		if len(indices) >= 2 {
+	for _, value := range values {
+		if indices := valueIndices[value]; len(indices) >= 2 {
 			return indices[:2]
 		}
 	}
@@ -279,15 +502,19 @@ func (ai *AIPlayer) findPairInSuit(suit string) []int {
 func (ai *AIPlayer) findTripleInSuit(suit string) []int {
 	// Count cards by value in the suit
 	valueIndices := make(map[string][]int)
+	var values []string
 	for i, card := range ai.Hand {
 		if card.Suit == suit {
+			if valueIndices[card.Value] == nil {
+				values = append(values, card.Value)
+			}
 			valueIndices[card.Value] = append(valueIndices[card.Value], i)
 		}
 	}
 
 	// Find triples
-	for _, indices := range valueIndices {
-		if len(indices) >= 3 {
+	for _, value := range values {
+		if indices := valueIndices[value]; len(indices) >= 3 {
 			return indices[:3]
 		}
 	}
@@ -322,8 +549,13 @@ func (ai *AIPlayer) decideCantFollow(table *GameTable, leadSuit, trumpSuit strin
 	trumpCards := ai.getTrumpCards(trumpSuit)
 	partnerWinning := ai.partnerIsWinning(table)
 
-	// If partner is winning and not last player, discard low
-	if partnerWinning && len(table.CurrentTrick) < 4 {
+	// 队友稳赢就垫分牌送分，否则垫小牌
+	if partnerWinning {
+		if ai.partnerWinsForSure(table, getCurrentWinnerSeat(table)) {
+			if feed := ai.findPointDiscards(table, leadCount); feed != nil {
+				return feed
+			}
+		}
 		return ai.discardLow(table, leadSuit, leadCount)
 	}
 
@@ -384,75 +616,52 @@ func (ai *AIPlayer) findLowestNonTrumpCards(trumpSuit string, count int) []int {
 	return candidates
 }
 
-// partnerIsWinning checks if the AI's partner is currently winning the trick
+// partnerIsWinning checks if the AI's partner (as far as the card memory can
+// tell, see CardMemory.IsPartner) is currently winning the trick
 func (ai *AIPlayer) partnerIsWinning(table *GameTable) bool {
-	if len(table.CurrentTrick) == 0 {
+	if len(table.TrickPlays) == 0 {
 		return false
 	}
-
-	// Check if we have a revealed friend
-	if table.FriendRevealed && table.FriendSeat != ai.SeatNumber {
-		// Check if friend is currently winning
-		currentWinner := getCurrentWinnerSeat(table)
-		return currentWinner == table.FriendSeat
+	if ai.memory == nil {
+		ai.memory = NewCardMemory(table, ai.SeatNumber, ai.Hand)
 	}
+	winner := getCurrentWinnerSeat(table)
+	return winner != ai.SeatNumber && ai.memory.IsPartner(winner)
+}
 
-	// If friend not revealed, assume we're on defender team
-	// and check if winner is not host
-	currentWinner := getCurrentWinnerSeat(table)
-	// Get host's seat number
-	hostSeat := 1 // Default host is seat 1
-	for seat, hand := range table.PlayerHands {
-		if hand.UserID == table.HostID {
-			hostSeat = seat
-			break
+// findPointDiscards picks count side-suit cards to throw onto a partner's trick,
+// point cards (10, K, 5) first. Returns nil unless at least one carries points.
+func (ai *AIPlayer) findPointDiscards(table *GameTable, count int) []int {
+	var candidates []int
+	for i, card := range ai.Hand {
+		if !isTrumpCard(card, table.TrumpSuit, table.TrumpRank) {
+			candidates = append(candidates, i)
 		}
 	}
-	return currentWinner != hostSeat
-}
-
-// getCurrentWinner returns the current winner and their card strength
-func getCurrentWinner(table *GameTable) (int, int) {
-	if len(table.CurrentTrick) == 0 {
-		return -1, 0
+	if len(candidates) < count {
+		return nil
 	}
-
-	winnerSeat := table.CurrentTrick[0].Seat
-	leadSuit := table.CurrentTrick[0].Card.Suit
-	highestStrength := getCardValue(table.CurrentTrick[0].Card, leadSuit, table.TrumpSuit)
-
-	for i := 1; i < len(table.CurrentTrick); i++ {
-		pc := table.CurrentTrick[i]
-		strength := getCardValue(pc.Card, leadSuit, table.TrumpSuit)
-		if strength > highestStrength {
-			highestStrength = strength
-			winnerSeat = pc.Seat
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := ai.Hand[candidates[i]], ai.Hand[candidates[j]]
+		if getCardPoints(a) != getCardPoints(b) {
+			return getCardPoints(a) > getCardPoints(b)
 		}
+		return getCardBaseValue(a) < getCardBaseValue(b)
+	})
+	candidates = candidates[:count]
+	if ai.pointsIn(candidates) == 0 {
+		return nil
 	}
-
-	return winnerSeat, highestStrength
+	return candidates
 }
 
 // getCurrentWinnerSeat returns the seat number of the current winner
+// Whole plays are compared the same way the trick will be settled.
 func getCurrentWinnerSeat(table *GameTable) int {
-	if len(table.CurrentTrick) == 0 {
+	if len(table.TrickPlays) == 0 {
 		return -1
 	}
-
-	winnerSeat := table.CurrentTrick[0].Seat
-	leadSuit := table.CurrentTrick[0].Card.Suit
-	highestStrength := getCardValue(table.CurrentTrick[0].Card, leadSuit, table.TrumpSuit)
-
-	for i := 1; i < len(table.CurrentTrick); i++ {
-		pc := table.CurrentTrick[i]
-		strength := getCardValue(pc.Card, leadSuit, table.TrumpSuit)
-		if strength > highestStrength {
-			highestStrength = strength
-			winnerSeat = pc.Seat
-		}
-	}
-
-	return winnerSeat
+	return determineTrickWinner(table.TrickPlays, table.TrumpSuit, table.TrumpRank)
 }
 
 // findLowestCard finds the index of the lowest value card
@@ -503,8 +712,12 @@ func (ai *AIPlayer) discardLow(table *GameTable, leadSuit string, count int) []i
 	// Avoid discarding scoring cards (5, 10, K)
 
 	suitCounts := make(map[string]int)
+	var suits []string
 	for _, card := range ai.Hand {
 		if card.Suit != table.TrumpSuit {
+			if suitCounts[card.Suit] == 0 {
+				suits = append(suits, card.Suit)
+			}
 			suitCounts[card.Suit]++
 		}
 	}
@@ -512,7 +725,8 @@ func (ai *AIPlayer) discardLow(table *GameTable, leadSuit string, count int) []i
 	// Find shortest suit
 	shortestSuit := ""
 	minCount := 1000
-	for suit, c := range suitCounts {
+	for _, suit := range suits {
+		c := suitCounts[suit]
 		if c > 0 && c < minCount {
 			minCount = c
 			shortestSuit = suit
@@ -589,86 +803,328 @@ func getCardBaseValue(card Card) int {
 	return 0
 }
 
-// DecideFriendCard decides which card to call as friend
-func (ai *AIPlayer) DecideFriendCard() (string, string) {
-	if len(ai.Hand) == 0 {
-		return "spades", "A" // Default
+// callStrengthThreshold is the trump strength (see trumpStrength) an AI wants
+// before it calls dealer; a random suit averages about 16
+const callStrengthThreshold = 19
+
+// counterSuitMargin is how much stronger a new trump suit must be before the AI
+// counter-calls with the temporary dealer's level (庄家不变，只换主花色)
+const counterSuitMargin = 4
+
+// DecideCall decides whether to call dealer (叫庄) or counter-call (反庄)
+// Returns the suit and the indices of the level cards to show, or nil to pass.
+// Follows RULE.md §3.2-3.3: the first call uses the AI's own level; a counter-call
+// shows more cards than the last call (at most 3), either of its own level (the AI
+// becomes temporary dealer) or of the temporary dealer's level (only the trump
+// suit changes). All copies of the chosen card are shown so the bid is harder to
+// counter.
+func (ai *AIPlayer) DecideCall(table *GameTable) (string, []int) {
+	hand, ok := table.PlayerHands[ai.SeatNumber]
+	if !ok || hand.Level == "" {
+		return "", nil
 	}
+	level := hand.Level
 
-	// Strategy: Call a card we have multiple of or a high card
-	// Count cards by suit and value
-	cardCounts := make(map[string]int)
-	for _, card := range ai.Hand {
-		key := fmt.Sprintf("%s_%s", card.Suit, card.Value)
-		cardCounts[key]++
+	var last *CallRecord
+	if n := len(table.CallRecords); n > 0 {
+		last = &table.CallRecords[n-1]
+		if last.Seat == ai.SeatNumber || last.Count >= 3 {
+			return "", nil
+		}
 	}
 
-	// Find a card we have at least 2 of, preferably high value
-	bestCard := struct {
-		suit     string
-		value    string
-		count    int
-		strength int
-	}{"spades", "A", 0, 0}
-
-	for key, count := range cardCounts {
-		if count >= 2 {
-			// Parse key
-			var suit, value string
-			fmt.Sscanf(key, "%s_%s", &suit, &value)
-			strength := getCardBaseValue(Card{Suit: suit, Value: value})
-			if strength > bestCard.strength {
-				bestCard.suit = suit
-				bestCard.value = value
-				bestCard.count = count
-				bestCard.strength = strength
+	ranks := []string{level}
+	if last != nil && last.Rank != level {
+		ranks = append(ranks, last.Rank)
+	}
+
+	bestSuit := ""
+	var bestIndices []int
+	bestStrength := 0
+	for _, rank := range ranks {
+		for _, suit := range []string{"spades", "hearts", "diamonds", "clubs"} {
+			indices := ai.levelCardIndices(rank, suit)
+			if len(indices) > 3 {
+				indices = indices[:3]
+			}
+			if len(indices) == 0 || (last != nil && len(indices) <= last.Count) {
+				continue
+			}
+
+			strength := ai.trumpStrength(suit, rank)
+			if rank == level {
+				// 自己成为（临时）庄家：牌力要够，反庄时还要比现在的主更好
+				if strength < callStrengthThreshold {
+					continue
+				}
+				if last != nil && strength <= ai.trumpStrength(table.TrumpSuit, table.TrumpRank) {
+					continue
+				}
+			} else {
+				// 用临时庄家的级牌反庄，只换主花色
+				if suit == table.TrumpSuit || strength < ai.trumpStrength(table.TrumpSuit, rank)+counterSuitMargin {
+					continue
+				}
+			}
+
+			if strength > bestStrength {
+				bestSuit = suit
+				bestIndices = indices
+				bestStrength = strength
 			}
 		}
 	}
 
-	// If no pair, call a high card we have
-	if bestCard.count == 0 {
-		// Find highest card in hand
-		for _, card := range ai.Hand {
-			strength := getCardBaseValue(card)
-			if strength > bestCard.strength {
-				bestCard.suit = card.Suit
-				bestCard.value = card.Value
-				bestCard.strength = strength
-			}
+	if bestIndices == nil {
+		return "", nil
+	}
+	return bestSuit, bestIndices
+}
+
+// levelCardIndices returns the indices of the cards of rank and suit in the hand
+func (ai *AIPlayer) levelCardIndices(rank, suit string) []int {
+	var indices []int
+	for i, card := range ai.Hand {
+		if card.Type != "joker" && card.Value == rank && card.Suit == suit {
+			indices = append(indices, i)
 		}
 	}
+	return indices
+}
 
-	return bestCard.suit, bestCard.value
+// trumpStrength scores the hand as if suit were trump and rank the level
+// 王3分，级牌2分（主级牌3分），主花色牌1分（主A、主K再加1分）
+func (ai *AIPlayer) trumpStrength(suit, rank string) int {
+	strength := 0
+	for _, card := range ai.Hand {
+		switch {
+		case card.Type == "joker":
+			strength += 3
+		case card.Value == rank:
+			strength += 2
+			if card.Suit == suit {
+				strength++
+			}
+		case card.Suit == suit:
+			strength++
+			if card.Value == "A" || card.Value == "K" {
+				strength++
+			}
+		}
+	}
+	return strength
 }
 
-// ShouldCallFriendAsHost decides if AI should call a specific card as friend
-// when AI is the host
-func (ai *AIPlayer) ShouldCallFriendAsHost(table *GameTable) (string, string) {
-	// Call the Ace of our longest suit (highest chance of having it)
-	suitCounts := make(map[string]int)
+// discardCount is how many cards the dealer buries (the size of the bottom)
+const discardCount = 7
+
+// maxVoidSuitLength is the longest side suit the AI tries to void (扣绝) when burying
+const maxVoidSuitLength = 6
+
+// DecideDiscard chooses the 7 cards the AI dealer buries (扣牌)
+// Follows 游戏策略-扣牌逻辑.md:
+//  1. void the shortest side suit (≤6 cards) unless it holds point cards
+//     (5/10/K); if slots are left, void the next short suit that fits
+//  2. fill up with loose low side cards: no points, no pairs/triples, no high cards
+//  3. jokers, level cards and the trump suit are kept; they are only buried when
+//     the hand has fewer than 7 side cards (lowest trump first)
+func (ai *AIPlayer) DecideDiscard(table *GameTable) []int {
+	trumpSuit, trumpRank := table.TrumpSuit, table.TrumpRank
+
+	copies := make(map[Card]int)
 	for _, card := range ai.Hand {
-		suitCounts[card.Suit]++
+		copies[card]++
 	}
 
-	longestSuit := ""
-	maxCount := 0
-	for suit, count := range suitCounts {
-		if count > maxCount {
-			maxCount = count
-			longestSuit = suit
+	var trumps []int
+	sideSuits := make(map[string][]int)
+	for i, card := range ai.Hand {
+		if isTrumpCard(card, trumpSuit, trumpRank) {
+			trumps = append(trumps, i)
+		} else {
+			sideSuits[card.Suit] = append(sideSuits[card.Suit], i)
+		}
+	}
+
+	chosen := make([]int, 0, discardCount)
+	used := make(map[int]bool)
+	take := func(indices []int) {
+		for _, idx := range indices {
+			if len(chosen) < discardCount && !used[idx] {
+				used[idx] = true
+				chosen = append(chosen, idx)
+			}
+		}
+	}
+
+	// 1. 扣绝最短门：短门优先，分数少的优先；有分牌的门不扣绝
+	suits := make([]string, 0, len(sideSuits))
+	for suit := range sideSuits {
+		suits = append(suits, suit)
+	}
+	sort.Slice(suits, func(a, b int) bool {
+		la, lb := len(sideSuits[suits[a]]), len(sideSuits[suits[b]])
+		if la != lb {
+			return la < lb
+		}
+		return suits[a] < suits[b]
+	})
+	for _, suit := range suits {
+		indices := sideSuits[suit]
+		if len(indices) > maxVoidSuitLength || len(indices) > discardCount-len(chosen) {
+			continue
+		}
+		if ai.pointsIn(indices) > 0 {
+			continue
+		}
+		take(indices)
+	}
+
+	// 2. 零散小牌：按扣牌代价从低到高补齐
+	var rest []int
+	for _, suit := range suits {
+		rest = append(rest, sideSuits[suit]...)
+	}
+	sort.SliceStable(rest, func(a, b int) bool {
+		return ai.discardCost(rest[a], copies) < ai.discardCost(rest[b], copies)
+	})
+	take(rest)
+
+	// 3. 副牌不够7张才扣主牌，从最小的主开始
+	sort.SliceStable(trumps, func(a, b int) bool {
+		return getCardRank(ai.Hand[trumps[a]], trumpSuit, trumpRank) < getCardRank(ai.Hand[trumps[b]], trumpSuit, trumpRank)
+	})
+	take(trumps)
+
+	return chosen
+}
+
+// discardCost is how reluctant the AI is to bury a side card (lower goes first)
+// 分牌 > 对子/三张 > 大牌(A/Q/J) > 小牌，同类按点数
+func (ai *AIPlayer) discardCost(idx int, copies map[Card]int) int {
+	card := ai.Hand[idx]
+	cost := getCardBaseValue(card)
+	if isScoringCard(card) {
+		cost += 100 + getCardPoints(card)
+	}
+	if copies[card] >= 2 {
+		cost += 50
+	}
+	if card.Value == "A" || card.Value == "Q" || card.Value == "J" {
+		cost += 20
+	}
+	return cost
+}
+
+// pointsIn sums the points of the cards at indices
+func (ai *AIPlayer) pointsIn(indices []int) int {
+	points := 0
+	for _, idx := range indices {
+		points += getCardPoints(ai.Hand[idx])
+	}
+	return points
+}
+
+// soloMinTrumps is how many trumps (of 31 cards) the AI dealer needs to go 1v4
+// on purpose. A 1v4 win gains 3+ levels, but in self-play even 18-20 trumps win
+// only about one hand in six alone, while a partner wins about half of them.
+const soloMinTrumps = 22
+
+// DecideFriendCall picks the friend card (叫朋友): suit, value and which copy
+// (第N张) makes its player the friend
+// Copies in the bottom are never played and the dealer's own copies count towards
+// N, so holding h copies with b buried the AI calls copy h+b+1: it cannot be reached
+// before another seat plays a copy, and one is left to play if h+b+1 <= 3-b.
+// It prefers side-suit Aces, then Kings, it holds none of, in suits where it is
+// short: the partner wins those tricks for the dealer. With an overwhelming hand it
+// calls a card it holds so the hand is played 1v4 (庄家一打四).
+func (ai *AIPlayer) DecideFriendCall(table *GameTable) (string, string, int) {
+	copies := make(map[Card]int)
+	for _, card := range ai.Hand {
+		copies[card]++
+	}
+	buried := make(map[Card]int)
+	for _, card := range table.BottomCards {
+		buried[card]++
+	}
+	suitLength := make(map[string]int)
+	for _, card := range ai.Hand {
+		if !isTrumpCard(card, table.TrumpSuit, table.TrumpRank) {
+			suitLength[card.Suit]++
 		}
 	}
+	// 叫庄、反庄亮过的牌不能叫（规则按点数判断）
+	shown := make(map[string]bool)
+	for _, record := range table.CallRecords {
+		shown[record.Rank] = true
+	}
 
-	// Check if we have the Ace
+	trumps := 0
 	for _, card := range ai.Hand {
-		if card.Suit == longestSuit && card.Value == "A" {
-			return longestSuit, "A" // We have it, 1v4 mode
+		if isTrumpCard(card, table.TrumpSuit, table.TrumpRank) {
+			trumps++
+		}
+	}
+	if trumps >= soloMinTrumps {
+		// 叫自己手里的牌，叫牌时就确定1打4
+		var best Card
+		for _, card := range ai.Hand {
+			n := copies[card]
+			if card.Type == "joker" || shown[card.Value] {
+				continue
+			}
+			if best.Value == "" || n > copies[best] || (n == copies[best] && getCardRank(card, table.TrumpSuit, table.TrumpRank) > getCardRank(best, table.TrumpSuit, table.TrumpRank)) {
+				best = card
+			}
+		}
+		if best.Value != "" {
+			return best.Suit, best.Value, 1
+		}
+	}
+
+	bestSuit, bestValue, bestPosition, bestScore := "", "", 0, 0
+	for _, suit := range []string{"spades", "hearts", "diamonds", "clubs"} {
+		if suit == table.TrumpSuit {
+			continue
+		}
+		for _, value := range []string{"A", "K"} {
+			if value == table.TrumpRank || shown[value] {
+				continue
+			}
+			card := Card{Suit: suit, Value: value, Type: "normal"}
+			h, b := copies[card], buried[card]
+			position := h + b + 1
+			if position > deckCopies-b {
+				continue // 其他人手里没有，叫了也是1打4
+			}
+
+			score := 10
+			if value == "A" {
+				score = 20
+			}
+			// 自己的牌可能恰好成为第N张（意外1打4），也让朋友更晚亮出
+			score -= 6*h + 4*b
+			// 短门里朋友的大牌替庄家赢墩
+			if suitLength[suit] < 6 {
+				score += 6 - suitLength[suit]
+			}
+			if bestSuit == "" || score > bestScore {
+				bestSuit, bestValue, bestPosition, bestScore = suit, value, position, score
+			}
 		}
 	}
+	if bestSuit != "" {
+		return bestSuit, bestValue, bestPosition
+	}
 
-	// Call Ace of longest suit
-	return longestSuit, "A"
+	// 所有 A、K 都不能叫时，叫第1张不在自己手里的非主牌
+	for _, card := range distinctCards() {
+		if card.Type != "joker" && !shown[card.Value] && copies[card]+buried[card] == 0 &&
+			!isTrumpCard(card, table.TrumpSuit, table.TrumpRank) {
+			return card.Suit, card.Value, 1
+		}
+	}
+	return "spades", "A", 1
 }
 
 // CreateAIPlayers creates AI players for single player mode
@@ -683,9 +1139,12 @@ func CreateAIPlayers(gameID string, hostID string) ([]AIPlayer, error) {
 	return aiPlayers, nil
 }
 
-// AutoPlayAI makes all AI players play automatically
+// AutoPlayAI makes all AI players play automatically, each with its seat's Strategy
+// The table is changed in place, so it must be a detached copy the caller owns
+// (e.g. from GetTableGame); the strategies run right here, and the hard AI's
+// search or an external bot must not hold a stored table's lock.
 func AutoPlayAI(table *GameTable) error {
-	for table.CurrentPlayer != 1 {
+	for table.Status == "playing" && table.CurrentPlayer != 1 {
 		hand, ok := table.PlayerHands[table.CurrentPlayer]
 		if !ok {
 			return fmt.Errorf("player %d not found", table.CurrentPlayer)
@@ -693,23 +1152,11 @@ func AutoPlayAI(table *GameTable) error {
 
 		// Check if this is an AI player (seat 2-5)
 		if table.CurrentPlayer >= 2 {
-			ai := &AIPlayer{
-				UserID:     hand.UserID,
-				SeatNumber: table.CurrentPlayer,
-				Hand:       hand.Cards,
-			}
-
-			cardIndices := ai.DecidePlay(table)
-			_, err := PlayCardsGame(table.GameID, hand.UserID, cardIndices)
+			cardIndices := strategyFor(hand).DecidePlay(table, table.CurrentPlayer)
+			_, err := playCardsGame(table, hand.UserID, cardIndices)
 			if err != nil {
 				return fmt.Errorf("AI %d play failed: %w", table.CurrentPlayer, err)
 			}
-
-			// Update AI hand reference
-			newHand, ok := table.PlayerHands[table.CurrentPlayer]
-			if ok {
-				ai.Hand = newHand.Cards
-			}
 		} else {
 			break // Human player's turn
 		}
@@ -717,3 +1164,36 @@ func AutoPlayAI(table *GameTable) error {
 
 	return nil
 }
+
+// aiCallDealer gives the AI seats a chance to call or counter-call, starting from
+// the starting dealer and going counter-clockwise; at most one AI bids per call.
+// Returns true if one did. The caller must hold the table lock.
+func aiCallDealer(table *GameTable) bool {
+	if table.Status != "calling" || table.CallPhase != "counting" {
+		return false
+	}
+
+	for i := 0; i < len(table.PlayerHands); i++ {
+		seat := ((table.StartingDealerSeat - 1 - i + 5) % 5) + 1
+		hand, ok := table.PlayerHands[seat]
+		if !ok || !isAIPlayerID(hand.UserID) {
+			continue
+		}
+
+		ai := &AIPlayer{
+			UserID:     hand.UserID,
+			SeatNumber: seat,
+			Hand:       hand.Cards,
+		}
+		suit, cardIndices := ai.DecideCall(table)
+		if cardIndices == nil {
+			continue
+		}
+		if _, err := callDealer(table, hand.UserID, suit, cardIndices); err != nil {
+			log.Printf("Warning: AI %d call dealer failed: %v", seat, err)
+			continue
+		}
+		return true
+	}
+	return false
+}
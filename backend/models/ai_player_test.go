@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+// TestFollowTripleWithPair checks that the AI follows a triple it can't match
+// with a pair of the suit, as validateFollowPlay requires
+func TestFollowTripleWithPair(t *testing.T) {
+	// 打2，黑桃是主
+	hand := &PlayerHand{UserID: "ai_2", SeatNumber: 2, Cards: testCards("CA C4 CQ C3 CQ CA H6 C6")}
+	table := &GameTable{
+		Status:        "playing",
+		TrumpSuit:     "spades",
+		TrumpRank:     "2",
+		DealerSeat:    1,
+		CurrentPlayer: 2,
+		PlayerHands:   map[int]*PlayerHand{2: hand},
+		TrickPlays:    []TrickPlay{{Seat: 1, Cards: testCards("C8 C8 C8"), IsLead: true}},
+	}
+
+	ai := &AIPlayer{UserID: hand.UserID, SeatNumber: 2, Hand: hand.Cards}
+	indices := ai.DecidePlay(table)
+	played := cardsAt(hand.Cards, indices)
+	if err := validateCardPlay(played, table); err != nil {
+		t.Fatalf("played %v: %v", CardCodes(played), err)
+	}
+	if !hasPairInSuit(played, "clubs") {
+		t.Errorf("played %v, want a club pair", CardCodes(played))
+	}
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSearchCandidates bounds how many plays the hard AI compares
+	maxSearchCandidates = 12
+	// maxSearchWorlds bounds how many sampled deals one decision looks at
+	maxSearchWorlds = 200
+	// sampleAttempts is how often a deal is retried before the voids are ignored
+	sampleAttempts = 20
+)
+
+// 困难 AI：确定化蒙特卡洛搜索
+// For each candidate play, deal the cards this seat cannot see into the other
+// hands (and the bottom) consistently with what it knows, play the rest of the
+// hand out with the heuristic AI for every seat and average the score. Repeat
+// with new deals until the think budget is spent; pick the best average.
+
+// decideBySearch picks a play for the hard AI; ai.memory must be set
+func (ai *AIPlayer) decideBySearch(table *GameTable, preferred []int) []int {
+	candidates := legalPlays(table, ai.Hand, preferred, maxSearchCandidates)
+	if len(candidates) == 0 {
+		return preferred
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	totals := make([]int, len(candidates))
+	deadline := time.Now().Add(ai.ThinkBudget)
+	worlds := 0
+	for tries := 0; tries < maxSearchWorlds && (tries == 0 || time.Now().Before(deadline)); tries++ {
+		world, ok := sampleWorld(table, ai.SeatNumber, ai.memory, rng)
+		if !ok {
+			continue
+		}
+		for i, candidate := range candidates {
+			totals[i] += playout(world, ai.SeatNumber, candidate)
+		}
+		worlds++
+	}
+	if worlds == 0 {
+		// 没有一种发牌与已知信息相符，搜索无从谈起
+		return preferred
+	}
+
+	// 分数相同时保留启发式的选择（排在第一位）
+	best := 0
+	for i := range candidates {
+		if totals[i] > totals[best] {
+			best = i
+		}
+	}
+	return candidates[best]
+}
+
+// playKey identifies a play by its cards, so identical plays are tried once
+func playKey(hand []Card, indices []int) string {
+	keys := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		card := hand[idx]
+		keys = append(keys, card.Suit+":"+card.Value)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// sampleWorld returns a copy of the table in which the cards seat cannot see are
+// dealt at random into the other hands and (unless seat is the dealer) the bottom
+// Known holdings are respected: a seat keeps the level cards it showed when calling
+// dealer (until played), and gets no card of a suit group it has shown a void in.
+// The called friend card needs no special care: the table counts the copies played
+// so far, so whoever plays the Nth copy in the playout becomes the friend.
+// Returns false if no deal met the constraints; the real hands must never stand in
+// for a sampled world.
+func sampleWorld(table *GameTable, seat int, memory *CardMemory, rng *rand.Rand) (*GameTable, bool) {
+	world := table.Clone()
+
+	// 未见过的牌：三副牌减去已出的牌、自己的手牌（庄家还有底牌）
+	remaining := make(map[Card]int)
+	for _, card := range distinctCards() {
+		remaining[card] = deckCopies - memory.Played[card] - memory.seen[card]
+	}
+
+	// Slots to fill: every other seat's hand size, and the bottom
+	need := make(map[int]int)
+	for s, hand := range world.PlayerHands {
+		if s != seat {
+			need[s] = len(hand.Cards)
+		}
+	}
+	const bottomSlot = 0
+	if seat != table.DealerSeat || table.Status != "playing" {
+		need[bottomSlot] = len(table.BottomCards)
+	}
+
+	// 叫庄亮过的级牌还在叫庄者手里（庄家可能已扣进底牌，不计）
+	pinned := make(map[int][]Card)
+	for _, record := range table.CallRecords {
+		if record.Seat == seat || record.Seat == table.DealerSeat || record.Suit == "joker" {
+			continue
+		}
+		card := Card{Suit: record.Suit, Value: record.Rank, Type: "normal"}
+		held := record.Count - playedBySeat(table, record.Seat, card)
+		for n := countCard(pinned[record.Seat], card); n < held; n++ {
+			pinned[record.Seat] = append(pinned[record.Seat], card)
+		}
+	}
+
+	for attempt := 0; attempt < sampleAttempts; attempt++ {
+		dealt, ok := dealUnseen(remaining, need, pinned, memory, rng, attempt < sampleAttempts-1)
+		if !ok {
+			continue
+		}
+		for s, cards := range dealt {
+			if s == bottomSlot {
+				world.BottomCards = cards
+			} else {
+				world.PlayerHands[s].Cards = cards
+			}
+		}
+		return world, true
+	}
+	return nil, false
+}
+
+// dealUnseen deals the remaining cards into the slots, pinned cards first
+// Returns false if the constraints could not be met this time.
+func dealUnseen(remaining map[Card]int, need map[int]int, pinned map[int][]Card, memory *CardMemory, rng *rand.Rand, respectVoids bool) (map[int][]Card, bool) {
+	left := make(map[Card]int, len(remaining))
+	for card, n := range remaining {
+		left[card] = n
+	}
+	free := make(map[int]int, len(need))
+	dealt := make(map[int][]Card, len(need))
+	for s, n := range need {
+		free[s] = n
+	}
+
+	for s, cards := range pinned {
+		for _, card := range cards {
+			if left[card] > 0 && free[s] > 0 {
+				left[card]--
+				free[s]--
+				dealt[s] = append(dealt[s], card)
+			}
+		}
+	}
+
+	var pool []Card
+	for _, card := range distinctCards() {
+		for n := 0; n < left[card]; n++ {
+			pool = append(pool, card)
+		}
+	}
+	rng.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	slots := make([]int, 0, len(free))
+	for s := range free {
+		slots = append(slots, s)
+	}
+	sort.Ints(slots)
+
+	for _, card := range pool {
+		group := memory.Group(card)
+		// 按剩余空位加权随机选一个能拿这张牌的位置
+		total := 0
+		for _, s := range slots {
+			if free[s] > 0 && (!respectVoids || !memory.IsVoid(s, group)) {
+				total += free[s]
+			}
+		}
+		if total == 0 {
+			return nil, false
+		}
+		pick := rng.Intn(total)
+		for _, s := range slots {
+			if free[s] == 0 || (respectVoids && memory.IsVoid(s, group)) {
+				continue
+			}
+			if pick < free[s] {
+				free[s]--
+				dealt[s] = append(dealt[s], card)
+				break
+			}
+			pick -= free[s]
+		}
+	}
+	return dealt, true
+}
+
+// playedBySeat counts how many copies of card the seat has played this hand
+func playedBySeat(table *GameTable, seat int, card Card) int {
+	n := 0
+	count := func(plays []TrickPlay) {
+		for _, play := range plays {
+			if play.Seat == seat {
+				n += countCard(play.Cards, card)
+			}
+		}
+	}
+	for _, trick := range table.TrickHistory {
+		count(trick.Plays)
+	}
+	count(table.TrickPlays)
+	return n
+}
+
+// countCard counts the copies of card in cards
+func countCard(cards []Card, card Card) int {
+	n := 0
+	for _, c := range cards {
+		if c == card {
+			n++
+		}
+	}
+	return n
+}
+
+// playout plays cardIndices for seat in a sampled world, lets the heuristic AI
+// finish the hand for every seat and returns the score from seat's point of view:
+// the points the catching team (抓分方) ends with, negative for the dealer's team
+func playout(world *GameTable, seat int, cardIndices []int) int {
+	score, _ := playoutPoints(world, seat, cardIndices)
+	return score
+}
+
+// playoutPoints is playout that also returns the catching team's points
+// A play the rules refuse, or a hand the heuristic cannot finish, scores -1000.
+func playoutPoints(world *GameTable, seat int, cardIndices []int) (int, int) {
+	table := world.Clone()
+	if err := simulatePlay(table, seat, cardIndices); err != nil || !finishHand(table) {
+		return -1000, 0
+	}
+	points := catchingPoints(table)
+	if seat == table.DealerSeat || (table.FriendRevealed && seat == table.FriendSeat) {
+		return -points, points
+	}
+	return points, points
+}
+
+// catchingPoints is what the catching team scores on a finished hand, bottom included
+func catchingPoints(table *GameTable) int {
+	lastWinner := table.TrickLeader
+	if n := len(table.TrickHistory); n > 0 {
+		lastWinner = table.TrickHistory[n-1].Winner
+	}
+	points, _ := handResult(table, lastWinner)
+	return points
+}
+
+// finishHand lets the heuristic AI play every seat until the hand is over
+// Returns false if a seat got stuck with no play the rules accept.
+func finishHand(table *GameTable) bool {
+	for table.Status == "playing" {
+		current := table.CurrentPlayer
+		hand := table.PlayerHands[current]
+		ai := &AIPlayer{UserID: hand.UserID, SeatNumber: current, Hand: hand.Cards}
+		if err := simulatePlay(table, current, ai.DecidePlay(table)); err == nil {
+			continue
+		}
+		if err := simulatePlay(table, current, findLegalPlay(table, hand)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// simulatePlay plays a seat's cards on a detached table by the rules of
+// playCardsGame (throw check, validation, trick completion), without logging,
+// events or scoring
+func simulatePlay(table *GameTable, seat int, cardIndices []int) error {
+	hand := table.PlayerHands[seat]
+	if len(cardIndices) == 0 {
+		return fmt.Errorf("no cards selected")
+	}
+	for _, idx := range cardIndices {
+		if idx < 0 || idx >= len(hand.Cards) {
+			return fmt.Errorf("invalid card index: %d", idx)
+		}
+	}
+
+	if len(table.TrickPlays) == 0 && len(cardIndices) >= 2 {
+		cardIndices, _, _ = resolveThrow(table, seat, cardIndices)
+	}
+	cards := make([]Card, 0, len(cardIndices))
+	for _, idx := range cardIndices {
+		cards = append(cards, hand.Cards[idx])
+	}
+	if err := validateCardPlay(cards, table); err != nil {
+		return err
+	}
+
+	applyPlay(table, seat, cardIndices, time.Time{})
+	if isTrickComplete(table) {
+		completeTrick(table, determineTrickWinner(table.TrickPlays, table.TrumpSuit, table.TrumpRank))
+	} else {
+		advanceTurn(table, seat)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Strategy decides the plays of a seat the server plays for: AI seats, and
+// players' seats in trusteeship (托管)
+// The engine only talks to a seat through its Strategy (see autoPlay), so a new
+// bot is added by registering a StrategyFactory under a new difficulty name.
+type Strategy interface {
+	// DecidePlay returns the indices of the seat's cards to play
+	DecidePlay(table *GameTable, seat int) []int
+}
+
+// StrategyFactory builds the strategy of a seat from its settings
+type StrategyFactory func(settings AISettings) Strategy
+
+// AI difficulty levels
+const (
+	AIDifficultyEasy   = "easy"   // 随机合法出牌
+	AIDifficultyNormal = "normal" // 启发式规则（AIPlayer 的贪心策略）
+	AIDifficultyHard   = "hard"   // 抽样模拟搜索
+)
+
+// AI personalities, which change how the heuristics (normal and hard) play
+const (
+	AIPersonalityBalanced     = "balanced"     // 默认（也可以不设）
+	AIPersonalityAggressive   = "aggressive"   // 激进：敢甩牌，先出大牌
+	AIPersonalityConservative = "conservative" // 保守：尽量不送分、不出分牌
+)
+
+// AISettings configures how the AI plays one seat (an AI seat, or a player's seat
+// in trusteeship)
+type AISettings struct {
+	Difficulty  string `json:"difficulty"`            // easy, normal, hard, or a registered strategy
+	Personality string `json:"personality,omitempty"` // balanced, aggressive, conservative
+	ThinkMs     int    `json:"thinkMs,omitempty"`     // hard: search budget per play, 0 = AI_THINK_MS
+}
+
+// Defaults for seats without their own settings
+var (
+	defaultAIDifficulty = getEnv("AI_DIFFICULTY", AIDifficultyNormal)
+	defaultAIThinkMs    = getEnvInt("AI_THINK_MS", 800)
+)
+
+// strategies maps a difficulty to the strategy playing it
+// Only written by RegisterStrategy during init, so reads need no lock.
+var strategies = map[string]StrategyFactory{
+	AIDifficultyEasy:   func(AISettings) Strategy { return randomStrategy{} },
+	AIDifficultyNormal: newHeuristicStrategy,
+	AIDifficultyHard:   newHeuristicStrategy,
+}
+
+// RegisterStrategy adds (or replaces) the strategy for a difficulty name
+// Call it from an init function, before any game is played.
+func RegisterStrategy(difficulty string, factory StrategyFactory) {
+	strategies[difficulty] = factory
+}
+
+// IsValidAIDifficulty reports whether d names a registered strategy
+func IsValidAIDifficulty(d string) bool {
+	_, ok := strategies[d]
+	return ok
+}
+
+// IsValidAIPersonality reports whether p is a known personality
+func IsValidAIPersonality(p string) bool {
+	switch p {
+	case "", AIPersonalityBalanced, AIPersonalityAggressive, AIPersonalityConservative:
+		return true
+	}
+	return false
+}
+
+// aiSettingsFor returns the settings of a seat, filling in the defaults
+func aiSettingsFor(hand *PlayerHand) AISettings {
+	settings := AISettings{Difficulty: defaultAIDifficulty, ThinkMs: defaultAIThinkMs}
+	if hand.AI != nil {
+		if hand.AI.Difficulty != "" {
+			settings.Difficulty = hand.AI.Difficulty
+		}
+		if hand.AI.ThinkMs > 0 {
+			settings.ThinkMs = hand.AI.ThinkMs
+		}
+		settings.Personality = hand.AI.Personality
+	}
+	return settings
+}
+
+// strategyFor returns the strategy that plays hand's seat
+// An unknown difficulty (e.g. a strategy no longer registered) plays as normal.
+func strategyFor(hand *PlayerHand) Strategy {
+	settings := aiSettingsFor(hand)
+	factory, ok := strategies[settings.Difficulty]
+	if !ok {
+		factory = strategies[AIDifficultyNormal]
+	}
+	return factory(settings)
+}
+
+// heuristicStrategy plays with AIPlayer: the heuristics (normal) or the search
+// over them (hard), in the seat's personality
+type heuristicStrategy struct {
+	settings AISettings
+}
+
+func newHeuristicStrategy(settings AISettings) Strategy {
+	return heuristicStrategy{settings: settings}
+}
+
+func (s heuristicStrategy) DecidePlay(table *GameTable, seat int) []int {
+	hand := table.PlayerHands[seat]
+	ai := &AIPlayer{
+		UserID:      hand.UserID,
+		SeatNumber:  seat,
+		Hand:        hand.Cards,
+		Difficulty:  s.settings.Difficulty,
+		Personality: s.settings.Personality,
+		ThinkBudget: time.Duration(s.settings.ThinkMs) * time.Millisecond,
+	}
+	return ai.DecidePlay(table)
+}
+
+// maxEasyChoices bounds how many legal plays the easy AI picks from
+const maxEasyChoices = 64
+
+// randomStrategy plays a random legal play (easy)
+type randomStrategy struct{}
+
+func (randomStrategy) DecidePlay(table *GameTable, seat int) []int {
+	hand := table.PlayerHands[seat]
+	plays := legalPlays(table, hand.Cards, nil, maxEasyChoices)
+	if len(plays) == 0 {
+		return findLegalPlay(table, hand)
+	}
+	return plays[rand.Intn(len(plays))]
+}
+
+// legalPlays lists up to limit distinct plays the rules accept, first (if legal)
+// at the front. Identical cards count once, so e.g. a single ♠A is listed once
+// however many copies the hand holds.
+// Leading: every triple, pair and single, biggest first (throws and tractors only
+// through first). Following: the legal combinations of the cards that may be played.
+func legalPlays(table *GameTable, hand []Card, first []int, limit int) [][]int {
+	var plays [][]int
+	seen := make(map[string]bool)
+	add := func(indices []int) {
+		key := playKey(hand, indices)
+		if len(indices) == 0 || seen[key] {
+			return
+		}
+		seen[key] = true
+		cards := make([]Card, len(indices))
+		for i, idx := range indices {
+			cards[i] = hand[idx]
+		}
+		if validateCardPlay(cards, table) == nil {
+			plays = append(plays, append([]int(nil), indices...))
+		}
+	}
+	add(first)
+
+	// 相同的牌只保留一份索引，避免重复的出法
+	byCard := make(map[Card][]int)
+	var distinct []Card
+	for i, card := range hand {
+		if byCard[card] == nil {
+			distinct = append(distinct, card)
+		}
+		byCard[card] = append(byCard[card], i)
+	}
+	sort.SliceStable(distinct, func(a, b int) bool {
+		return getCardRank(distinct[a], table.TrumpSuit, table.TrumpRank) >
+			getCardRank(distinct[b], table.TrumpSuit, table.TrumpRank)
+	})
+
+	if len(table.TrickPlays) == 0 {
+		// 先三张、对子，再单张，从大到小
+		for size := 3; size >= 1; size-- {
+			for _, card := range distinct {
+				if len(byCard[card]) >= size && len(plays) < limit {
+					add(byCard[card][:size])
+				}
+			}
+		}
+		return plays
+	}
+
+	need := len(table.TrickPlays[0].Cards)
+	leadSuit := table.TrickPlays[0].Cards[0].Suit
+	pool := distinct
+	if countSuit(hand, leadSuit) >= need {
+		pool = nil
+		for _, card := range distinct {
+			if card.Suit == leadSuit {
+				pool = append(pool, card)
+			}
+		}
+	}
+
+	tried := 0
+	chosen := make([]int, 0, need)
+	var search func(start int)
+	search = func(start int) {
+		if len(plays) >= limit || tried >= maxPlaySearch {
+			return
+		}
+		if len(chosen) == need {
+			tried++
+			add(chosen)
+			return
+		}
+		for i := start; i < len(pool); i++ {
+			// 同一种牌取 1..n 张
+			copies := byCard[pool[i]]
+			for n := 1; n <= len(copies) && len(chosen)+n <= need; n++ {
+				chosen = append(chosen, copies[:n]...)
+				search(i + 1)
+				chosen = chosen[:len(chosen)-n]
+			}
+		}
+	}
+	search(0)
+	return plays
+}
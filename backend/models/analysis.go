@@ -0,0 +1,406 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Post-game analysis: a finished game is replayed from its action log with all
+// five hands known. At every play with a choice, each legal alternative (up to
+// maxAnalysisCandidates) and the play actually made are scored by an alpha-beta
+// search on the real cards (see alphaBeta): the catching team (抓分方) plays for
+// the most points, the dealer's side for the fewest, every seat trying at most
+// analysisBranching plays with the heuristic AI's choice first. The search only
+// looks to the end of the current trick; from there the heuristic AI plays the
+// hand out. Once the hands are down to analysisEndgameCards cards it searches to
+// the end of the hand. Leads are searched as singles, pairs and triples only
+// (see legalPlays), and the sides are those the game ended with.
+// A play costs its side the difference to the best alternative; alternatives the
+// search cannot score are left out. Only card plays are analysed: the bid, the
+// discard (扣底) and the friend call are not.
+// Analyses run as background jobs, ANALYSIS_WORKERS at a time, and are kept in
+// game_analyses.
+
+// Search bounds; variables so tests can keep the search small
+var (
+	// maxAnalysisCandidates bounds the alternatives compared at one play
+	maxAnalysisCandidates = 16
+	// analysisBranching bounds the plays each seat tries inside the search
+	analysisBranching = 3
+	// analysisEndgameCards is the hand size from which the search runs to the end
+	analysisEndgameCards = 2
+)
+
+// DefaultMistakeLoss is how many points a play must cost to count as a mistake
+const DefaultMistakeLoss = 30
+
+// analysisWorkers limits the analyses running at once
+var analysisWorkers = make(chan struct{}, max(1, getEnvInt("ANALYSIS_WORKERS", 1)))
+
+// analysisJobs holds the games being analysed
+var analysisJobs = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: make(map[string]bool)}
+
+// AnalyzedPlay is one play compared with its alternatives
+type AnalyzedPlay struct {
+	Step         int    `json:"step"` // replay step of the play (see ReplayFrame)
+	Trick        int    `json:"trick"`
+	Seat         int    `json:"seat"`
+	HostTeam     bool   `json:"hostTeam"` // the seat was on the dealer's side
+	Played       []Card `json:"played"`
+	PlayedPoints int    `json:"playedPoints"` // 抓分方最终得分，实际出牌后搜索
+	Best         []Card `json:"best"`
+	BestPoints   int    `json:"bestPoints"`   // 最佳出法搜索的抓分方得分
+	Loss         int    `json:"loss"`         // points the play cost the seat's side, 0 if it was the best
+	Alternatives int    `json:"alternatives"` // legal plays compared
+
+	options []playOption // every alternative, until the sides are known
+}
+
+// playOption is one alternative and the catching team's points after it
+type playOption struct {
+	cards  []Card
+	points int
+}
+
+// GameAnalysis is the analysis of every play of a game
+type GameAnalysis struct {
+	GameID    string         `json:"gameId"`
+	Plays     []AnalyzedPlay `json:"plays"`            // every play that had a choice
+	Points    int            `json:"points,omitempty"` // the most the catching team can score, see dealPoints
+	Error     string         `json:"error,omitempty"`  // the game could not be analysed
+	Duration  time.Duration  `json:"duration"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// SeatAnalysis sums up the mistakes of one seat
+type SeatAnalysis struct {
+	Seat       int  `json:"seat"`
+	HostTeam   bool `json:"hostTeam"`
+	Plays      int  `json:"plays"`
+	Mistakes   int  `json:"mistakes"`
+	PointsLost int  `json:"pointsLost"` // summed estimates, at most Points
+}
+
+// AnalyzeActions analyses the plays of a finished game from its action log
+func AnalyzeActions(gameID string, actions []GameActionLog) (*GameAnalysis, error) {
+	start := time.Now()
+	analysis := &GameAnalysis{GameID: gameID, Plays: make([]AnalyzedPlay, 0)}
+
+	// 阵营按实际结局算：抓分方要多得分，庄家方要少丢分
+	final, err := ReplayActions(actions)
+	if err != nil {
+		return nil, err
+	}
+	if final.Status != "finished" {
+		return nil, fmt.Errorf("the game is not finished")
+	}
+	hostTeam := func(seat int) bool {
+		return seat == final.DealerSeat || (final.FriendRevealed && seat == final.FriendSeat)
+	}
+
+	var table *GameTable
+	for i, action := range actions {
+		if action.ActionType == "play_cards" && table != nil {
+			if analysis.Points == 0 {
+				analysis.Points = dealPoints(table)
+			}
+			var data cardIndicesAction
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			if play, ok := analyzePlay(table, action.PlayerSeat, data.CardIndices, hostTeam); ok {
+				play.Step = i + 1
+				analysis.Plays = append(analysis.Plays, play)
+			}
+		}
+
+		next, err := Apply(table, action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d (%s): %w", i+1, action.ActionType, err)
+		}
+		table = next
+	}
+
+	for i := range analysis.Plays {
+		analysis.Plays[i].pickBest()
+	}
+	analysis.Duration = time.Since(start)
+	analysis.CreatedAt = time.Now()
+	return analysis, nil
+}
+
+// analyzePlay searches the seat's legal plays and the one it made
+// Returns false when the seat had no choice or the play made cannot be scored.
+func analyzePlay(table *GameTable, seat int, played []int, hostTeam func(int) bool) (AnalyzedPlay, bool) {
+	hand, ok := table.PlayerHands[seat]
+	if !ok {
+		return AnalyzedPlay{}, false
+	}
+	candidates := legalPlays(table, hand.Cards, played, maxAnalysisCandidates)
+	if len(candidates) < 2 {
+		return AnalyzedPlay{}, false
+	}
+
+	result := AnalyzedPlay{
+		Trick:    len(table.TrickHistory) + 1,
+		Seat:     seat,
+		HostTeam: hostTeam(seat),
+		Played:   cardsAt(hand.Cards, played),
+	}
+	playedKey := playKey(hand.Cards, played)
+	foundPlayed := false
+	for _, candidate := range candidates {
+		points, ok := searchPoints(table, seat, candidate, hostTeam)
+		if !ok {
+			// 推演不下去的出法不参与比较，免得被当成最佳出法
+			continue
+		}
+		result.options = append(result.options, playOption{cards: cardsAt(hand.Cards, candidate), points: points})
+		if playKey(hand.Cards, candidate) == playedKey {
+			result.PlayedPoints, foundPlayed = points, true
+		}
+	}
+	// 甩牌等不在候选里的出法单独推演
+	if !foundPlayed {
+		result.PlayedPoints, foundPlayed = searchPoints(table, seat, played, hostTeam)
+	}
+	if !foundPlayed || len(result.options) == 0 {
+		return AnalyzedPlay{}, false
+	}
+	result.Alternatives = len(result.options)
+	return result, true
+}
+
+// searchPoints is the catching team's points after seat plays cardIndices and
+// both sides play on as alphaBeta finds best
+// Returns false if the rules refuse the play or no line after it can be scored.
+func searchPoints(table *GameTable, seat int, cardIndices []int, hostTeam func(int) bool) (int, bool) {
+	next := table.Clone()
+	if err := simulatePlay(next, seat, cardIndices); err != nil {
+		return 0, false
+	}
+	return alphaBeta(next, hostTeam, math.MinInt, math.MaxInt)
+}
+
+// alphaBeta is the catching team's points with every seat playing its best,
+// searched to the end of the trick (to the end of the hand in the endgame) and
+// played out by the heuristic AI from there
+// Returns false if no line could be scored.
+func alphaBeta(table *GameTable, hostTeam func(int) bool, alpha, beta int) (int, bool) {
+	if table.Status != "playing" {
+		return catchingPoints(table), true
+	}
+	seat := table.CurrentPlayer
+	hand := table.PlayerHands[seat]
+	limit := analysisBranching
+	if len(table.TrickPlays) == 0 {
+		if len(hand.Cards) > analysisEndgameCards {
+			leaf := table.Clone()
+			if !finishHand(leaf) {
+				return 0, false
+			}
+			return catchingPoints(leaf), true
+		}
+		// 残局：每家只剩几张牌，所有出法都搜
+		limit = math.MaxInt
+	}
+
+	ai := &AIPlayer{UserID: hand.UserID, SeatNumber: seat, Hand: hand.Cards}
+	maximize := !hostTeam(seat)
+	best, found := 0, false
+	for _, play := range legalPlays(table, hand.Cards, ai.DecidePlay(table), limit) {
+		child := table.Clone()
+		if err := simulatePlay(child, seat, play); err != nil {
+			continue
+		}
+		points, ok := alphaBeta(child, hostTeam, alpha, beta)
+		if !ok {
+			continue
+		}
+		if !found || (maximize && points > best) || (!maximize && points < best) {
+			best, found = points, true
+		}
+		if maximize {
+			alpha = max(alpha, best)
+		} else {
+			beta = min(beta, best)
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best, found
+}
+
+// dealPoints is the most the catching team can score: every card in the deal,
+// with the bottom counted twice as handResult does
+func dealPoints(table *GameTable) int {
+	points := 2 * cardPoints(table.BottomCards)
+	for _, hand := range table.PlayerHands {
+		points += cardPoints(hand.Cards) + cardPoints(hand.Collected)
+	}
+	for _, play := range table.TrickPlays {
+		points += cardPoints(play.Cards)
+	}
+	return points
+}
+
+// pickBest picks the best alternative for the seat's side and what the play cost
+func (p *AnalyzedPlay) pickBest() {
+	p.Best, p.BestPoints, p.Loss = p.Played, p.PlayedPoints, 0
+	for _, option := range p.options {
+		loss := option.points - p.PlayedPoints
+		if p.HostTeam {
+			loss = -loss
+		}
+		if loss > p.Loss {
+			p.Best, p.BestPoints, p.Loss = option.cards, option.points, loss
+		}
+	}
+	p.options = nil
+}
+
+// cardsAt returns the cards at indices
+func cardsAt(hand []Card, indices []int) []Card {
+	cards := make([]Card, 0, len(indices))
+	for _, idx := range indices {
+		cards = append(cards, hand[idx])
+	}
+	return cards
+}
+
+// Mistakes returns the plays that cost at least minLoss points
+func (a *GameAnalysis) Mistakes(minLoss int) []AnalyzedPlay {
+	mistakes := make([]AnalyzedPlay, 0)
+	for _, play := range a.Plays {
+		if play.Loss > 0 && play.Loss >= minLoss {
+			mistakes = append(mistakes, play)
+		}
+	}
+	return mistakes
+}
+
+// Seats sums up the plays and mistakes of every seat
+func (a *GameAnalysis) Seats(minLoss int) []SeatAnalysis {
+	seats := make([]SeatAnalysis, 5)
+	for i := range seats {
+		seats[i].Seat = i + 1
+	}
+	for _, play := range a.Plays {
+		if play.Seat < 1 || play.Seat > len(seats) {
+			continue
+		}
+		s := &seats[play.Seat-1]
+		s.HostTeam = play.HostTeam
+		s.Plays++
+		if play.Loss > 0 && play.Loss >= minLoss {
+			s.Mistakes++
+			s.PointsLost += play.Loss
+		}
+	}
+	// 每手的估计各自独立，加起来会重复计算同一批分，不超过整副牌的分
+	for i := range seats {
+		if a.Points > 0 && seats[i].PointsLost > a.Points {
+			seats[i].PointsLost = a.Points
+		}
+	}
+	return seats
+}
+
+// GetGameAnalysis returns the analysis of a finished game
+// If the game has not been analysed yet the job is started and nil is
+// returned; ask again once it is done.
+func GetGameAnalysis(gameID string) (*GameAnalysis, error) {
+	analysis, err := loadGameAnalysis(gameID)
+	if err != nil || analysis != nil {
+		return analysis, err
+	}
+	startGameAnalysis(gameID)
+	return nil, nil
+}
+
+// startGameAnalysis analyses the game in the background, once at a time
+func startGameAnalysis(gameID string) {
+	analysisJobs.Lock()
+	defer analysisJobs.Unlock()
+	if analysisJobs.running[gameID] {
+		return
+	}
+	analysisJobs.running[gameID] = true
+
+	go func() {
+		defer func() {
+			analysisJobs.Lock()
+			delete(analysisJobs.running, gameID)
+			analysisJobs.Unlock()
+		}()
+		analysisWorkers <- struct{}{}
+		defer func() { <-analysisWorkers }()
+
+		actions, err := GetGameActionLogs(gameID)
+		if err != nil {
+			log.Printf("Warning: analysis of game %s: %v", gameID, err)
+			return
+		}
+		if err := saveGameAnalysis(analyzeGame(gameID, actions)); err != nil {
+			log.Printf("Warning: failed to save analysis of game %s: %v", gameID, err)
+		}
+	}()
+}
+
+// analyzeGame runs AnalyzeActions, turning a failure into an analysis that says so
+func analyzeGame(gameID string, actions []GameActionLog) (analysis *GameAnalysis) {
+	defer func() {
+		if r := recover(); r != nil {
+			analysis = &GameAnalysis{GameID: gameID, Plays: make([]AnalyzedPlay, 0), Error: fmt.Sprintf("panic: %v", r), CreatedAt: time.Now()}
+		}
+	}()
+	analysis, err := AnalyzeActions(gameID, actions)
+	if err != nil {
+		return &GameAnalysis{GameID: gameID, Plays: make([]AnalyzedPlay, 0), Error: err.Error(), CreatedAt: time.Now()}
+	}
+	return analysis
+}
+
+// loadGameAnalysis reads a stored analysis; nil if there is none
+func loadGameAnalysis(gameID string) (*GameAnalysis, error) {
+	var reportJSON []byte
+	err := db.QueryRow(`SELECT report FROM game_analyses WHERE game_id = $1`, gameID).Scan(&reportJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query game analysis: %w", err)
+	}
+
+	var analysis GameAnalysis
+	if err := json.Unmarshal(reportJSON, &analysis); err != nil {
+		return nil, fmt.Errorf("failed to decode game analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// saveGameAnalysis stores an analysis, replacing any earlier one
+func saveGameAnalysis(analysis *GameAnalysis) error {
+	reportJSON, err := json.Marshal(analysis)
+	if err != nil {
+		return fmt.Errorf("failed to marshal game analysis: %w", err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO game_analyses (game_id, report, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (game_id) DO UPDATE SET report = EXCLUDED.report, created_at = EXCLUDED.created_at
+	`, analysis.GameID, reportJSON, analysis.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save game analysis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestSeatsCapsPointsLost checks that overlapping per-play estimates never add
+// up to more than the deal can score
+func TestSeatsCapsPointsLost(t *testing.T) {
+	analysis := &GameAnalysis{Points: 200, Plays: []AnalyzedPlay{
+		{Seat: 2, Loss: 120},
+		{Seat: 2, Loss: 110},
+		{Seat: 3, Loss: 40},
+		{Seat: 3, Loss: 10},
+	}}
+	seats := analysis.Seats(DefaultMistakeLoss)
+	if got := seats[1].PointsLost; got != 200 {
+		t.Errorf("seat 2 lost %d, want it capped at 200", got)
+	}
+	if got := seats[2]; got.PointsLost != 40 || got.Mistakes != 1 || got.Plays != 2 {
+		t.Errorf("seat 3: %+v, want 1 mistake in 2 plays losing 40", got)
+	}
+}
+
+// giftStrategy plays like the normal AI, except that as the last seat of a
+// trick the dealer is winning it throws in its most points without taking the
+// trick. gifts records the trick of every such play.
+type giftStrategy struct {
+	heuristic Strategy
+	gifts     *[]int
+}
+
+func (s giftStrategy) DecidePlay(table *GameTable, seat int) []int {
+	hand := table.PlayerHands[seat]
+	plays := table.TrickPlays
+	if seat == table.DealerSeat || len(plays) != len(table.PlayerHands)-1 ||
+		determineTrickWinner(plays, table.TrumpSuit, table.TrumpRank) != table.DealerSeat {
+		return s.heuristic.DecidePlay(table, seat)
+	}
+
+	var gift []int
+	most, least := -1, -1
+	for _, play := range legalPlays(table, hand.Cards, nil, maxSearchCandidates) {
+		cards := cardsAt(hand.Cards, play)
+		trick := append(append([]TrickPlay(nil), plays...), TrickPlay{Seat: seat, Cards: cards})
+		if determineTrickWinner(trick, table.TrumpSuit, table.TrumpRank) == seat {
+			continue
+		}
+		points := cardPoints(cards)
+		if points > most {
+			gift, most = play, points
+		}
+		if least < 0 || points < least {
+			least = points
+		}
+	}
+	if gift == nil || most == least {
+		return s.heuristic.DecidePlay(table, seat)
+	}
+	*s.gifts = append(*s.gifts, len(table.TrickHistory)+1)
+	return gift
+}
+
+// TestAnalyzeFlagsGift analyses a self-play hand in which a defender hands
+// points to the dealer, and checks that the gifts are flagged
+func TestAnalyzeFlagsGift(t *testing.T) {
+	var gifts []int
+	RegisterStrategy("test_gift", func(settings AISettings) Strategy {
+		return giftStrategy{heuristic: newHeuristicStrategy(settings), gifts: &gifts}
+	})
+	defer delete(strategies, "test_gift")
+
+	// 小范围搜索，测试跑得快
+	candidates, branching, endgame := maxAnalysisCandidates, analysisBranching, analysisEndgameCards
+	maxAnalysisCandidates, analysisBranching, analysisEndgameCards = 4, 2, 1
+	defer func() {
+		maxAnalysisCandidates, analysisBranching, analysisEndgameCards = candidates, branching, endgame
+	}()
+
+	const seed, giftSeat = 1, 1
+	stop := captureActions(simulatedGameID(seed))
+	hand := SimulateHand(seed, map[int]AISettings{giftSeat: {Difficulty: "test_gift"}})
+	actions := stop()
+	if hand.Error != "" {
+		t.Fatalf("self-play: %s", hand.Error)
+	}
+	if hand.IsHostTeam(giftSeat) || len(gifts) == 0 {
+		t.Fatalf("seat %d (host team %v) made no gift", giftSeat, hand.IsHostTeam(giftSeat))
+	}
+
+	analysis, err := AnalyzeActions(simulatedGameID(seed), actions)
+	if err != nil {
+		t.Fatalf("AnalyzeActions: %v", err)
+	}
+	// 送出的分本该留着：最佳出法分更少，损失为正
+	for _, play := range analysis.Plays {
+		if play.Seat == giftSeat && slices.Contains(gifts, play.Trick) &&
+			play.Loss > 0 && cardPoints(play.Played) > cardPoints(play.Best) {
+			return
+		}
+	}
+	t.Errorf("none of seat %d's gifts (tricks %v) flagged", giftSeat, gifts)
+}
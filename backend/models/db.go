@@ -73,6 +73,15 @@ func InitDB() error {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	// 早先的回放不知道是否1打4、是否抠底，后台按动作日志补齐
+	go func() {
+		if n, err := backfillReplayFacets(); err != nil {
+			log.Println("Warning: failed to backfill game_replays facets:", err)
+		} else if n > 0 {
+			log.Printf("Backfilled the facets of %d game replays", n)
+		}
+	}()
+
 	return nil
 }
 
@@ -100,6 +109,11 @@ func createTables() error {
 		return fmt.Errorf("failed to create users table: %w", err)
 	}
 
+	// 导入对局的座位用占位用户，不能登录
+	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS login_disabled BOOLEAN DEFAULT FALSE`); err != nil {
+		return fmt.Errorf("failed to add login_disabled column to users table: %w", err)
+	}
+
 	// Create index on username
 	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`); err != nil {
 		log.Println("Warning: failed to create username index:", err)
@@ -123,6 +137,46 @@ func createTables() error {
 		return fmt.Errorf("failed to create games table: %w", err)
 	}
 
+	// 发牌种子：seed_hash 在发牌前公开，deal_seed 结束后公开
+	gamesSeedColumns := `
+	ALTER TABLE games
+		ADD COLUMN IF NOT EXISTS deal_seed BIGINT,
+		ADD COLUMN IF NOT EXISTS seed_hash VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS seed_fixed BOOLEAN DEFAULT FALSE`
+
+	if _, err := db.Exec(gamesSeedColumns); err != nil {
+		return fmt.Errorf("failed to add seed columns to games table: %w", err)
+	}
+
+	// Create matches table: a match is a series of hands (games) until someone wins at A
+	matchesTable := `
+	CREATE TABLE IF NOT EXISTS matches (
+		id VARCHAR(64) PRIMARY KEY,
+		host_id VARCHAR(64) NOT NULL,
+		status VARCHAR(20) DEFAULT 'waiting',
+		single_player BOOLEAN DEFAULT FALSE,
+		base_seed BIGINT,
+		champion_id VARCHAR(64),
+		state JSONB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (host_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+
+	if _, err := db.Exec(matchesTable); err != nil {
+		return fmt.Errorf("failed to create matches table: %w", err)
+	}
+
+	// 每一局属于一场比赛，hand_number 从1开始
+	gamesMatchColumns := `
+	ALTER TABLE games
+		ADD COLUMN IF NOT EXISTS match_id VARCHAR(64) REFERENCES matches(id) ON DELETE SET NULL,
+		ADD COLUMN IF NOT EXISTS hand_number INT DEFAULT 0`
+
+	if _, err := db.Exec(gamesMatchColumns); err != nil {
+		return fmt.Errorf("failed to add match columns to games table: %w", err)
+	}
+
 	// Create game_players table for many-to-many relationship
 	gamePlayersTable := `
 	CREATE TABLE IF NOT EXISTS game_players (
@@ -194,7 +248,7 @@ func createTables() error {
 		duration_seconds INT DEFAULT 0,
 		winner_team VARCHAR(20),
 		final_score INT DEFAULT 0,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
 	)`
 
@@ -202,6 +256,127 @@ func createTables() error {
 		return fmt.Errorf("failed to create game_replays table: %w", err)
 	}
 
+	// 按时间检索时和带时区的参数比较；早先建的表是 TIMESTAMP，原有的值按
+	// 会话时区（写入时 CURRENT_TIMESTAMP 用的时区）换算
+	var createdAtType string
+	err := db.QueryRow(`SELECT data_type FROM information_schema.columns WHERE table_name = 'game_replays' AND column_name = 'created_at'`).Scan(&createdAtType)
+	if err != nil {
+		return fmt.Errorf("failed to read game_replays.created_at type: %w", err)
+	}
+	if createdAtType == "timestamp without time zone" {
+		if _, err := db.Exec(`ALTER TABLE game_replays ALTER COLUMN created_at TYPE TIMESTAMPTZ`); err != nil {
+			return fmt.Errorf("failed to convert game_replays.created_at to TIMESTAMPTZ: %w", err)
+		}
+	}
+
+	// 回放检索用的字段（见 replay_search.go）
+	// solo_mode、bottom_taken、bottom_points 为 NULL 表示还不知道（早先的回放，
+	// 由 backfillReplayFacets 按动作日志补齐），按这两项筛选时不会被选中；
+	// 动作日志无法重放的记 facets_failed，以后启动不再重试
+	gameReplaysFacetColumns := `
+	ALTER TABLE game_replays
+		ADD COLUMN IF NOT EXISTS dealer_seat INT DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS dealer_id VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS level VARCHAR(10),
+		ADD COLUMN IF NOT EXISTS solo_mode BOOLEAN,
+		ADD COLUMN IF NOT EXISTS bottom_taken BOOLEAN,
+		ADD COLUMN IF NOT EXISTS bottom_points INT,
+		ADD COLUMN IF NOT EXISTS facets_failed BOOLEAN NOT NULL DEFAULT FALSE,
+		ALTER COLUMN solo_mode DROP DEFAULT,
+		ALTER COLUMN bottom_taken DROP DEFAULT,
+		ALTER COLUMN bottom_points DROP DEFAULT`
+
+	if _, err := db.Exec(gameReplaysFacetColumns); err != nil {
+		return fmt.Errorf("failed to add facet columns to game_replays table: %w", err)
+	}
+
+	// 早先的回放先从 initial_state 取庄家和级数，其余留空等按动作日志补齐
+	backfillReplayFacets := `
+	UPDATE game_replays r SET
+		dealer_seat = COALESCE((r.initial_state->>'dealerSeat')::int, 0),
+		level = COALESCE(r.initial_state->>'trumpRank', ''),
+		dealer_id = (SELECT gp.user_id FROM game_players gp
+			WHERE gp.game_id = r.game_id AND gp.seat_number = (r.initial_state->>'dealerSeat')::int LIMIT 1),
+		solo_mode = NULL,
+		bottom_taken = NULL,
+		bottom_points = NULL
+	WHERE r.level IS NULL`
+
+	if _, err := db.Exec(backfillReplayFacets); err != nil {
+		log.Println("Warning: failed to backfill game_replays facets:", err)
+	}
+
+	replayIndexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_created_at_id ON game_replays(created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_dealer_id ON game_replays(dealer_id, created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_level ON game_replays(level, created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_final_score ON game_replays(final_score)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_solo ON game_replays(created_at DESC, id DESC) WHERE solo_mode`,
+		`CREATE INDEX IF NOT EXISTS idx_game_replays_bottom_taken ON game_replays(created_at DESC, id DESC) WHERE bottom_taken`,
+		`CREATE INDEX IF NOT EXISTS idx_game_players_user_id ON game_players(user_id, game_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_records_user_id ON game_records(user_id, game_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_game_records_game_id ON game_records(game_id)`,
+	}
+	for _, index := range replayIndexes {
+		if _, err := db.Exec(index); err != nil {
+			log.Println("Warning: failed to create replay index:", err)
+		}
+	}
+
+	// Create game_table_snapshots table for persisting in-flight tables across restarts
+	gameTableSnapshotsTable := `
+	CREATE TABLE IF NOT EXISTS game_table_snapshots (
+		game_id VARCHAR(64) PRIMARY KEY,
+		state JSONB NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	)`
+
+	if _, err := db.Exec(gameTableSnapshotsTable); err != nil {
+		return fmt.Errorf("failed to create game_table_snapshots table: %w", err)
+	}
+
+	// Finished tables leave the store and their snapshots go with them (see
+	// GameStore.evictLater); drop the ones kept from before
+	if _, err := db.Exec(`DELETE FROM game_table_snapshots WHERE status = 'finished'`); err != nil {
+		return fmt.Errorf("failed to delete finished table snapshots: %w", err)
+	}
+
+	// Create game_analyses table for post-game analyses (see analysis.go)
+	gameAnalysesTable := `
+	CREATE TABLE IF NOT EXISTS game_analyses (
+		game_id VARCHAR(64) PRIMARY KEY,
+		report JSONB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	)`
+
+	if _, err := db.Exec(gameAnalysesTable); err != nil {
+		return fmt.Errorf("failed to create game_analyses table: %w", err)
+	}
+
+	// Create replay_shares table for public replay links (see replay_share.go)
+	replaySharesTable := `
+	CREATE TABLE IF NOT EXISTS replay_shares (
+		token VARCHAR(64) PRIMARY KEY,
+		game_id VARCHAR(64) NOT NULL,
+		created_by VARCHAR(64) NOT NULL,
+		anonymize BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE,
+		FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+	)`
+
+	if _, err := db.Exec(replaySharesTable); err != nil {
+		return fmt.Errorf("failed to create replay_shares table: %w", err)
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_replay_shares_game_id ON replay_shares(game_id, created_by)`); err != nil {
+		log.Println("Warning: failed to create replay_shares index:", err)
+	}
+
 	log.Println("Database tables created/verified successfully")
 	return nil
 }
@@ -8,4 +8,5 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrGameNotFound       = errors.New("game not found")
 	ErrGameFull           = errors.New("game is full")
+	ErrMatchNotFound      = errors.New("match not found")
 )
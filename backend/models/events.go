@@ -0,0 +1,153 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// GameEvent is a typed table update pushed to connected clients
+// Data is visible to every seat; Private holds per-seat data (e.g. the dealer's
+// hand after picking up the bottom) and is merged in by ForSeat.
+type GameEvent struct {
+	Type      string                         `json:"type"` // call_dealer, flip_bottom, call_countdown_end, discard_bottom, call_friend, play_cards, trick_complete, game_end, turn_deadline, trusteeship
+	GameID    string                         `json:"gameId"`
+	Seat      int                            `json:"seat"` // Seat that produced the event (0 = system)
+	Data      map[string]interface{}         `json:"data"`
+	Private   map[int]map[string]interface{} `json:"-"`
+	Timestamp int64                          `json:"timestamp"`
+}
+
+// SeatEvent is the projection of a GameEvent delivered to one seat
+type SeatEvent struct {
+	Type      string                 `json:"type"`
+	GameID    string                 `json:"gameId"`
+	Seat      int                    `json:"seat"`
+	Data      map[string]interface{} `json:"data"`
+	Private   map[string]interface{} `json:"private,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+// ForSeat returns the view of the event that the given seat is allowed to see
+// seat 0 (spectator) only receives the public data
+func (e GameEvent) ForSeat(seat int) SeatEvent {
+	return SeatEvent{
+		Type:      e.Type,
+		GameID:    e.GameID,
+		Seat:      e.Seat,
+		Data:      e.Data,
+		Private:   e.Private[seat],
+		Timestamp: e.Timestamp,
+	}
+}
+
+// GameSubscription receives events for a single game
+type GameSubscription struct {
+	GameID string
+	Events chan GameEvent
+
+	once sync.Once
+}
+
+// eventBufferSize is how many events a subscriber may lag behind before it is dropped
+const eventBufferSize = 64
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = make(map[string]map[*GameSubscription]struct{})
+)
+
+// SubscribeGameEvents registers a listener for all events of a game
+// The caller must Close the subscription when done.
+func SubscribeGameEvents(gameID string) *GameSubscription {
+	sub := &GameSubscription{
+		GameID: gameID,
+		Events: make(chan GameEvent, eventBufferSize),
+	}
+
+	subscribersMu.Lock()
+	if subscribers[gameID] == nil {
+		subscribers[gameID] = make(map[*GameSubscription]struct{})
+	}
+	subscribers[gameID][sub] = struct{}{}
+	subscribersMu.Unlock()
+
+	return sub
+}
+
+// Close unregisters the subscription and closes its channel
+func (s *GameSubscription) Close() {
+	s.once.Do(func() {
+		subscribersMu.Lock()
+		if subs, ok := subscribers[s.GameID]; ok {
+			delete(subs, s)
+			if len(subs) == 0 {
+				delete(subscribers, s.GameID)
+			}
+		}
+		subscribersMu.Unlock()
+		close(s.Events)
+	})
+}
+
+// publishGameEvent delivers an event to every subscriber of the game
+// Slow subscribers are dropped (their channel is closed) so a stuck client
+// never blocks the game; they are expected to reconnect and get a new snapshot.
+func publishGameEvent(evt GameEvent) {
+	if evt.Timestamp == 0 {
+		evt.Timestamp = time.Now().UnixNano()
+	}
+
+	var lagging []*GameSubscription
+
+	subscribersMu.RLock()
+	for sub := range subscribers[evt.GameID] {
+		select {
+		case sub.Events <- evt:
+		default:
+			lagging = append(lagging, sub)
+		}
+	}
+	subscribersMu.RUnlock()
+
+	for _, sub := range lagging {
+		sub.Close()
+	}
+}
+
+// newTableEvent builds an event carrying the public table fields every client needs
+// to keep its state in sync (status, turn, dealer and trump)
+func newTableEvent(table *GameTable, eventType string, seat int, data map[string]interface{}) GameEvent {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["status"] = table.Status
+	data["callPhase"] = table.CallPhase
+	data["currentPlayer"] = table.CurrentPlayer
+	data["dealerSeat"] = table.DealerSeat
+	data["trumpSuit"] = table.TrumpSuit
+	data["trumpRank"] = table.TrumpRank
+
+	return GameEvent{
+		Type:      eventType,
+		GameID:    table.GameID,
+		Seat:      seat,
+		Data:      data,
+		Timestamp: time.Now().UnixNano(),
+	}
+}
+
+// withHandUpdate attaches the seat's current hand as private data
+// Used when a hand changes other than by public plays (picking up or burying the bottom).
+func (e GameEvent) withHandUpdate(table *GameTable, seat int) GameEvent {
+	hand, ok := table.PlayerHands[seat]
+	if !ok {
+		return e
+	}
+	if e.Private == nil {
+		e.Private = make(map[int]map[string]interface{})
+	}
+	e.Private[seat] = map[string]interface{}{
+		"hand": copyCards(hand.Cards),
+	}
+	return e
+}
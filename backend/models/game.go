@@ -2,9 +2,13 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,6 +23,17 @@ type GameState struct {
 	CurrentLevel string    `json:"currentLevel"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
+
+	// 发牌种子（commit-reveal）：创建时只公开SeedHash，结束后才公开Seed
+	DealSeed  int64  `json:"-"`
+	HasSeed   bool   `json:"-"`
+	SeedHash  string `json:"seedHash"`
+	SeedFixed bool   `json:"seedFixed"`      // 种子由创建者指定（测试/复现用）
+	Seed      *int64 `json:"seed,omitempty"` // 仅在对局结束后公开
+
+	// 所属比赛：每个房间是一场比赛的第一局，后续局自动创建
+	MatchID    string `json:"matchId,omitempty"`
+	HandNumber int    `json:"handNumber,omitempty"`
 }
 
 // Card represents a playing card
@@ -37,6 +52,13 @@ type PlayerHand struct {
 	HasCalled  bool   `json:"hasCalled"` // Whether they've called a card
 	Score      int    `json:"score"`     // Current round score
 	Collected  []Card `json:"collected"` // Cards collected (scoring cards)
+	Level      string `json:"level"`     // 本局开始时该玩家的等级（各自记级）
+	Timeouts   int    `json:"timeouts"`  // 连续超时次数，自己出手后清零
+	// Trusteeship 托管：连续超时、掉线或主动托管后由服务器代为出牌，不再等满时限
+	Trusteeship       bool   `json:"trusteeship"`
+	TrusteeshipReason string `json:"trusteeshipReason,omitempty"` // timeout, disconnected, manual
+	// AI 代打时的设置（难度、思考时间）；nil 表示用默认值
+	AI *AISettings `json:"ai,omitempty"`
 }
 
 // GameTable represents the active game table
@@ -53,8 +75,10 @@ type GameTable struct {
 	BottomCards    []Card              `json:"bottomCards"`    // 7 bottom cards
 	CurrentPlayer  int                 `json:"currentPlayer"`  // Current player's seat (1-5)
 	CurrentTrick   []PlayedCard        `json:"currentTrick"`   // Cards in current trick
+	TrickPlays     []TrickPlay         `json:"trickPlays"`     // Plays in current trick, one per seat in play order
 	TrickLeader    int                 `json:"trickLeader"`    // Who led the current trick
 	TricksWon      [][]Card            `json:"tricksWon"`      // All tricks won by defender team
+	TrickHistory   []CompletedTrick    `json:"trickHistory"`   // Every finished trick with its plays, in order
 	PlayerHands    map[int]*PlayerHand `json:"playerHands"`    // Seat -> PlayerHand
 	LastPlay       *PlayResult         `json:"lastPlay"`       // Last play result
 	CreatedAt      time.Time           `json:"createdAt"`
@@ -69,6 +93,15 @@ type GameTable struct {
 	TrumpRank          string       `json:"trumpRank"`          // 级牌点数（如"2"表示打2级）
 	FlippedBottomCards []Card       `json:"flippedBottomCards"` // 已翻开的底牌
 	CallRecords        []CallRecord `json:"callRecords"`        // 抢庄记录
+
+	// Deadline is when the current turn times out (unix ms, 0 = no limit)
+	// 超时后服务器代为操作，见 timer.go
+	Deadline int64  `json:"deadline"`
+	turnKey  string // what the deadline was set for; not persisted, see restoreTurnKey
+
+	// afterUnlock is slow work a command leaves for after the table lock (see
+	// GameTable.afterCommand); never persisted or cloned
+	afterUnlock []func()
 }
 
 // CallRecord represents a bid for dealer
@@ -96,6 +129,23 @@ type PlayedCard struct {
 	Timestamp int64 `json:"timestamp"`
 }
 
+// TrickPlay is one seat's whole play in the current trick
+// A trick is complete once every seat has played; the winner is decided by
+// comparing whole plays, not single cards (RULE.md §5.4/5.5).
+type TrickPlay struct {
+	Seat      int    `json:"seat"`
+	Cards     []Card `json:"cards"`
+	IsLead    bool   `json:"isLead"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// CompletedTrick is a finished trick: who played what, and who won it
+// Everything in it is public, so it is what the AI counts cards from.
+type CompletedTrick struct {
+	Plays  []TrickPlay `json:"plays"`
+	Winner int         `json:"winner"`
+}
+
 // PlayResult represents the result of a card play
 type PlayResult struct {
 	Success       bool         `json:"success"`
@@ -109,9 +159,6 @@ type PlayResult struct {
 	GameResults   []GameResult `json:"gameResults,omitempty"`
 }
 
-// In-memory game storage (in production, use Redis or similar)
-var activeGames = make(map[string]*GameTable)
-
 // StartGame initializes and starts a game with card dealing
 func StartGame(gameID, hostID string) (*GameTable, error) {
 	game, err := GetGame(gameID)
@@ -131,18 +178,61 @@ func StartGame(gameID, hostID string) (*GameTable, error) {
 		return nil, fmt.Errorf("game already started")
 	}
 
-	// Deal cards
-	hands, bottomCards := DealCards(5)
+	// First hand: the seed picks the starting dealer at random
+	return dealHand(game, false, 0)
+}
+
+// dealHand deals the game from its seed and makes it the active table
+// startingDealer 0 lets the seed draw it (first hand of a match); later hands pass
+// the seat chosen by the previous result (RULE.md §2.2).
+func dealHand(game *GameState, singlePlayer bool, startingDealer int) (*GameTable, error) {
+	if err := ensureDealSeed(game); err != nil {
+		return nil, err
+	}
+
+	deal := DealFromSeed(game.DealSeed, singlePlayer)
+	if startingDealer > 0 {
+		deal.StartingDealer = startingDealer
+	}
+
+	levels, err := seatLevels(game)
+	if err != nil {
+		return nil, err
+	}
+
+	// 首发人的等级作为本局初始级牌
+	level := levels[deal.StartingDealer-1]
+	now := time.Now()
+	table := newDealtTable(game.ID, game.HostID, level, deal.StartingDealer, game.PlayerIDs, levels, deal.handList(), deal.BottomCards, now)
+	for seat, settings := range seatAISettings(game) {
+		if hand, ok := table.PlayerHands[seat]; ok {
+			settings := settings
+			hand.AI = &settings
+		}
+	}
+	refreshDeadline(table, now)
+
+	// Store active game
+	snapshot := table.Clone()
+	if !activeGames.PutIfAbsent(table, func(table *GameTable) { logGameStart(table, game) }) {
+		return nil, fmt.Errorf("game already started")
+	}
+
+	// Update game status in database
+	UpdateGameStatus(game.ID, "playing")
 
-	// Determine starting dealer (random for first game)
-	startingDealer := rand.Intn(5) + 1 // Random seat 1-5
+	return snapshot, nil
+}
 
-	// Initialize game table
+// newDealtTable builds the table right after the deal, before anyone has called dealer
+// playerIDs, levels and hands are ordered by seat (index 0 is seat 1). The replay
+// reducer uses the same constructor, so a game rebuilt from its log starts identically.
+func newDealtTable(gameID, hostID, level string, startingDealer int, playerIDs, levels []string, hands [][]Card, bottomCards []Card, now time.Time) *GameTable {
 	table := &GameTable{
 		GameID:             gameID,
 		HostID:             hostID,
 		Status:             "calling", // 进入抢庄阶段
-		CurrentLevel:       game.CurrentLevel,
+		CurrentLevel:       level,
 		TrumpSuit:          "",
 		HostCalledCard:     nil,
 		FriendRevealed:     false,
@@ -150,21 +240,23 @@ func StartGame(gameID, hostID string) (*GameTable, error) {
 		CurrentPlayer:      startingDealer, // 起始发牌人先叫庄
 		TrickLeader:        startingDealer,
 		CurrentTrick:       make([]PlayedCard, 0),
+		TrickPlays:         make([]TrickPlay, 0),
 		TricksWon:          make([][]Card, 0),
+		TrickHistory:       make([]CompletedTrick, 0),
 		PlayerHands:        make(map[int]*PlayerHand),
-		CreatedAt:          time.Now(),
-		UpdatedAt:          time.Now(),
+		CreatedAt:          now,
+		UpdatedAt:          now,
 		StartingDealerSeat: startingDealer, // 起始发牌人
 		CurrentCaller:      startingDealer,
-		CallPhase:          "counting", // 倒计时抢庄阶段
-		CallCountdown:      10,         // 10秒倒计时
-		TrumpRank:          game.CurrentLevel,
+		CallPhase:          "counting",                              // 倒计时抢庄阶段
+		CallCountdown:      int(turnTimeouts.Calling / time.Second), // 默认10秒倒计时
+		TrumpRank:          level,
 		FlippedBottomCards: make([]Card, 0),
 		CallRecords:        make([]CallRecord, 0),
 	}
 
 	// Assign cards to players
-	for i, playerID := range game.PlayerIDs {
+	for i, playerID := range playerIDs {
 		seat := i + 1
 		table.PlayerHands[seat] = &PlayerHand{
 			UserID:     playerID,
@@ -173,37 +265,57 @@ func StartGame(gameID, hostID string) (*GameTable, error) {
 			IsFriend:   false,
 			Score:      0,
 			Collected:  make([]Card, 0),
+			Level:      levels[i],
 		}
 	}
 
-	// Store active game
-	activeGames[gameID] = table
+	return table
+}
 
-	// Update game status in database
-	UpdateGameStatus(gameID, "playing")
+// logGameStart records the deal (every hand and the bottom) so the whole game can
+// be rebuilt from game_action_logs, together with the seed it was dealt from
+func logGameStart(table *GameTable, game *GameState) {
+	playerIDs := make(map[int]string, len(table.PlayerHands))
+	levels := make(map[int]string, len(table.PlayerHands))
+	hands := make(map[int][]Card, len(table.PlayerHands))
+	for seat, hand := range table.PlayerHands {
+		playerIDs[seat] = hand.UserID
+		levels[seat] = hand.Level
+		hands[seat] = hand.Cards
+	}
 
-	// Log game start action
 	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
+		GameID:     table.GameID,
 		ActionType: "game_start",
 		PlayerSeat: 0,
-		PlayerID:   hostID,
+		PlayerID:   table.HostID,
 		ActionData: map[string]interface{}{
-			"starting_dealer": startingDealer,
-			"current_level":   game.CurrentLevel,
-			"player_count":    len(game.PlayerIDs),
+			"starting_dealer": table.StartingDealerSeat,
+			"current_level":   table.CurrentLevel,
+			"player_count":    len(table.PlayerHands),
+			"player_ids":      playerIDs,
+			"player_levels":   levels,
+			"hands":           hands,
+			"bottom_cards":    table.BottomCards,
+			"single_player":   isSinglePlayerGame(table),
+			"seed":            game.DealSeed,
+			"seed_hash":       game.SeedHash,
+			"match_id":        game.MatchID,
+			"hand_number":     game.HandNumber,
 		},
 		ResultData: map[string]interface{}{
 			"status": "success",
 		},
 	})
-
-	return table, nil
 }
 
-// GetTableGame retrieves the active game table
+// GetTableGame retrieves a snapshot of the active game table
+// The returned table is a copy; use the command functions to change the game.
 func GetTableGame(gameID string) (*GameTable, error) {
-	table, exists := activeGames[gameID]
+	table, exists, err := activeGames.Snapshot(gameID)
+	if err != nil {
+		return nil, err
+	}
 	if !exists {
 		// Try to load from database
 		game, err := GetGame(gameID)
@@ -217,6 +329,7 @@ func GetTableGame(gameID string) (*GameTable, error) {
 				Status:       "waiting",
 				PlayerHands:  make(map[int]*PlayerHand),
 				CurrentTrick: make([]PlayedCard, 0),
+				TrickPlays:   make([]TrickPlay, 0),
 			}, nil
 		}
 		return nil, fmt.Errorf("game not active in memory")
@@ -228,11 +341,18 @@ func GetTableGame(gameID string) (*GameTable, error) {
 // position: 第几张被打出的牌成为盟友（1=第1张，2=第2张，3=第3张）
 // 如果叫的牌在庄家手中或底牌中达不到position张数，则触发1打4独打模式
 func CallFriendCard(gameID, userID, suit, value string, position int) error {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return err
-	}
+	_, err := updateTable(gameID, func(table *GameTable) error {
+		if err := callFriendCard(table, userID, suit, value, position); err != nil {
+			return err
+		}
+		playerActed(table, userID)
+		return nil
+	})
+	return err
+}
 
+// callFriendCard implements CallFriendCard; the caller must hold the table lock
+func callFriendCard(table *GameTable, userID, suit, value string, position int) error {
 	if table.HostID != userID {
 		return fmt.Errorf("only host can call friend")
 	}
@@ -256,45 +376,15 @@ func CallFriendCard(gameID, userID, suit, value string, position int) error {
 		}
 	}
 
-	table.HostCalledCard = &CalledCard{
-		Suit:     suit,
-		Value:    value,
-		Position: position,
-		Count:    0, // 初始化计数器
-	}
-
-	// 统计该牌在庄家手中和底牌中的总数
-	totalCount := 0
-	dealerHand, ok := table.PlayerHands[table.DealerSeat]
-	if !ok {
-		return fmt.Errorf("dealer hand not found")
-	}
-
-	// 统计庄家手牌中该牌的数量
-	for _, card := range dealerHand.Cards {
-		if card.Suit == suit && card.Value == value {
-			totalCount++
-		}
-	}
-
-	// 统计底牌中该牌的数量
-	for _, card := range table.BottomCards {
-		if card.Suit == suit && card.Value == value {
-			totalCount++
-		}
+	totalCount, err := applyFriendCall(table, suit, value, position, time.Now())
+	if err != nil {
+		return err
 	}
 
-	// 如果庄家手中+底牌中的该牌数量 >= position，说明无法打出第position张，触发1打4
-	// 例如：叫第3张红桃A，庄家手中有2张，底牌有1张，总共3张，无法让其他玩家打出第3张
-	if totalCount >= position {
-		table.IsSoloMode = true
-		table.FriendRevealed = true
-		table.FriendSeat = table.DealerSeat // 庄家自己就是"朋友"
-		table.UpdatedAt = time.Now()
-
+	if table.IsSoloMode {
 		// 记录叫朋友日志（1v4模式）
 		LogGameAction(GameActionLogRequest{
-			GameID:     gameID,
+			GameID:     table.GameID,
 			ActionType: "call_friend",
 			PlayerSeat: table.DealerSeat,
 			PlayerID:   userID,
@@ -311,212 +401,156 @@ func CallFriendCard(gameID, userID, suit, value string, position int) error {
 				"friend_seat":     table.DealerSeat,
 				"game_mode":       "1v4",
 				"reason":          "called_card_not_reachable",
+				"status":          table.Status,
+			},
+		})
+	} else {
+		// 记录叫朋友日志
+		LogGameAction(GameActionLogRequest{
+			GameID:     table.GameID,
+			ActionType: "call_friend",
+			PlayerSeat: table.DealerSeat,
+			PlayerID:   userID,
+			ActionData: map[string]interface{}{
+				"suit":        suit,
+				"value":       value,
+				"position":    position,
+				"called_card": fmt.Sprintf("%s%s", suit, value),
+			},
+			ResultData: map[string]interface{}{
+				"is_solo_mode":    table.IsSoloMode,
+				"friend_revealed": table.FriendRevealed,
+				"game_mode":       "2v3",
+				"status":          table.Status,
 			},
 		})
-
-		// 如果之前是calling_friend状态，进入playing状态
-		if table.Status == "calling_friend" {
-			table.Status = "playing"
-			table.CurrentPlayer = table.DealerSeat // 庄家先出牌
-			table.CallPhase = "finished"
-			table.UpdatedAt = time.Now()
-		}
-
-		return nil
-	}
-
-	// 正常2打3模式
-	table.IsSoloMode = false
-	table.UpdatedAt = time.Now()
-
-	// 记录叫朋友日志
-	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
-		ActionType: "call_friend",
-		PlayerSeat: table.DealerSeat,
-		PlayerID:   userID,
-		ActionData: map[string]interface{}{
-			"suit":        suit,
-			"value":       value,
-			"position":    position,
-			"called_card": fmt.Sprintf("%s%s", suit, value),
-		},
-		ResultData: map[string]interface{}{
-			"is_solo_mode":    table.IsSoloMode,
-			"friend_revealed": table.FriendRevealed,
-			"game_mode":       "2v3",
-		},
-	})
-
-	// 如果之前是calling_friend状态，进入playing状态
-	if table.Status == "calling_friend" {
-		table.Status = "playing"
-		table.CurrentPlayer = table.DealerSeat // 庄家先出牌
-		table.CallPhase = "finished"
-		table.UpdatedAt = time.Now()
 	}
 
+	publishFriendCalled(table)
 	return nil
 }
 
-// PlayCardGame plays a card from a player's hand
-func PlayCardGame(gameID, userID string, cardIndex int) (*PlayResult, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
+// applyFriendCall records the called card, decides between 1v4 and 2v3 and moves
+// calling_friend on to playing. It never logs, so the replay reducer shares it.
+// Returns how many copies of the called card the dealer holds (hand + bottom).
+func applyFriendCall(table *GameTable, suit, value string, position int, now time.Time) (int, error) {
+	// 统计该牌在庄家手中和底牌中的总数
+	totalCount := 0
+	dealerHand, ok := table.PlayerHands[table.DealerSeat]
+	if !ok {
+		return 0, fmt.Errorf("dealer hand not found")
 	}
 
-	if table.Status != "playing" {
-		return nil, fmt.Errorf("game not in playing state")
+	table.HostCalledCard = &CalledCard{
+		Suit:     suit,
+		Value:    value,
+		Position: position,
+		Count:    0, // 初始化计数器
 	}
 
-	// Find player's seat
-	var playerSeat int
-	var hand *PlayerHand
-	for seat, h := range table.PlayerHands {
-		if h.UserID == userID {
-			playerSeat = seat
-			hand = h
-			break
+	// 统计庄家手牌中该牌的数量
+	for _, card := range dealerHand.Cards {
+		if card.Suit == suit && card.Value == value {
+			totalCount++
 		}
 	}
 
-	if hand == nil {
-		return nil, fmt.Errorf("player not in game")
-	}
-
-	if playerSeat != table.CurrentPlayer {
-		return nil, fmt.Errorf("not your turn")
-	}
-
-	// Validate card index
-	if cardIndex < 0 || cardIndex >= len(hand.Cards) {
-		return nil, fmt.Errorf("invalid card index")
-	}
-
-	card := hand.Cards[cardIndex]
-
-	// Check for friend reveal (when called card is played)
-	// 追踪打出次数，当打出第N张时识别盟友
-	if !table.FriendRevealed && table.HostCalledCard != nil {
-		if card.Suit == table.HostCalledCard.Suit && card.Value == table.HostCalledCard.Value {
-			// 打出了叫的牌，计数器+1
-			table.HostCalledCard.Count++
-
-			// 检查是否达到指定的position
-			if table.HostCalledCard.Count == table.HostCalledCard.Position {
-				// 第N张被打出，识别盟友
-				table.FriendRevealed = true
-				table.FriendSeat = playerSeat
-				hand.IsFriend = true
-			}
+	// 统计底牌中该牌的数量
+	bottomCount := 0
+	for _, card := range table.BottomCards {
+		if card.Suit == suit && card.Value == value {
+			totalCount++
+			bottomCount++
 		}
 	}
 
-	// Remove card from hand and add to current trick
-	hand.Cards = append(hand.Cards[:cardIndex], hand.Cards[cardIndex+1:]...)
-	isLead := len(table.CurrentTrick) == 0
-	if isLead {
-		table.TrickLeader = playerSeat
+	// 如果庄家手中+底牌中的该牌数量 >= position，说明无法打出第position张，触发1打4
+	// 例如：叫第3张红桃A，庄家手中有2张，底牌有1张，总共3张，无法让其他玩家打出第3张
+	// 底牌不会被打出，所以 position 超过能打出的张数（三副牌减去底牌）也是1打4
+	if totalCount >= position || position > deckCopies-bottomCount {
+		table.IsSoloMode = true
+		table.FriendRevealed = true
+		table.FriendSeat = table.DealerSeat // 庄家自己就是"朋友"
+	} else {
+		// 正常2打3模式
+		table.IsSoloMode = false
 	}
 
-	table.CurrentTrick = append(table.CurrentTrick, PlayedCard{
-		Card:      card,
-		Seat:      playerSeat,
-		IsLead:    isLead,
-		Timestamp: time.Now().UnixNano(),
-	})
-
-	table.UpdatedAt = time.Now()
-
-	result := &PlayResult{
-		Success: true,
-		Message: fmt.Sprintf("Played %s of %s", card.Value, card.Suit),
+	// 如果之前是calling_friend状态，进入playing状态
+	if table.Status == "calling_friend" {
+		table.Status = "playing"
+		table.CurrentPlayer = table.DealerSeat // 庄家先出牌
+		table.CallPhase = "finished"
 	}
+	table.UpdatedAt = now
 
-	// Check if trick is complete (5 cards played)
-	if len(table.CurrentTrick) == 5 {
-		winner := determineTrickWinner(table.CurrentTrick, table.TrumpSuit, table.TrumpRank)
-		result.TrickComplete = true
-		result.TrickWinner = winner
+	return totalCount, nil
+}
 
-		// Collect scoring cards
-		var collectedCards []Card
-		for _, pc := range table.CurrentTrick {
-			if isScoringCard(pc.Card) {
-				collectedCards = append(collectedCards, pc.Card)
-			}
-		}
+// publishFriendCalled pushes the call_friend event to connected clients
+func publishFriendCalled(table *GameTable) {
+	publishGameEvent(newTableEvent(table, "call_friend", table.DealerSeat, map[string]interface{}{
+		"calledCard":     table.HostCalledCard,
+		"isSoloMode":     table.IsSoloMode,
+		"friendRevealed": table.FriendRevealed,
+		"friendSeat":     table.FriendSeat,
+	}))
+}
 
-		// Winner gets the cards
-		if winnerHand, ok := table.PlayerHands[winner]; ok {
-			winnerHand.Collected = append(winnerHand.Collected, collectedCards...)
+// PlayCardGame plays a card from a player's hand
+func PlayCardGame(gameID, userID string, cardIndex int) (*PlayResult, error) {
+	var result *PlayResult
+	_, err := updateTable(gameID, func(table *GameTable) error {
+		var err error
+		if result, err = playCardGame(table, userID, cardIndex); err != nil {
+			return err
 		}
+		playerActed(table, userID)
+		return nil
+	})
+	return result, err
+}
 
-		table.TricksWon = append(table.TricksWon, []Card{card})
-
-		// Clear trick and set winner as next leader
-		table.CurrentTrick = make([]PlayedCard, 0)
-		table.CurrentPlayer = winner
-		table.TrickLeader = winner
-	} else {
-		// Next player (counter-clockwise: 1→5→4→3→2→1)
-		result.NextPlayer = ((playerSeat - 2 + 5) % 5) + 1
-		table.CurrentPlayer = result.NextPlayer
-	}
-
-	table.LastPlay = result
-	return result, nil
+// playCardGame implements PlayCardGame; the caller must hold the table lock
+// A single card is just a one-card play.
+func playCardGame(table *GameTable, userID string, cardIndex int) (*PlayResult, error) {
+	return playCardsGame(table, userID, []int{cardIndex})
 }
 
 // determineTrickWinner determines who wins the current trick
-// 新规则：必须同牌型才能毙牌（对子毙对子，三张毙三张等）
-// 王作为主牌的一部分，不存在"王毙主牌"
-func determineTrickWinner(trick []PlayedCard, trumpSuit, trumpRank string) int {
-	if len(trick) == 0 {
+// 两步判断：先看牌型（牌型、张数、分组必须与首家完全一致），再看花色和大小。
+// 主牌（王、级牌、主花色）> 首家花色 > 其他花色（垫牌不能赢）；同花色时比较决定牌的大小，
+// 一样大时先出者胜。王作为主牌的一部分，不存在"王毙主牌"
+func determineTrickWinner(plays []TrickPlay, trumpSuit, trumpRank string) int {
+	if len(plays) == 0 {
 		return 0
 	}
 
-	// 按座位分组牌
-	playsByPlayer := make(map[int][]Card)
-	playerOrder := []int{} // 记录出牌顺序
-	for _, pc := range trick {
-		if _, exists := playsByPlayer[pc.Seat]; !exists {
-			playerOrder = append(playerOrder, pc.Seat)
-		}
-		playsByPlayer[pc.Seat] = append(playsByPlayer[pc.Seat], pc.Card)
-	}
-
-	// 领出玩家和领出牌
-	leadPlayer := playerOrder[0]
-	leadCards := playsByPlayer[leadPlayer]
-	leadSuit := leadCards[0].Suit
-
-	// 判断领出牌型
-	leadCardType := determineCardType(leadCards)
-
-	// 初始化赢家为领出玩家
-	winner := leadPlayer
-	winnerCards := leadCards
+	lead := plays[0]
+	leadShape := playShape(lead.Cards)
+	leadSuit := playSuit(lead.Cards, trumpSuit, trumpRank)
 
-	// 遍历其他玩家的出牌
-	for i := 1; i < len(playerOrder); i++ {
-		player := playerOrder[i]
-		cards := playsByPlayer[player]
+	winner := lead.Seat
+	winnerSuit := leadSuit
+	winnerRank := playRank(lead.Cards, trumpSuit, trumpRank)
 
-		// 判断跟牌的牌型
-		cardType := determineCardType(cards)
+	for _, play := range plays[1:] {
+		// 第一步：牌型必须完全匹配
+		if playShape(play.Cards) != leadShape {
+			continue
+		}
 
-		// 只有牌型匹配才能参与比较
-		if cardType != leadCardType {
-			continue // 牌型不匹配，无法毙牌
+		// 第二步：只有跟首家花色或全是主牌的出牌才能参与比较
+		suit := playSuit(play.Cards, trumpSuit, trumpRank)
+		if suit == "" || (suit != leadSuit && suit != "trump") {
+			continue
 		}
 
-		// 牌型匹配，比较大小
-		// 规则：主牌（含王）> 副牌
-		if canBeatCards(cards, winnerCards, leadSuit, trumpSuit, trumpRank) {
-			winner = player
-			winnerCards = cards
+		rank := playRank(play.Cards, trumpSuit, trumpRank)
+		if (suit == "trump" && winnerSuit != "trump") || (suit == winnerSuit && rank > winnerRank) {
+			winner = play.Seat
+			winnerSuit = suit
+			winnerRank = rank
 		}
 	}
 
@@ -542,56 +576,85 @@ func determineCardType(cards []Card) string {
 	return "throw"
 }
 
-// canBeatCards 判断 cards 是否能击败 currentWinnerCards
-// 规则：主牌（含王）> 副牌
-func canBeatCards(cards []Card, currentWinnerCards []Card, leadSuit, trumpSuit, trumpRank string) bool {
-	// 判断两组牌的花色类型
-	cardsType := getPlayType(cards, trumpSuit)
-	winnerType := getPlayType(currentWinnerCards, trumpSuit)
-
-	// 主牌 > 副牌
-	if cardsType == "trump" && winnerType != "trump" {
-		return true // 主牌毙副牌
+// playShape describes a play's shape: its type and the sizes of its groups of
+// identical cards, e.g. "pair:2", "tractor:2,2,2", "throw:2,1,1"
+// Two plays can only be compared when their shapes are equal.
+func playShape(cards []Card) string {
+	sizes := make([]int, 0, len(cards))
+	for _, group := range identicalGroups(cards) {
+		sizes = append(sizes, len(group))
 	}
-	if cardsType != "trump" && winnerType == "trump" {
-		return false // 副牌无法毙主牌
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	parts := make([]string, len(sizes))
+	for i, size := range sizes {
+		parts[i] = strconv.Itoa(size)
 	}
+	return determineCardType(cards) + ":" + strings.Join(parts, ",")
+}
 
-	// 同为主牌或同为副牌，比较最大牌的等级
-	maxCard1 := getMaxCardRank(cards, trumpSuit, trumpRank)
-	maxCard2 := getMaxCardRank(currentWinnerCards, trumpSuit, trumpRank)
+// identicalGroups splits cards into groups of identical cards (same suit and value)
+func identicalGroups(cards []Card) [][]Card {
+	var groups [][]Card
+	index := make(map[Card]int)
+	for _, card := range cards {
+		if i, ok := index[card]; ok {
+			groups[i] = append(groups[i], card)
+			continue
+		}
+		index[card] = len(groups)
+		groups = append(groups, []Card{card})
+	}
+	return groups
+}
 
-	return maxCard1 > maxCard2
+// isTrumpCard reports whether a card is trump: jokers, level cards and the trump suit
+func isTrumpCard(card Card, trumpSuit, trumpRank string) bool {
+	return card.Type == "joker" || card.Suit == "joker" || card.Value == trumpRank || (trumpSuit != "" && card.Suit == trumpSuit)
 }
 
-// getPlayType 判断出牌类型：trump（主牌）或 suit（副牌）
-func getPlayType(cards []Card, trumpSuit string) string {
-	for _, card := range cards {
-		// 王是主牌的一部分
-		if card.Type == "joker" {
-			return "trump"
+// playSuit returns "trump" if every card is trump, the common suit if every card is
+// of the same side suit, or "" for a mixed play
+func playSuit(cards []Card, trumpSuit, trumpRank string) string {
+	suit := ""
+	for i, card := range cards {
+		cardSuit := card.Suit
+		if isTrumpCard(card, trumpSuit, trumpRank) {
+			cardSuit = "trump"
 		}
-		// 主花色牌是主牌
-		if card.Suit == trumpSuit {
-			return "trump"
+		if i == 0 {
+			suit = cardSuit
+		} else if cardSuit != suit {
+			return ""
 		}
 	}
-	return "suit" // 副牌
+	return suit
 }
 
-// getMaxCardRank 获取一组牌中最大牌的等级
-func getMaxCardRank(cards []Card, trumpSuit, trumpRank string) int {
+// playRank returns the rank that decides between two plays of the same shape:
+// the highest card among the play's largest groups (the top pair of a tractor,
+// the triple of a throw with one, ...)
+func playRank(cards []Card, trumpSuit, trumpRank string) int {
+	groups := identicalGroups(cards)
+	largest := 0
+	for _, group := range groups {
+		if len(group) > largest {
+			largest = len(group)
+		}
+	}
+
 	maxRank := 0
-	for _, card := range cards {
-		rank := getCardRank(card, trumpSuit, trumpRank)
-		if rank > maxRank {
+	for _, group := range groups {
+		if len(group) != largest {
+			continue
+		}
+		if rank := getCardRank(group[0], trumpSuit, trumpRank); rank > maxRank {
 			maxRank = rank
 		}
 	}
 	return maxRank
 }
 
-// getCardValue returns the numeric value of a card for comparison
 // getCardRank 计算牌在游戏中的等级，考虑主牌、级牌等特殊规则
 // 返回值越大，牌的等级越高
 // 主牌等级: 大王(1000) > 小王(900) > 主级牌(800) > 副级牌(700-703) > 主A(614) > 主K(613) > ... > 主3(603)
@@ -641,6 +704,7 @@ func getCardRank(card Card, trumpSuit, trumpRank string) int {
 	return baseValues[card.Value]
 }
 
+// getCardValue returns the numeric value of a card for comparison
 func getCardValue(card Card, leadSuit, trumpSuit string) int {
 	values := map[string]int{
 		"2": 2, "3": 3, "4": 4, "5": 5, "6": 6, "7": 7, "8": 8, "9": 9, "10": 10,
@@ -712,32 +776,73 @@ func GetPlayerHand(gameID, userID string) (*PlayerHand, error) {
 	return nil, fmt.Errorf("player not in game")
 }
 
+// ErrFixedSeedNotAllowed is returned when a room asks for a fixed seed on a
+// server that does not allow it
+var ErrFixedSeedNotAllowed = errors.New("指定发牌种子只能用于单人游戏")
+
+// allowFixedSeed lets rooms be created with a fixed seed (ALLOW_FIXED_SEED=true)
+// Only for reproducing a deal on a test server: whoever picks the seed knows
+// every hand.
+var allowFixedSeed = getEnv("ALLOW_FIXED_SEED", "") == "true"
+
 // CreateGame creates a new game
-func CreateGame(name, hostID string) (*GameState, error) {
+// Every room is the first hand of a match; later hands are created when a hand ends.
+// Rooms draw a fresh random seed (seed nil): a host who picked the seed would
+// know every hand the other players are dealt. A fixed seed is refused with
+// ErrFixedSeedNotAllowed unless the server allows it (allowFixedSeed). Only the
+// seed's hash is published until the game ends.
+func CreateGame(name, hostID string, seed *int64) (*GameState, error) {
+	if seed != nil && !allowFixedSeed {
+		return nil, ErrFixedSeedNotAllowed
+	}
+	m, err := createMatch(name, hostID, seed, false)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := insertGame(name, hostID, m.handSeed(), m.ID, m.HandNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add host as first player
+	_, err = db.Exec(`INSERT INTO game_players (game_id, user_id, seat_number) VALUES ($1, $2, 1)`, id, hostID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.CurrentGameID = id
+	if err := saveMatch(m); err != nil {
+		return nil, err
+	}
+
+	return GetGame(id)
+}
+
+// insertGame creates a waiting game row for one hand of a match and logs game_create
+func insertGame(name, hostID string, seed *int64, matchID string, handNumber int) (string, error) {
 	var id string
-	var err error
+
+	dealSeed, seedFixed, err := pickDealSeed(seed)
+	if err != nil {
+		return "", err
+	}
 
 	// Try to generate a unique ID (retry if collision)
 	for i := 0; i < 10; i++ {
 		id = generateID()
-		query := `INSERT INTO games (id, name, host_id, max_players, status, current_level) VALUES ($1, $2, $3, $4, $5, $6)`
-		_, err = db.Exec(query, id, name, hostID, 5, "waiting", "2")
+		query := `INSERT INTO games (id, name, host_id, max_players, status, current_level, deal_seed, seed_hash, seed_fixed, match_id, hand_number) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+		_, err = db.Exec(query, id, name, hostID, 5, "waiting", "2", dealSeed, SeedHash(dealSeed), seedFixed, matchID, handNumber)
 		if err == nil {
 			break
 		}
 		// Check if it's a duplicate error, try again
 		if !isDuplicateError(err) {
-			return nil, err
+			return "", err
 		}
 	}
 	if err != nil {
-		return nil, err
-	}
-
-	// Add host as first player
-	_, err = db.Exec(`INSERT INTO game_players (game_id, user_id, seat_number) VALUES ($1, $2, 1)`, id, hostID)
-	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	// 记录游戏创建日志
@@ -750,6 +855,10 @@ func CreateGame(name, hostID string) (*GameState, error) {
 			"game_name":   name,
 			"max_players": 5,
 			"host_id":     hostID,
+			"seed_hash":   SeedHash(dealSeed),
+			"seed_fixed":  seedFixed,
+			"match_id":    matchID,
+			"hand_number": handNumber,
 		},
 		ResultData: map[string]interface{}{
 			"game_id": id,
@@ -757,27 +866,21 @@ func CreateGame(name, hostID string) (*GameState, error) {
 		},
 	})
 
-	return GetGame(id)
+	return id, nil
 }
 
 // CreateSinglePlayerGame creates a single player game with AI opponents
-func CreateSinglePlayerGame(name, hostID string) (*GameState, error) {
-	var id string
-	var err error
-
-	// Try to generate a unique ID (retry if collision)
-	for i := 0; i < 10; i++ {
-		id = generateID()
-		query := `INSERT INTO games (id, name, host_id, max_players, status, current_level) VALUES ($1, $2, $3, $4, $5, $6)`
-		_, err = db.Exec(query, id, name, hostID, 5, "waiting", "2")
-		if err == nil {
-			break
-		}
-		// Check if it's a duplicate error, try again
-		if !isDuplicateError(err) {
-			return nil, err
-		}
+// seed is optional: nil draws a fresh random seed, a fixed seed reproduces a
+// deal (every other seat is an AI, so nobody is dealt a hand the host chose).
+// aiSettings (seat -> settings, may be nil) sets how
+// each AI seat plays for the whole match.
+func CreateSinglePlayerGame(name, hostID string, seed *int64, aiSettings map[int]AISettings) (*GameState, error) {
+	m, err := createMatch(name, hostID, seed, true)
+	if err != nil {
+		return nil, err
 	}
+
+	id, err := insertGame(name, hostID, m.handSeed(), m.ID, m.HandNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -807,6 +910,12 @@ func CreateSinglePlayerGame(name, hostID string) (*GameState, error) {
 		}
 	}
 
+	m.CurrentGameID = id
+	m.AISettings = aiSettings
+	if err := saveMatch(m); err != nil {
+		return nil, err
+	}
+
 	return GetGame(id)
 }
 
@@ -830,144 +939,71 @@ func StartSinglePlayerGame(gameID, hostID string) (*GameTable, error) {
 		return nil, fmt.Errorf("game already started")
 	}
 
-	// Deal cards
-	hands, bottomCards := DealCards(5)
-
-	// 单人模式：玩家1是庄家（起始发牌人）
-	startingDealer := 1
-
-	// Initialize game table
-	table := &GameTable{
-		GameID:             gameID,
-		HostID:             hostID,
-		Status:             "calling", // 进入抢庄阶段
-		CurrentLevel:       game.CurrentLevel,
-		TrumpSuit:          "",
-		HostCalledCard:     nil,
-		FriendRevealed:     false,
-		BottomCards:        bottomCards,
-		CurrentPlayer:      startingDealer,
-		TrickLeader:        startingDealer,
-		CurrentTrick:       make([]PlayedCard, 0),
-		TricksWon:          make([][]Card, 0),
-		PlayerHands:        make(map[int]*PlayerHand),
-		CreatedAt:          time.Now(),
-		UpdatedAt:          time.Now(),
-		StartingDealerSeat: startingDealer,
-		CurrentCaller:      startingDealer,
-		CallPhase:          "counting",
-		CallCountdown:      10,
-		TrumpRank:          game.CurrentLevel,
-		FlippedBottomCards: make([]Card, 0),
-		CallRecords:        make([]CallRecord, 0),
-	}
-
-	// Assign cards to players (seat 1 is human, 2-5 are AI)
-	for i, playerID := range game.PlayerIDs {
-		seat := i + 1
-		table.PlayerHands[seat] = &PlayerHand{
-			UserID:     playerID,
-			Cards:      hands[i],
-			SeatNumber: seat,
-			IsFriend:   false,
-			Score:      0,
-			Collected:  make([]Card, 0),
-		}
-	}
-
-	// Store active game
-	activeGames[gameID] = table
-
-	// Update game status in database
-	UpdateGameStatus(gameID, "playing")
-
-	return table, nil
+	// 单人模式：玩家1是庄家（起始发牌人），Seat 1 is the human, seats 2-5 are AI
+	return dealHand(game, true, 0)
 }
 
-// AIPlayTurn makes AI players play until it's the human's turn
+// maxAITurns bounds the plays one AIPlayTurn makes (a whole trick and then some)
+const maxAITurns = 10
+
+// AIPlayTurn makes AI players (and seats in trusteeship) play until a human is to play
+// Each play is decided on a copy of the table without holding the lock (the hard
+// AI's search and external bots take a while), then made under the lock if the
+// turn hasn't moved on meanwhile, e.g. by the turn timer playing it first.
 func AIPlayTurn(gameID string) (*GameTable, error) {
 	table, err := GetTableGame(gameID)
 	if err != nil {
 		return nil, err
 	}
-
 	if table.Status != "playing" {
 		return nil, fmt.Errorf("game not in playing state")
 	}
 
-	// Keep playing while it's an AI player's turn (seats 2-5)
-	maxIterations := 10 // Prevent infinite loop (increased to handle full round)
-	iterations := 0
-
-	for table.CurrentPlayer != 1 && iterations < maxIterations {
-		hand, ok := table.PlayerHands[table.CurrentPlayer]
+	// Keep playing while the seat to play is driven by the AI: AI players, and
+	// human seats in trusteeship (托管)
+	for i := 0; i < maxAITurns && table.Status == "playing"; i++ {
+		seat := table.CurrentPlayer
+		hand, ok := table.PlayerHands[seat]
 		if !ok {
-			return nil, fmt.Errorf("player %d not found", table.CurrentPlayer)
+			return nil, fmt.Errorf("player %d not found", seat)
 		}
-
-		// Create AI player
-		ai := &AIPlayer{
-			UserID:     hand.UserID,
-			SeatNumber: table.CurrentPlayer,
-			Hand:       hand.Cards,
+		if !isAIPlayerID(hand.UserID) && !hand.Trusteeship {
+			break
 		}
 
-		// Decide which cards to play
-		cardIndices := ai.DecidePlay(table)
-
-		// Play the cards
-		_, err = PlayCardsGame(gameID, hand.UserID, cardIndices)
+		turn, _, _ := currentTurn(table)
+		chosen := strategyFor(hand).DecidePlay(table, seat)
+		table, err = updateTable(gameID, func(current *GameTable) error {
+			if key, _, _ := currentTurn(current); key != turn {
+				return nil // 已经有人出过了，按新的牌桌再看
+			}
+			return autoPlay(current, current.PlayerHands[seat], chosen)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("AI %d play failed: %w", table.CurrentPlayer, err)
+			return nil, fmt.Errorf("AI %d play failed: %w", seat, err)
 		}
-
-		// Refresh table state
-		table, _ = GetTableGame(gameID)
-		iterations++
 	}
 
 	return table, nil
 }
 
-// AICallFriendCard decides which card to call as friend
-func AICallFriendCard(cards []Card) (string, string) {
-	if len(cards) == 0 {
-		return "spades", "A"
-	}
-
-	// Strategy: Call Ace of longest suit or a suit we have multiple cards of
-	suitCounts := make(map[string]int)
-	for _, card := range cards {
-		suitCounts[card.Suit]++
-	}
-
-	longestSuit := ""
-	maxCount := 0
-	for suit, count := range suitCounts {
-		if count > maxCount {
-			maxCount = count
-			longestSuit = suit
-		}
-	}
-
-	// Check if we have the Ace
-	for _, card := range cards {
-		if card.Suit == longestSuit && card.Value == "A" {
-			return longestSuit, "A" // We have it, 1v4 mode
-		}
-	}
-
-	return longestSuit, "A"
-}
-
 // GetGame retrieves a game by ID
 func GetGame(id string) (*GameState, error) {
-	query := `SELECT id, name, host_id, max_players, status, current_level, created_at, updated_at FROM games WHERE id = $1`
+	if db == nil {
+		return nil, ErrGameNotFound // 自对弈的牌桌没有对应的对局记录
+	}
+
+	query := `SELECT id, name, host_id, max_players, status, current_level, created_at, updated_at, deal_seed, seed_hash, seed_fixed, match_id, hand_number FROM games WHERE id = $1`
 
 	game := &GameState{}
+	var dealSeed sql.NullInt64
+	var seedHash sql.NullString
+	var matchID sql.NullString
+	var handNumber sql.NullInt64
 	err := db.QueryRow(query, id).Scan(
 		&game.ID, &game.Name, &game.HostID, &game.MaxPlayers,
 		&game.Status, &game.CurrentLevel, &game.CreatedAt, &game.UpdatedAt,
+		&dealSeed, &seedHash, &game.SeedFixed, &matchID, &handNumber,
 	)
 
 	if err == sql.ErrNoRows {
@@ -977,6 +1013,19 @@ func GetGame(id string) (*GameState, error) {
 		return nil, err
 	}
 
+	if dealSeed.Valid {
+		game.DealSeed = dealSeed.Int64
+		game.HasSeed = true
+		game.SeedHash = seedHash.String
+		if game.Status == "finished" {
+			seed := game.DealSeed
+			game.Seed = &seed
+		}
+	}
+
+	game.MatchID = matchID.String
+	game.HandNumber = int(handNumber.Int64)
+
 	// Load players
 	game.PlayerIDs, err = getGamePlayers(id)
 	if err != nil {
@@ -1054,6 +1103,70 @@ func JoinGame(gameID, playerID string) error {
 	return err
 }
 
+// AddAIPlayer seats an AI in the next free seat of a waiting room (host only)
+// settings.Difficulty may name an external bot (see ai_bot.go). The settings are
+// kept in the room's match, so the seat plays every hand the same way.
+// Returns the seat number.
+func AddAIPlayer(gameID, hostID string, settings AISettings) (int, error) {
+	game, err := GetGame(gameID)
+	if err != nil {
+		return 0, err
+	}
+	if game.HostID != hostID {
+		return 0, fmt.Errorf("only host can add AI players")
+	}
+	if game.Status != "waiting" {
+		return 0, fmt.Errorf("game already started")
+	}
+	if len(game.PlayerIDs) >= game.MaxPlayers {
+		return 0, ErrGameFull
+	}
+	if game.MatchID == "" {
+		return 0, fmt.Errorf("game has no match to keep the AI settings")
+	}
+
+	seat := len(game.PlayerIDs) + 1
+	aiID := fmt.Sprintf("ai_%d", seat)
+	_, err = db.Exec(`INSERT INTO users (id, username, password, level, wins, losses) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING`,
+		aiID, aiID, "ai", "2", 0, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create AI user %s: %w", aiID, err)
+	}
+	_, err = db.Exec(`INSERT INTO game_players (game_id, user_id, seat_number) VALUES ($1, $2, $3)`, gameID, aiID, seat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add AI player %s to game: %w", aiID, err)
+	}
+
+	m, err := GetMatch(game.MatchID)
+	if err != nil {
+		return 0, err
+	}
+	if m.AISettings == nil {
+		m.AISettings = make(map[int]AISettings)
+	}
+	m.AISettings[seat] = settings
+	if err := saveMatch(m); err != nil {
+		return 0, err
+	}
+
+	LogGameAction(GameActionLogRequest{
+		GameID:     gameID,
+		ActionType: "player_join",
+		PlayerSeat: seat,
+		PlayerID:   aiID,
+		ActionData: map[string]interface{}{
+			"seat_number":   seat,
+			"current_count": seat,
+			"ai":            settings,
+		},
+		ResultData: map[string]interface{}{
+			"status": "success",
+		},
+	})
+
+	return seat, nil
+}
+
 // ListGames returns all active games
 func ListGames() ([]*GameState, error) {
 	query := `SELECT id, name, host_id, max_players, status, current_level, created_at, updated_at FROM games WHERE status != 'finished' ORDER BY created_at DESC`
@@ -1136,6 +1249,12 @@ type PlayerInfo struct {
 // DealCards deals cards for a 5-player, 3-deck game
 // Each player gets 31 cards, 7 cards go to the bottom
 func DealCards(playerCount int) ([][]Card, []Card) {
+	return DealCardsWithRand(playerCount, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// DealCardsWithRand deals like DealCards but shuffles with the given RNG
+// The same seed always gives the same deal, so games can be re-dealt and bugs reproduced.
+func DealCardsWithRand(playerCount int, rng *rand.Rand) ([][]Card, []Card) {
 	// Create 3 decks of cards (162 cards total)
 	var allCards []Card
 	suits := []string{"hearts", "diamonds", "clubs", "spades"}
@@ -1157,9 +1276,8 @@ func DealCards(playerCount int) ([][]Card, []Card) {
 	}
 
 	// Proper shuffle using rand
-	rand.Seed(time.Now().UnixNano())
 	for i := len(allCards) - 1; i > 0; i-- {
-		j := rand.Intn(i + 1)
+		j := rng.Intn(i + 1)
 		allCards[i], allCards[j] = allCards[j], allCards[i]
 	}
 
@@ -1167,8 +1285,9 @@ func DealCards(playerCount int) ([][]Card, []Card) {
 	hands := make([][]Card, playerCount)
 	cardsPerPlayer := 31
 
+	// 限定容量：庄家收底牌时 append 会重新分配，不会覆盖下一家的牌
 	for i := 0; i < playerCount; i++ {
-		hands[i] = allCards[i*cardsPerPlayer : (i+1)*cardsPerPlayer]
+		hands[i] = allCards[i*cardsPerPlayer : (i+1)*cardsPerPlayer : (i+1)*cardsPerPlayer]
 	}
 
 	// Remaining 7 cards are the bottom cards
@@ -1283,6 +1402,13 @@ func RecordGameResult(gameID string, results []GameResult) error {
 		return err
 	}
 
+	logGameEnd(gameID, results)
+
+	return tx.Commit()
+}
+
+// logGameEnd records the level changes of a finished game
+func logGameEnd(gameID string, results []GameResult) {
 	// 记录游戏结束日志
 	levelChanges := make([]map[string]interface{}, 0, len(results))
 	for _, r := range results {
@@ -1307,8 +1433,6 @@ func RecordGameResult(gameID string, results []GameResult) error {
 			"game_status":   "finished",
 		},
 	})
-
-	return tx.Commit()
 }
 
 // GameResult represents the result for a single player
@@ -1322,11 +1446,20 @@ type GameResult struct {
 
 // PlayCardsGame plays multiple cards from a player's hand
 func PlayCardsGame(gameID, userID string, cardIndices []int) (*PlayResult, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
-	}
+	var result *PlayResult
+	_, err := updateTable(gameID, func(table *GameTable) error {
+		var err error
+		if result, err = playCardsGame(table, userID, cardIndices); err != nil {
+			return err
+		}
+		playerActed(table, userID)
+		return nil
+	})
+	return result, err
+}
 
+// playCardsGame implements PlayCardsGame; the caller must hold the table lock
+func playCardsGame(table *GameTable, userID string, cardIndices []int) (*PlayResult, error) {
 	if table.Status != "playing" {
 		return nil, fmt.Errorf("game not in playing state")
 	}
@@ -1369,64 +1502,21 @@ func PlayCardsGame(gameID, userID string, cardIndices []int) (*PlayResult, error
 	}
 
 	// Check if this is a throw (甩牌) - leading with multiple cards of same suit
-	isLead := len(table.CurrentTrick) == 0
+	isLead := len(table.TrickPlays) == 0
 	if isLead && len(cardsToPlay) >= 2 {
-		// Check for throw cards validation
-		throwResult := ValidateThrowCards(cardsToPlay, table, playerSeat)
-
-		if !throwResult.IsValid && len(throwResult.ActualPlay) < len(cardsToPlay) {
-			// 甩牌失败，只出最小的牌
-			// 重新计算 cardIndices，只保留要出的牌
-			actualCardIndices := make([]int, 0, len(throwResult.ActualPlay))
-			for i, idx := range cardIndices {
-				if i < len(throwResult.ActualPlay) {
-					actualCardIndices = append(actualCardIndices, idx)
-				}
-			}
-			cardIndices = actualCardIndices
-			cardsToPlay = throwResult.ActualPlay
-			fmt.Printf("甩牌失败: %s，只出最小牌\n", throwResult.Reason)
+		var reason string
+		cardIndices, cardsToPlay, reason = resolveThrow(table, playerSeat, cardIndices)
+		if reason != "" {
+			log.Printf("甩牌失败: %s，只出最小牌", reason)
 		}
 	}
 
 	// Validate the play (must be valid combination)
-	fmt.Printf("DEBUG: Validating %d cards: %+v\n", len(cardsToPlay), cardsToPlay)
 	if err := validateCardPlay(cardsToPlay, table); err != nil {
-		fmt.Printf("DEBUG: Validation failed: %v\n", err)
 		return nil, err
 	}
 
-	// Check for friend reveal with first card
-	if !table.FriendRevealed && table.HostCalledCard != nil && len(cardsToPlay) > 0 {
-		if cardsToPlay[0].Suit == table.HostCalledCard.Suit && cardsToPlay[0].Value == table.HostCalledCard.Value {
-			table.FriendRevealed = true
-			table.FriendSeat = playerSeat
-			hand.IsFriend = true
-		}
-	}
-
-	// Remove cards from hand (remove in reverse order to preserve indices)
-	sort.Slice(cardIndices, func(i, j int) bool { return cardIndices[i] > cardIndices[j] })
-	for _, idx := range cardIndices {
-		hand.Cards = append(hand.Cards[:idx], hand.Cards[idx+1:]...)
-	}
-
-	// Add all played cards to current trick
-	isLead = len(table.CurrentTrick) == 0
-	if isLead {
-		table.TrickLeader = playerSeat
-	}
-
-	for _, card := range cardsToPlay {
-		table.CurrentTrick = append(table.CurrentTrick, PlayedCard{
-			Card:      card,
-			Seat:      playerSeat,
-			IsLead:    isLead,
-			Timestamp: time.Now().UnixNano(),
-		})
-	}
-
-	table.UpdatedAt = time.Now()
+	isLead = applyPlay(table, playerSeat, cardIndices, time.Now())
 
 	// 判断牌型用于日志记录
 	playType := "single"
@@ -1442,7 +1532,7 @@ func PlayCardsGame(gameID, userID string, cardIndices []int) (*PlayResult, error
 
 	// 记录出牌日志
 	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
+		GameID:     table.GameID,
 		ActionType: "play_cards",
 		PlayerSeat: playerSeat,
 		PlayerID:   userID,
@@ -1462,37 +1552,26 @@ func PlayCardsGame(gameID, userID string, cardIndices []int) (*PlayResult, error
 		Message: fmt.Sprintf("Played %d cards", len(cardsToPlay)),
 	}
 
-	// Check if trick is complete (5 cards played - considering pairs/triples count as one play)
-	if len(table.CurrentTrick) >= 5 {
-		winner := determineTrickWinner(table.CurrentTrick, table.TrumpSuit, table.TrumpRank)
+	publishGameEvent(newTableEvent(table, "play_cards", playerSeat, map[string]interface{}{
+		"cards":          cardsToPlay,
+		"isLead":         isLead,
+		"playType":       playType,
+		"cardCount":      len(hand.Cards),
+		"friendRevealed": table.FriendRevealed,
+		"friendSeat":     table.FriendSeat,
+	}))
+
+	// Check if trick is complete (every seat has played, whatever the number of cards)
+	if isTrickComplete(table) {
+		winner := determineTrickWinner(table.TrickPlays, table.TrumpSuit, table.TrumpRank)
 		result.TrickComplete = true
 		result.TrickWinner = winner
 
-		// Collect scoring cards
-		var collectedCards []Card
-		pointsCollected := 0
-		for _, pc := range table.CurrentTrick {
-			if isScoringCard(pc.Card) {
-				collectedCards = append(collectedCards, pc.Card)
-				pointsCollected += getCardPoints(pc.Card)
-			}
-		}
-
-		// Winner gets the cards
-		if winnerHand, ok := table.PlayerHands[winner]; ok {
-			winnerHand.Collected = append(winnerHand.Collected, collectedCards...)
-		}
-
-		// Store all played cards in tricks won
-		var trickCards []Card
-		for _, pc := range table.CurrentTrick {
-			trickCards = append(trickCards, pc.Card)
-		}
-		table.TricksWon = append(table.TricksWon, trickCards)
+		trickCards, collectedCards, pointsCollected := completeTrick(table, winner)
 
 		// 记录回合结束日志
 		LogGameAction(GameActionLogRequest{
-			GameID:     gameID,
+			GameID:     table.GameID,
 			ActionType: "trick_complete",
 			PlayerSeat: winner,
 			PlayerID:   table.PlayerHands[winner].UserID,
@@ -1508,148 +1587,290 @@ func PlayCardsGame(gameID, userID string, cardIndices []int) (*PlayResult, error
 			},
 		})
 
-		// Clear trick and set winner as next leader
-		table.CurrentTrick = make([]PlayedCard, 0)
-		table.CurrentPlayer = winner
-		table.TrickLeader = winner
+		publishGameEvent(newTableEvent(table, "trick_complete", winner, map[string]interface{}{
+			"trickNumber":     len(table.TricksWon),
+			"winnerSeat":      winner,
+			"pointsCollected": pointsCollected,
+			"scoringCards":    collectedCards,
+		}))
 
-		// Check if game ended (all cards played)
-		allCardsPlayed := true
-		for _, hand := range table.PlayerHands {
-			if len(hand.Cards) > 0 {
-				allCardsPlayed = false
-				break
-			}
+		if table.Status == "finished" {
+			// Game ended - calculate final scores and results
+			result.GameEnded = true
+			totalPoints, winnerTeam := handResult(table, winner)
+			result.FinalScore = totalPoints
+			result.WinnerTeam = winnerTeam
+
+			// Calculate level changes from the levels the hand was played at
+			gameResults := handResults(table, totalPoints, winnerTeam)
+			result.GameResults = gameResults
+
+			publishGameEvent(newTableEvent(table, "game_end", 0, map[string]interface{}{
+				"winnerTeam":  result.WinnerTeam,
+				"finalScore":  result.FinalScore,
+				"gameResults": result.GameResults,
+				"bottomCards": table.BottomCards,
+			}))
+		}
+	} else {
+		result.NextPlayer = advanceTurn(table, playerSeat)
+	}
+
+	table.LastPlay = result
+	if result.GameEnded {
+		// 记录战绩、回放和发下一局要读写数据库，放到牌桌解锁之后
+		finished := table.Clone()
+		table.afterCommand(func() { recordFinishedHand(finished) })
+	}
+	return result, nil
+}
+
+// recordFinishedHand stores the result and replay of a finished hand and, in a
+// match, deals the next hand. It runs after the table lock is released, on a copy
+// of the finished table whose LastPlay holds the result.
+func recordFinishedHand(table *GameTable) {
+	totalPoints, winnerTeam, gameResults := table.LastPlay.FinalScore, table.LastPlay.WinnerTeam, table.LastPlay.GameResults
+	game, err := GetGame(table.GameID)
+	if err != nil || game == nil {
+		return
+	}
+
+	// Record game result and create replay
+	if err := RecordGameResult(table.GameID, gameResults); err != nil {
+		log.Printf("Failed to record game result: %v", err)
+	}
+
+	initialState := map[string]interface{}{
+		"dealerSeat": table.DealerSeat,
+		"trumpSuit":  table.TrumpSuit,
+		"trumpRank":  table.TrumpRank,
+	}
+
+	finalState := map[string]interface{}{
+		"totalPoints": totalPoints,
+		"winnerTeam":  winnerTeam,
+		"results":     gameResults,
+	}
+
+	// Get action count
+	actions, _ := GetGameActionLogs(table.GameID)
+	totalActions := len(actions)
+
+	// Calculate duration
+	durationSeconds := 0
+	if len(actions) > 0 {
+		startTime := actions[0].Timestamp
+		endTime := actions[len(actions)-1].Timestamp
+		durationSeconds = int(endTime.Sub(startTime).Seconds())
+	}
+
+	if err := CreateGameReplay(table.GameID, initialState, finalState, totalActions, durationSeconds, winnerTeam, totalPoints, replayFacets(table)); err != nil {
+		log.Printf("Failed to create game replay: %v", err)
+	}
+
+	// 比赛未结束则按上一局结果发下一局
+	if game.MatchID != "" {
+		if _, err := advanceMatch(game, table, totalPoints, winnerTeam, gameResults); err != nil {
+			log.Printf("Failed to advance match %s: %v", game.MatchID, err)
 		}
+	}
+}
 
-		if allCardsPlayed {
-			// Game ended - calculate final scores and results
-			result.GameEnded = true
+// The helpers below change the table for a play without logging or publishing
+// anything; the live engine and the replay reducer (Apply) both go through them.
 
-			// Calculate total points collected by non-host team
-			totalPoints := 0
-			for seat, hand := range table.PlayerHands {
-				// If not host or friend, count points
-				if seat != table.DealerSeat && (!table.FriendRevealed || seat != table.FriendSeat) {
-					for _, card := range hand.Collected {
-						totalPoints += getCardPoints(card)
-					}
-				}
-			}
+// applyPlay moves the cards at cardIndices from the seat's hand into the current
+// trick, revealing the friend when the Nth copy of the called card is played.
+// Returns isLead.
+func applyPlay(table *GameTable, seat int, cardIndices []int, now time.Time) bool {
+	hand := table.PlayerHands[seat]
 
-			// Add bottom cards to score if non-host team won last trick
-			if winner != table.DealerSeat && (!table.FriendRevealed || winner != table.FriendSeat) {
-				// Non-host team won last trick - bottom cards count double
-				if table.BottomCards != nil {
-					for _, bottomCard := range table.BottomCards {
-						totalPoints += getCardPoints(bottomCard) * 2
-					}
+	cards := make([]Card, 0, len(cardIndices))
+	for _, idx := range cardIndices {
+		cards = append(cards, hand.Cards[idx])
+	}
+
+	// 第N张朋友牌被打出时认定朋友（庄家自己打出第N张则成为1打4）
+	if called := table.HostCalledCard; called != nil && !table.FriendRevealed {
+		for _, card := range cards {
+			if card.Suit != called.Suit || card.Value != called.Value {
+				continue
+			}
+			called.Count++
+			if called.Count == called.Position {
+				table.FriendRevealed = true
+				table.FriendSeat = seat
+				if seat == table.DealerSeat {
+					table.IsSoloMode = true
+				} else {
+					hand.IsFriend = true
 				}
+				break
 			}
+		}
+	}
 
-			result.FinalScore = totalPoints
+	// Remove cards from hand (remove in reverse order to preserve indices)
+	sorted := append([]int(nil), cardIndices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	for _, idx := range sorted {
+		hand.Cards = append(hand.Cards[:idx], hand.Cards[idx+1:]...)
+	}
 
-			// Determine winner team based on score
-			if totalPoints >= 120 {
-				result.WinnerTeam = "guest" // 抓分方获胜
-			} else {
-				result.WinnerTeam = "host" // 庄家方获胜
-			}
+	// Add the play to the current trick (per seat) and its cards to CurrentTrick
+	isLead := len(table.TrickPlays) == 0
+	if isLead {
+		table.TrickLeader = seat
+	}
 
-			// Calculate level changes
-			game, err := GetGame(gameID)
-			if err == nil && game != nil {
-				// Determine if solo mode (friend not revealed or no friend)
-				isSolo := !table.FriendRevealed
-				winnerIsHost := result.WinnerTeam == "host"
-				levelUp := CalculateLevelUp(totalPoints, isSolo, winnerIsHost)
-
-				gameResults := make([]GameResult, 0)
-				for _, playerID := range game.PlayerIDs {
-					user, err := GetUserByID(playerID)
-					if err != nil {
-						continue
-					}
+	table.TrickPlays = append(table.TrickPlays, TrickPlay{
+		Seat:      seat,
+		Cards:     copyCards(cards),
+		IsLead:    isLead,
+		Timestamp: now.UnixNano(),
+	})
 
-					oldLevel := user.Level
-					newLevel := oldLevel
-					isWinner := false
-
-					// Find player seat
-					playerSeat := 0
-					for seat, hand := range table.PlayerHands {
-						if hand.UserID == playerID {
-							playerSeat = seat
-							break
-						}
-					}
+	for _, card := range cards {
+		table.CurrentTrick = append(table.CurrentTrick, PlayedCard{
+			Card:      card,
+			Seat:      seat,
+			IsLead:    isLead,
+			Timestamp: now.UnixNano(),
+		})
+	}
 
-					// Determine if winner
-					if result.WinnerTeam == "host" {
-						isWinner = (playerSeat == table.DealerSeat) || (table.FriendRevealed && playerSeat == table.FriendSeat)
-					} else {
-						isWinner = (playerSeat != table.DealerSeat) && (!table.FriendRevealed || playerSeat != table.FriendSeat)
-					}
+	table.UpdatedAt = now
+	return isLead
+}
 
-					// Update level for winners
-					if isWinner && levelUp > 0 {
-						newLevel = upgradeLevel(oldLevel, levelUp)
-					}
+// isTrickComplete reports whether every seat has played to the current trick
+func isTrickComplete(table *GameTable) bool {
+	return len(table.TrickPlays) >= len(table.PlayerHands)
+}
 
-					gameResults = append(gameResults, GameResult{
-						UserID:   playerID,
-						OldLevel: oldLevel,
-						NewLevel: newLevel,
-						IsWinner: isWinner,
-						Score:    totalPoints,
-					})
-				}
+// advanceTurn passes the turn to the next seat and returns it
+// Next player (counter-clockwise: 1→5→4→3→2→1)
+func advanceTurn(table *GameTable, seat int) int {
+	table.CurrentPlayer = ((seat - 2 + 5) % 5) + 1
+	return table.CurrentPlayer
+}
 
-				result.GameResults = gameResults
+// completeTrick gives the trick to the winner, who leads the next one
+// The table is marked finished once every hand is empty.
+func completeTrick(table *GameTable, winner int) (trickCards, collectedCards []Card, points int) {
+	// Collect scoring cards
+	for _, pc := range table.CurrentTrick {
+		if isScoringCard(pc.Card) {
+			collectedCards = append(collectedCards, pc.Card)
+			points += getCardPoints(pc.Card)
+		}
+	}
 
-				// Record game result and create replay
-				if err := RecordGameResult(gameID, gameResults); err != nil {
-					fmt.Printf("Failed to record game result: %v\n", err)
-				}
+	// Winner gets the cards
+	if winnerHand, ok := table.PlayerHands[winner]; ok {
+		winnerHand.Collected = append(winnerHand.Collected, collectedCards...)
+	}
 
-				// Create game replay
-				initialState := map[string]interface{}{
-					"dealerSeat": table.DealerSeat,
-					"trumpSuit":  table.TrumpSuit,
-					"trumpRank":  table.TrumpRank,
-				}
+	// Store all played cards in tricks won
+	for _, pc := range table.CurrentTrick {
+		trickCards = append(trickCards, pc.Card)
+	}
+	table.TricksWon = append(table.TricksWon, trickCards)
+	table.TrickHistory = append(table.TrickHistory, CompletedTrick{
+		Plays:  table.TrickPlays,
+		Winner: winner,
+	})
 
-				finalState := map[string]interface{}{
-					"totalPoints": totalPoints,
-					"winnerTeam":  result.WinnerTeam,
-					"results":     gameResults,
-				}
+	// Clear trick and set winner as next leader
+	table.CurrentTrick = make([]PlayedCard, 0)
+	table.TrickPlays = make([]TrickPlay, 0)
+	table.CurrentPlayer = winner
+	table.TrickLeader = winner
 
-				// Get action count
-				actions, _ := GetGameActionLogs(gameID)
-				totalActions := len(actions)
+	// Check if game ended (all cards played)
+	allCardsPlayed := true
+	for _, hand := range table.PlayerHands {
+		if len(hand.Cards) > 0 {
+			allCardsPlayed = false
+			break
+		}
+	}
+	if allCardsPlayed {
+		table.Status = "finished"
+	}
 
-				// Calculate duration
-				durationSeconds := 0
-				if len(actions) > 0 {
-					startTime := actions[0].Timestamp
-					endTime := actions[len(actions)-1].Timestamp
-					durationSeconds = int(endTime.Sub(startTime).Seconds())
-				}
+	return trickCards, collectedCards, points
+}
 
-				if err := CreateGameReplay(gameID, initialState, finalState, totalActions, durationSeconds, result.WinnerTeam, totalPoints); err != nil {
-					fmt.Printf("Failed to create game replay: %v\n", err)
-				}
+// handResult counts the points of the non-host team and picks the winning team
+// lastWinner is the seat that won the final trick (for the bottom cards).
+func handResult(table *GameTable, lastWinner int) (int, string) {
+	// Calculate total points collected by non-host team
+	totalPoints := 0
+	for seat, hand := range table.PlayerHands {
+		// If not host or friend, count points
+		if seat != table.DealerSeat && (!table.FriendRevealed || seat != table.FriendSeat) {
+			for _, card := range hand.Collected {
+				totalPoints += getCardPoints(card)
 			}
 		}
-	} else {
-		// Next player (counter-clockwise: 1→5→4→3→2→1)
-		result.NextPlayer = ((playerSeat - 2 + 5) % 5) + 1
-		table.CurrentPlayer = result.NextPlayer
 	}
 
-	table.LastPlay = result
-	return result, nil
+	// Add bottom cards to score if non-host team won last trick
+	if lastWinner != table.DealerSeat && (!table.FriendRevealed || lastWinner != table.FriendSeat) {
+		// Non-host team won last trick - bottom cards count double
+		for _, bottomCard := range table.BottomCards {
+			totalPoints += getCardPoints(bottomCard) * 2
+		}
+	}
+
+	// Determine winner team based on score
+	if totalPoints >= 120 {
+		return totalPoints, "guest" // 抓分方获胜
+	}
+	return totalPoints, "host" // 庄家方获胜
+}
+
+// handResults computes every player's level change for a finished hand
+// Levels come from the table (the level each seat played the hand at), so the
+// result doesn't depend on anything outside the table.
+func handResults(table *GameTable, totalPoints int, winnerTeam string) []GameResult {
+	// Determine if solo mode (called card was the dealer's, or never played)
+	isSolo := table.IsSoloMode || !table.FriendRevealed
+	winnerIsHost := winnerTeam == "host"
+	levelUp := CalculateLevelUp(totalPoints, isSolo, winnerIsHost)
+
+	results := make([]GameResult, 0, len(table.PlayerHands))
+	for seat := 1; seat <= 5; seat++ {
+		hand, ok := table.PlayerHands[seat]
+		if !ok {
+			continue
+		}
+
+		oldLevel := hand.Level
+		if oldLevel == "" {
+			oldLevel = "2"
+		}
+		newLevel := oldLevel
+
+		// Determine if winner
+		isHostTeam := seat == table.DealerSeat || (table.FriendRevealed && seat == table.FriendSeat)
+		isWinner := isHostTeam == winnerIsHost
+
+		// Update level for winners
+		if isWinner && levelUp > 0 {
+			newLevel = upgradeLevel(oldLevel, levelUp)
+		}
+
+		results = append(results, GameResult{
+			UserID:   hand.UserID,
+			OldLevel: oldLevel,
+			NewLevel: newLevel,
+			IsWinner: isWinner,
+			Score:    totalPoints,
+		})
+	}
+	return results
 }
 
 // validateCardPlay validates if the selected cards form a valid play
@@ -1659,7 +1880,7 @@ func validateCardPlay(cards []Card, table *GameTable) error {
 	}
 
 	// Check if this is the first play of the trick
-	isLead := len(table.CurrentTrick) == 0
+	isLead := len(table.TrickPlays) == 0
 
 	if isLead {
 		// Leading: can play single card, pair, triple, or tractor
@@ -1685,25 +1906,50 @@ func validateLeadPlay(cards []Card, table *GameTable) error {
 		// If not a valid tractor, continue to check pair/triple
 	}
 
-	// For pairs and triples, all cards must have same value AND same suit
-	firstValue := cards[0].Value
+	// Pairs, triples and throws (甩牌) must be of one suit
 	firstSuit := cards[0].Suit
-
 	for _, card := range cards {
-		if card.Value != firstValue {
-			return fmt.Errorf("all cards must have the same value for pairs/triples")
-		}
 		if card.Suit != firstSuit {
-			return fmt.Errorf("all cards must have the same suit for pairs/triples")
+			return fmt.Errorf("all cards must have the same suit")
 		}
 	}
 
-	// Pair (2 cards) or Triple (3 cards) with same value and suit is valid
-	if len(cards) == 2 || len(cards) == 3 {
-		return nil
+	// Pair or triple, or a throw of any other cards of the suit: playCardsGame has
+	// already checked with ValidateThrowCards that nobody can beat part of it
+	// (甩不出时只出最小的一组)
+	return nil
+}
+
+// resolveThrow checks a lead of several cards as a throw (甩牌)
+// If another seat can beat part of it, only the smallest group is played: the
+// returned indices and cards are what is actually played, reason why it failed
+// ("" if the lead stands as chosen).
+func resolveThrow(table *GameTable, seat int, cardIndices []int) ([]int, []Card, string) {
+	hand := table.PlayerHands[seat]
+	cards := make([]Card, 0, len(cardIndices))
+	for _, idx := range cardIndices {
+		cards = append(cards, hand.Cards[idx])
+	}
+
+	throwResult := ValidateThrowCards(cards, table, seat)
+	if throwResult.IsValid || len(throwResult.ActualPlay) >= len(cards) {
+		return cardIndices, cards, ""
 	}
 
-	return fmt.Errorf("invalid card combination")
+	// 甩牌失败，只出最小的牌
+	// 重新计算 cardIndices，只保留要出的牌（按牌匹配，保证日志与手牌一致）
+	actualCardIndices := make([]int, 0, len(throwResult.ActualPlay))
+	used := make(map[int]bool)
+	for _, card := range throwResult.ActualPlay {
+		for _, idx := range cardIndices {
+			if !used[idx] && hand.Cards[idx].Suit == card.Suit && hand.Cards[idx].Value == card.Value {
+				used[idx] = true
+				actualCardIndices = append(actualCardIndices, idx)
+				break
+			}
+		}
+	}
+	return actualCardIndices, throwResult.ActualPlay, throwResult.Reason
 }
 
 // ThrowCardsResult represents the result of a throw cards validation
@@ -1782,21 +2028,25 @@ func ValidateThrowCards(cards []Card, table *GameTable, playerSeat int) *ThrowCa
 		// 如果能管上任意一种牌型，甩牌失败
 		if len(canBeat) > 0 {
 			// 选择要留下的最小牌型
-			// 优先级：三张 > 对子 > 单张
+			// 优先级：三张 > 对子 > 单张，同一牌型留最小的
+			keeps := func(group, keep *CardGroup) bool {
+				if keep == nil || getTypePriority(group.Type) != getTypePriority(keep.Type) {
+					return keep == nil || getTypePriority(group.Type) > getTypePriority(keep.Type)
+				}
+				return getCardNumericValue(group.Value) < getCardNumericValue(keep.Value)
+			}
 			var keepGroup *CardGroup
-			for _, group := range groups {
-				if !canBeat[group.Type] {
-					if keepGroup == nil || getTypePriority(group.Type) > getTypePriority(keepGroup.Type) {
-						keepGroup = &group
-					}
+			for i := range groups {
+				if !canBeat[groups[i].Type] && keeps(&groups[i], keepGroup) {
+					keepGroup = &groups[i]
 				}
 			}
 
 			// 如果所有牌型都能被管上，选择三张、对子、单张中的最小
 			if keepGroup == nil {
-				for _, group := range groups {
-					if keepGroup == nil || getTypePriority(group.Type) > getTypePriority(keepGroup.Type) {
-						keepGroup = &group
+				for i := range groups {
+					if keeps(&groups[i], keepGroup) {
+						keepGroup = &groups[i]
 					}
 				}
 			}
@@ -2121,24 +2371,13 @@ func getCardNumericValue(value string) int {
 // 4. 主牌杀（无色时用主牌，牌型需完美匹配）
 // 5. 垫任意其他牌
 func validateFollowPlay(cards []Card, table *GameTable) error {
-	if len(table.CurrentTrick) == 0 {
+	if len(table.TrickPlays) == 0 {
 		return fmt.Errorf("no lead to follow")
 	}
 
 	// Get the lead play
-	leadPlay := table.CurrentTrick[0].Card
-	leadSuit := leadPlay.Suit
-	leadSeat := table.CurrentTrick[0].Seat
-
-	// Count how many cards the leader played and what type
-	leadCards := []Card{}
-	for _, pc := range table.CurrentTrick {
-		if pc.Seat == leadSeat {
-			leadCards = append(leadCards, pc.Card)
-		} else {
-			break
-		}
-	}
+	leadCards := table.TrickPlays[0].Cards
+	leadSuit := leadCards[0].Suit
 
 	leadCardCount := len(leadCards)
 
@@ -2181,12 +2420,18 @@ func validateFollowPlay(cards []Card, table *GameTable) error {
 
 		// 2. 相同花色的对子（如果领出的是对子或三张）
 		if (isLeadPair || isLeadTriple) && !isPlayerPair && !isPlayerTriple {
-			// 检查是否有相同花色的对子
-			if hasPairInSuit(cards, leadSuit) {
+			// 检查手里是否有相同花色的对子却没跟
+			if hand, ok := table.PlayerHands[table.CurrentPlayer]; ok &&
+				!hasPairInSuit(cards, leadSuit) && hasPairInSuit(hand.Cards, leadSuit) {
 				return fmt.Errorf("有相同花色的对子，必须跟对子")
 			}
 		}
 
+		// 同花色不够时，全部跟出后再垫其他牌
+		if held := countSuitInHand(table, leadSuit); held < leadCardCount && countSuit(cards, leadSuit) == held {
+			return nil
+		}
+
 		// 3. 相同花色的单张
 		return validateSingleSuitFollow(cards, leadSuit)
 	}
@@ -2227,7 +2472,7 @@ func validateFollowPlay(cards []Card, table *GameTable) error {
 					return nil // 主牌单张组合杀成功
 				}
 			}
-			return fmt.Errorf("主牌杀必须牌型匹配")
+			// 牌型不匹配的主牌只算垫牌，管不上（手里只剩主牌时也必须能出）
 		}
 	}
 
@@ -2309,6 +2554,27 @@ func hasPairInSuit(cards []Card, suit string) bool {
 	return false
 }
 
+// countSuit counts the cards of the given suit
+func countSuit(cards []Card, suit string) int {
+	n := 0
+	for _, card := range cards {
+		if card.Suit == suit {
+			n++
+		}
+	}
+	return n
+}
+
+// countSuitInHand counts the cards of the given suit held by the current player
+// Returns -1 if the hand is unknown.
+func countSuitInHand(table *GameTable, suit string) int {
+	hand, ok := table.PlayerHands[table.CurrentPlayer]
+	if !ok {
+		return -1
+	}
+	return countSuit(hand.Cards, suit)
+}
+
 // validateSingleSuitFollow validates following with single cards (when can't match pair/triple)
 func validateSingleSuitFollow(cards []Card, leadSuit string) error {
 	// Check if player has any card of lead suit
@@ -2336,12 +2602,15 @@ func validateSingleSuitFollow(cards []Card, leadSuit string) error {
 
 // CallDealer handles a player calling for dealer (抢庄)
 // 玩家用级牌叫庄，决定主牌花色
-func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTable, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
-	}
+func CallDealer(gameID string, userID string, suit string, cardIndices []int) (*GameTable, error) {
+	return updateTable(gameID, func(table *GameTable) error {
+		_, err := callDealer(table, userID, suit, cardIndices)
+		return err
+	})
+}
 
+// callDealer implements CallDealer; the caller must hold the table lock
+func callDealer(table *GameTable, userID string, suit string, cardIndices []int) (*GameTable, error) {
 	if table.Status != "calling" {
 		return nil, fmt.Errorf("game not in calling phase")
 	}
@@ -2365,16 +2634,24 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 		return nil, fmt.Errorf("not in countdown phase")
 	}
 
-	// Validate card indices and check they are rank cards
-	rank := table.TrumpRank // 当前级牌点数（如"2"）
+	if len(cardIndices) == 0 {
+		return nil, fmt.Errorf("no cards selected")
+	}
+
+	// Validate card indices; all cards must be the same rank card
+	// 各自记级：亮的级牌可以是自己的级牌，反庄时也可以是临时庄家的级牌
+	var rank string
 	var cardsToPlay []Card
 	for _, idx := range cardIndices {
 		if idx < 0 || idx >= len(hand.Cards) {
 			return nil, fmt.Errorf("invalid card index")
 		}
 		card := hand.Cards[idx]
+		if rank == "" {
+			rank = card.Value
+		}
 		// 检查是否是级牌
-		if card.Value != rank {
+		if card.Suit == "joker" || card.Value != rank {
 			return nil, fmt.Errorf("只能用级牌叫庄")
 		}
 		cardsToPlay = append(cardsToPlay, card)
@@ -2388,18 +2665,12 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 		}
 	}
 
-	// 记录叫庄
-	table.CallRecords = append(table.CallRecords, CallRecord{
-		Seat:      playerSeat,
-		Suit:      suit,
-		Rank:      rank,
-		Count:     len(cardsToPlay),
-		Timestamp: time.Now().UnixNano(),
-	})
+	playerLevel := hand.Level
 
 	// 检查反庄（如果之前已经有人叫庄）
-	if len(table.CallRecords) > 1 {
-		lastCall := table.CallRecords[len(table.CallRecords)-2]
+	// 先校验再记录，失败的反庄不会留在CallRecords里
+	if len(table.CallRecords) > 0 {
+		lastCall := table.CallRecords[len(table.CallRecords)-1]
 
 		// 反庄基础规则：必须比临时庄家亮的牌多至少一张
 		if len(cardsToPlay) <= lastCall.Count {
@@ -2409,21 +2680,14 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 			return nil, fmt.Errorf("反主最多3张")
 		}
 
-		// 获取反庄玩家的等级
-		playerUser, err := GetUserByID(userID)
-		if err != nil {
-			return nil, fmt.Errorf("无法获取玩家信息")
-		}
-		playerLevel := playerUser.Level
-
 		// 判断反庄方式
-		// 特殊情况：当反庄者的级牌也是2（与临时庄家相同）时，用2反庄会转移庄家
+		// 特殊情况：当反庄者的级牌与临时庄家相同时，用该级牌反庄会转移庄家
 		// 方式一：用临时庄家的级牌反庄（rank == lastCall.Rank 且 rank != playerLevel）
 		// 方式二：用玩家自己的级牌反庄（rank == playerLevel）
 
 		if rank == lastCall.Rank && rank == playerLevel {
-			// 特殊情况：反庄者的级牌也是2（与临时庄家相同）
-			// 使用2反庄时，庄家转移给反庄者，同时改变主牌花色
+			// 特殊情况：反庄者的级牌与临时庄家相同
+			// 庄家转移给反庄者，同时改变主牌花色
 			table.TrumpRank = rank
 			table.DealerSeat = playerSeat
 			table.TrumpSuit = suit
@@ -2437,7 +2701,7 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 			table.HostID = table.PlayerHands[lastCall.Seat].UserID
 		} else if rank == playerLevel {
 			// 方式二：用玩家自己的级牌反庄
-			// 玩家变为临时庄家，主牌花色变为玩家亮的花色
+			// 玩家变为临时庄家，主牌花色变为玩家亮的花色，级牌变为玩家的等级
 			table.TrumpRank = rank
 			table.DealerSeat = playerSeat
 			table.TrumpSuit = suit
@@ -2447,31 +2711,33 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 		}
 	}
 
-	// 首次叫庄或反庄成功后，验证rank是否为玩家的等级
-	if len(table.CallRecords) == 1 {
-		// 首次叫庄，必须使用玩家自己的级牌
-		playerUser, err := GetUserByID(userID)
-		if err != nil {
-			return nil, fmt.Errorf("无法获取玩家信息")
-		}
-		if rank != playerUser.Level {
+	// 首次叫庄，必须使用玩家自己的级牌
+	if len(table.CallRecords) == 0 {
+		if rank != playerLevel {
 			return nil, fmt.Errorf("首次叫庄必须使用自己的级牌")
 		}
-	}
 
-	// 设置庄家和主牌花色（首次叫庄）
-	if len(table.CallRecords) == 1 {
+		// 设置庄家和主牌花色（首次叫庄）
 		table.DealerSeat = playerSeat
 		table.TrumpSuit = suit
 		table.HostID = userID
 		table.TrumpRank = rank
 	}
 
-	table.CallPhase = "finished"
+	// 记录叫庄
+	table.CallRecords = append(table.CallRecords, CallRecord{
+		Seat:      playerSeat,
+		Suit:      suit,
+		Rank:      rank,
+		Count:     len(cardsToPlay),
+		Timestamp: time.Now().UnixNano(),
+	})
+
+	// 继续倒计时等待反庄，倒计时结束才定庄（见 endCallCountdown）
 
 	// 记录抢庄日志
 	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
+		GameID:     table.GameID,
 		ActionType: "call_dealer",
 		PlayerSeat: playerSeat,
 		PlayerID:   userID,
@@ -2487,15 +2753,23 @@ func CallDealer(gameID, userID string, suit string, cardIndices []int) (*GameTab
 			"trump_suit":  table.TrumpSuit,
 			"trump_rank":  table.TrumpRank,
 			"call_phase":  table.CallPhase,
+			"status":      table.Status,
 		},
 	})
 
-	// 如果是单人模式，直接进入找朋友阶段
-	if isSinglePlayerGame(table) {
-		return finalizeDealerAndStartPlaying(table)
+	table.UpdatedAt = time.Now()
+
+	evt := newTableEvent(table, "call_dealer", playerSeat, map[string]interface{}{
+		"suit":  suit,
+		"rank":  rank,
+		"count": len(cardsToPlay),
+		"cards": cardsToPlay,
+	})
+	if table.Status == "discarding" {
+		evt = evt.withHandUpdate(table, table.DealerSeat)
 	}
+	publishGameEvent(evt)
 
-	table.UpdatedAt = time.Now()
 	return table, nil
 }
 
@@ -2513,11 +2787,14 @@ func isSinglePlayerGame(table *GameTable) bool {
 // FlipBottomCard handles flipping a card from the bottom to determine dealer
 // 翻底牌定庄
 func FlipBottomCard(gameID string) (*GameTable, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
-	}
+	return updateTable(gameID, func(table *GameTable) error {
+		_, err := flipBottomCard(table)
+		return err
+	})
+}
 
+// flipBottomCard implements FlipBottomCard; the caller must hold the table lock
+func flipBottomCard(table *GameTable) (*GameTable, error) {
 	if table.Status != "calling" {
 		return nil, fmt.Errorf("game not in calling phase")
 	}
@@ -2534,52 +2811,67 @@ func FlipBottomCard(gameID string) (*GameTable, error) {
 	nextCard := table.BottomCards[len(table.FlippedBottomCards)]
 	table.FlippedBottomCards = append(table.FlippedBottomCards, nextCard)
 
-	// 记录翻底牌日志
-	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
-		ActionType: "flip_bottom",
-		PlayerSeat: 0,
-		PlayerID:   "",
-		ActionData: map[string]interface{}{
-			"card":          nextCard,
-			"flipped_count": len(table.FlippedBottomCards),
-			"total_bottom":  len(table.BottomCards),
-		},
-		ResultData: map[string]interface{}{
-			"card_suit":  nextCard.Suit,
-			"card_value": nextCard.Value,
-		},
-	})
+	// 记录翻底牌日志：等定庄结果出来后再写，回放时直接使用结果
+	defer func() {
+		LogGameAction(GameActionLogRequest{
+			GameID:     table.GameID,
+			ActionType: "flip_bottom",
+			PlayerSeat: 0,
+			PlayerID:   "",
+			ActionData: map[string]interface{}{
+				"card":          nextCard,
+				"flipped_count": len(table.FlippedBottomCards),
+				"total_bottom":  len(table.BottomCards),
+			},
+			ResultData: map[string]interface{}{
+				"card_suit":   nextCard.Suit,
+				"card_value":  nextCard.Value,
+				"dealer_seat": table.DealerSeat,
+				"trump_suit":  table.TrumpSuit,
+				"call_phase":  table.CallPhase,
+				"status":      table.Status,
+			},
+		})
+
+		evt := newTableEvent(table, "flip_bottom", 0, map[string]interface{}{
+			"card":         nextCard,
+			"flippedCount": len(table.FlippedBottomCards),
+			"totalBottom":  len(table.BottomCards),
+		})
+		if table.Status == "discarding" {
+			evt = evt.withHandUpdate(table, table.DealerSeat)
+		}
+		publishGameEvent(evt)
+	}()
 
-	// 检查是否翻到了级牌
-	rank := table.TrumpRank
-	if nextCard.Value == rank {
-		// 找到了级牌，确定庄家
-		// 找出所有打这个级别的玩家，按逆时针顺序选择距离起始发牌人最近的
-		var candidates []int
+	// 检查是否翻到了级牌：翻出的点数等于场上某位玩家的等级
+	// 找出所有打这个级别的玩家，按逆时针顺序选择距离起始发牌人最近的
+	var candidates []int
+	if nextCard.Suit != "joker" {
 		for seat, hand := range table.PlayerHands {
-			// 检查玩家的等级
-			user, err := GetUserByID(hand.UserID)
-			if err == nil && user.Level == rank {
+			if hand.Level == nextCard.Value {
 				candidates = append(candidates, seat)
 			}
 		}
+	}
 
-		if len(candidates) > 0 {
-			// 按逆时针顺序找最近的
-			selectedSeat := findClosestSeatCounterClockwise(table.StartingDealerSeat, candidates)
-			table.DealerSeat = selectedSeat
-			table.TrumpSuit = nextCard.Suit
-			table.HostID = table.PlayerHands[selectedSeat].UserID
-			table.CallPhase = "finished"
+	if len(candidates) > 0 {
+		// 按逆时针顺序找最近的
+		selectedSeat := findClosestSeatCounterClockwise(table.StartingDealerSeat, candidates)
+		table.DealerSeat = selectedSeat
+		table.TrumpSuit = nextCard.Suit
+		table.TrumpRank = nextCard.Value
+		table.HostID = table.PlayerHands[selectedSeat].UserID
+		table.CallPhase = "finished"
 
-			return finalizeDealerAndStartPlaying(table)
-		}
+		return finalizeDealerAndStartPlaying(table)
 	}
 
 	// 如果翻完了所有底牌还没定庄，则首发人当庄
 	if len(table.FlippedBottomCards) >= len(table.BottomCards) {
 		table.DealerSeat = table.StartingDealerSeat
+		// 级牌为首发人的等级
+		table.TrumpRank = table.PlayerHands[table.StartingDealerSeat].Level
 
 		// 从第7张底牌开始往前找，找第一张有花色的牌（非王）
 		trumpSuit := ""
@@ -2655,11 +2947,17 @@ func finalizeDealerAndStartPlaying(table *GameTable) (*GameTable, error) {
 
 // DiscardBottomCards 庄家扣牌（选择7张牌扣回底牌）
 func DiscardBottomCards(gameID string, userID string, cardIndices []int) (*GameTable, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
-	}
+	return updateTable(gameID, func(table *GameTable) error {
+		if _, err := discardBottomCards(table, userID, cardIndices); err != nil {
+			return err
+		}
+		playerActed(table, userID)
+		return nil
+	})
+}
 
+// discardBottomCards implements DiscardBottomCards; the caller must hold the table lock
+func discardBottomCards(table *GameTable, userID string, cardIndices []int) (*GameTable, error) {
 	if table.Status != "discarding" {
 		return nil, fmt.Errorf("game not in discarding phase")
 	}
@@ -2682,33 +2980,20 @@ func DiscardBottomCards(gameID string, userID string, cardIndices []int) (*GameT
 		}
 	}
 
-	// 收集要扣的牌
-	var discardedCards []Card
 	usedIndices := make(map[int]bool)
 	for _, idx := range cardIndices {
 		if usedIndices[idx] {
 			return nil, fmt.Errorf("duplicate card index: %d", idx)
 		}
 		usedIndices[idx] = true
-		discardedCards = append(discardedCards, dealerHand.Cards[idx])
-	}
-
-	// 从庄家手牌中移除扣的牌
-	newHandCards := make([]Card, 0, len(dealerHand.Cards)-7)
-	for i, card := range dealerHand.Cards {
-		if !usedIndices[i] {
-			newHandCards = append(newHandCards, card)
-		}
 	}
-	dealerHand.Cards = newHandCards
-	table.PlayerHands[table.DealerSeat] = dealerHand
 
-	// 将扣的牌放回底牌
-	table.BottomCards = discardedCards
+	applyDiscard(table, cardIndices, time.Now())
+	discardedCards := table.BottomCards
 
 	// 记录扣牌日志
 	LogGameAction(GameActionLogRequest{
-		GameID:     gameID,
+		GameID:     table.GameID,
 		ActionType: "discard_bottom",
 		PlayerSeat: table.DealerSeat,
 		PlayerID:   userID,
@@ -2717,11 +3002,44 @@ func DiscardBottomCards(gameID string, userID string, cardIndices []int) (*GameT
 			"discarded_cards": discardedCards,
 		},
 		ResultData: map[string]interface{}{
-			"status":      "success",
+			"status":      table.Status,
 			"dealer_seat": table.DealerSeat,
 		},
 	})
 
+	publishGameEvent(newTableEvent(table, "discard_bottom", table.DealerSeat, map[string]interface{}{
+		"count": len(discardedCards),
+	}).withHandUpdate(table, table.DealerSeat))
+
+	return table, nil
+}
+
+// applyDiscard buries the cards at cardIndices as the new bottom and moves on to
+// calling_friend (or straight to playing if the friend card is already called)
+// Indices must be valid and distinct; shared with the replay reducer.
+func applyDiscard(table *GameTable, cardIndices []int, now time.Time) {
+	dealerHand := table.PlayerHands[table.DealerSeat]
+
+	// 收集要扣的牌
+	discardedCards := make([]Card, 0, len(cardIndices))
+	usedIndices := make(map[int]bool)
+	for _, idx := range cardIndices {
+		usedIndices[idx] = true
+		discardedCards = append(discardedCards, dealerHand.Cards[idx])
+	}
+
+	// 从庄家手牌中移除扣的牌
+	newHandCards := make([]Card, 0, len(dealerHand.Cards)-len(cardIndices))
+	for i, card := range dealerHand.Cards {
+		if !usedIndices[i] {
+			newHandCards = append(newHandCards, card)
+		}
+	}
+	dealerHand.Cards = newHandCards
+
+	// 将扣的牌放回底牌
+	table.BottomCards = discardedCards
+
 	// 检查是否已经叫了朋友
 	if table.HostCalledCard == nil {
 		// 进入找朋友阶段
@@ -2734,9 +3052,7 @@ func DiscardBottomCards(gameID string, userID string, cardIndices []int) (*GameT
 		table.CallPhase = "finished"
 	}
 
-	table.UpdatedAt = time.Now()
-
-	return table, nil
+	table.UpdatedAt = now
 }
 
 // ==================== 抠底相关函数 ====================
@@ -2881,49 +3197,6 @@ func CalculateBottomCardsScore(bottomCards []Card, multiplier int) int {
 	return totalScore * multiplier
 }
 
-// AutoCallForDealer 自动为AI玩家叫庄（单人模式）
-func AutoCallForDealer(gameID string) (*GameTable, error) {
-	table, err := GetTableGame(gameID)
-	if err != nil {
-		return nil, err
-	}
-
-	if table.Status != "calling" && table.Status != "playing" {
-		return nil, fmt.Errorf("game not in calling phase")
-	}
-
-	if table.TrumpSuit != "" {
-		// Already has trump suit, skip
-		return table, nil
-	}
-
-	// 在单人模式中，玩家1（人类）是庄家，直接使用人类玩家的最长花色作为主牌
-	if hand, ok := table.PlayerHands[1]; ok {
-		// 找出最长的花色
-		suitCounts := make(map[string]int)
-		for _, card := range hand.Cards {
-			suitCounts[card.Suit]++
-		}
-
-		longestSuit := ""
-		maxCount := 0
-		for suit, count := range suitCounts {
-			if count > maxCount && suit != "joker" {
-				maxCount = count
-				longestSuit = suit
-			}
-		}
-
-		table.DealerSeat = 1
-		table.TrumpSuit = longestSuit
-		table.CallPhase = "finished"
-
-		return finalizeDealerAndStartPlaying(table)
-	}
-
-	return nil, fmt.Errorf("human player not found")
-}
-
 // upgradeLevel upgrades a player's level by the specified number of levels
 func upgradeLevel(currentLevel string, levelsUp int) string {
 	if levelsUp <= 0 {
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sync"
 	"time"
 )
 
@@ -32,6 +34,38 @@ type GameReplay struct {
 	CreatedAt       time.Time       `json:"createdAt"`
 }
 
+// ReplayFacets are the facts of a hand that replays can be searched by
+type ReplayFacets struct {
+	DealerSeat   int    `json:"dealerSeat"`
+	DealerID     string `json:"dealerId"`
+	Level        string `json:"level"`        // 打的级（主牌点数）
+	SoloMode     bool   `json:"soloMode"`     // 1打4
+	BottomTaken  bool   `json:"bottomTaken"`  // 抠底：抓分方赢了最后一墩
+	BottomPoints int    `json:"bottomPoints"` // 抠底加的分（底牌分翻倍）
+}
+
+// replayFacets reads the searchable facts off a finished table
+func replayFacets(table *GameTable) ReplayFacets {
+	facets := ReplayFacets{
+		DealerSeat: table.DealerSeat,
+		Level:      table.TrumpRank,
+		SoloMode:   table.IsSoloMode || !table.FriendRevealed,
+	}
+	if hand, ok := table.PlayerHands[table.DealerSeat]; ok {
+		facets.DealerID = hand.UserID
+	}
+	if n := len(table.TrickHistory); n > 0 {
+		winner := table.TrickHistory[n-1].Winner
+		if winner != table.DealerSeat && (!table.FriendRevealed || winner != table.FriendSeat) {
+			facets.BottomTaken = true
+			for _, card := range table.BottomCards {
+				facets.BottomPoints += getCardPoints(card) * 2
+			}
+		}
+	}
+	return facets
+}
+
 // GameActionLogRequest represents the data for logging a game action
 type GameActionLogRequest struct {
 	GameID     string      `json:"gameId"`
@@ -43,7 +77,16 @@ type GameActionLogRequest struct {
 }
 
 // LogGameAction logs a game action to the database
+// The row is written after the caller returns (see actionLogs), so the call
+// costs no database round trip under the table lock.
+// Without a database (self-play, see simulate.go) nothing is logged. Tables
+// being captured (see captureActions) log to memory instead.
 func LogGameAction(req GameActionLogRequest) error {
+	capture, capturing := actionCaptures.Load(req.GameID)
+	if db == nil && !capturing {
+		return nil
+	}
+
 	actionDataJSON, err := json.Marshal(req.ActionData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal action data: %w", err)
@@ -57,26 +100,148 @@ func LogGameAction(req GameActionLogRequest) error {
 		}
 	}
 
-	query := `
-		INSERT INTO game_action_logs (game_id, action_type, player_seat, player_id, action_data, result_data)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`
+	if capturing {
+		capture.(*actionCapture).add(GameActionLog{
+			GameID:     req.GameID,
+			ActionType: req.ActionType,
+			PlayerSeat: req.PlayerSeat,
+			PlayerID:   req.PlayerID,
+			ActionData: actionDataJSON,
+			ResultData: resultDataJSON,
+			Timestamp:  time.Now(),
+		})
+		return nil
+	}
+
+	actionLogs.queue(GameActionLog{
+		GameID:     req.GameID,
+		ActionType: req.ActionType,
+		PlayerSeat: req.PlayerSeat,
+		PlayerID:   req.PlayerID,
+		ActionData: actionDataJSON,
+		ResultData: resultDataJSON,
+	})
+	return nil
+}
+
+// actionLogs holds the rows logged but not yet written, by game ID
+// Actions are mostly logged while the table is locked; the INSERT runs in the
+// background instead, so other commands on the table don't wait for the
+// database. Each game's rows are written one at a time in the order they were
+// logged, and GetGameActionLogs writes a game's pending rows before reading.
+var actionLogs = newActionLogQueue(insertGameAction)
+
+// actionLogQueue holds the pending rows of every game
+type actionLogQueue struct {
+	mu    sync.Mutex
+	games map[string]*actionLogWriter
+	write func(action GameActionLog) error
+}
+
+func newActionLogQueue(write func(action GameActionLog) error) *actionLogQueue {
+	return &actionLogQueue{games: make(map[string]*actionLogWriter), write: write}
+}
+
+// actionLogWriter holds one game's pending rows
+type actionLogWriter struct {
+	writeMu sync.Mutex // one writer at a time, so rows keep their order
+	pending []GameActionLog
+}
 
-	_, err = db.Exec(query, req.GameID, req.ActionType, req.PlayerSeat, req.PlayerID, actionDataJSON, resultDataJSON)
+// queue adds a row to its game's pending rows and writes them in the background
+func (q *actionLogQueue) queue(action GameActionLog) {
+	q.mu.Lock()
+	w, ok := q.games[action.GameID]
+	if !ok {
+		w = &actionLogWriter{}
+		q.games[action.GameID] = w
+	}
+	w.pending = append(w.pending, action)
+	q.mu.Unlock()
+
+	go q.flush(action.GameID)
+}
+
+// flush writes the game's pending rows in order; failures are logged
+func (q *actionLogQueue) flush(gameID string) {
+	q.mu.Lock()
+	w, ok := q.games[gameID]
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	q.mu.Lock()
+	rows := w.pending
+	w.pending = nil
+	q.mu.Unlock()
+
+	for _, row := range rows {
+		if err := q.write(row); err != nil {
+			log.Printf("Warning: game %s: %v", gameID, err)
+		}
+	}
+
+	// 写完且没有新的行，这一局的队列就可以去掉了
+	q.mu.Lock()
+	if q.games[gameID] == w && len(w.pending) == 0 {
+		delete(q.games, gameID)
+	}
+	q.mu.Unlock()
+}
+
+// insertGameAction writes one action row
+func insertGameAction(action GameActionLog) error {
+	// 系统动作（翻底牌、倒计时结束、结束）没有玩家，player_id 记 NULL 以满足外键
+	_, err := db.Exec(`
+		INSERT INTO game_action_logs (game_id, action_type, player_seat, player_id, action_data, result_data)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+	`, action.GameID, action.ActionType, action.PlayerSeat, action.PlayerID, []byte(action.ActionData), []byte(action.ResultData))
 	if err != nil {
 		return fmt.Errorf("failed to insert game action log: %w", err)
 	}
-
 	return nil
 }
 
+// actionCaptures holds the tables whose actions are kept in memory, by game ID
+var actionCaptures sync.Map // gameID -> *actionCapture
+
+// actionCapture collects the actions logged for one table
+type actionCapture struct {
+	mu      sync.Mutex
+	actions []GameActionLog
+}
+
+func (c *actionCapture) add(action GameActionLog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.actions = append(c.actions, action)
+}
+
+// captureActions keeps the actions logged for gameID in memory instead of the
+// database until the returned function is called; it returns them in order.
+// Used to play a table through the live commands without storing it (game records).
+func captureActions(gameID string) func() []GameActionLog {
+	capture := &actionCapture{}
+	actionCaptures.Store(gameID, capture)
+	return func() []GameActionLog {
+		actionCaptures.Delete(gameID)
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+		return capture.actions
+	}
+}
+
 // GetGameActionLogs retrieves all action logs for a specific game
 func GetGameActionLogs(gameID string) ([]GameActionLog, error) {
+	actionLogs.flush(gameID)
 	query := `
 		SELECT id, game_id, action_type, player_seat, player_id, action_data, result_data, timestamp
 		FROM game_action_logs
 		WHERE game_id = $1
-		ORDER BY timestamp ASC
+		ORDER BY timestamp ASC, id ASC
 	`
 
 	rows, err := db.Query(query, gameID)
@@ -123,7 +288,7 @@ func GetGameActionLogs(gameID string) ([]GameActionLog, error) {
 }
 
 // CreateGameReplay creates a replay record for a completed game
-func CreateGameReplay(gameID string, initialState, finalState interface{}, totalActions, durationSeconds int, winnerTeam string, finalScore int) error {
+func CreateGameReplay(gameID string, initialState, finalState interface{}, totalActions, durationSeconds int, winnerTeam string, finalScore int, facets ReplayFacets) error {
 	initialStateJSON, err := json.Marshal(initialState)
 	if err != nil {
 		return fmt.Errorf("failed to marshal initial state: %w", err)
@@ -135,17 +300,25 @@ func CreateGameReplay(gameID string, initialState, finalState interface{}, total
 	}
 
 	query := `
-		INSERT INTO game_replays (game_id, initial_state, final_state, total_actions, duration_seconds, winner_team, final_score)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO game_replays (game_id, initial_state, final_state, total_actions, duration_seconds, winner_team, final_score,
+			dealer_seat, dealer_id, level, solo_mode, bottom_taken, bottom_points)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11, $12, $13)
 		ON CONFLICT (game_id) DO UPDATE SET
 			final_state = EXCLUDED.final_state,
 			total_actions = EXCLUDED.total_actions,
 			duration_seconds = EXCLUDED.duration_seconds,
 			winner_team = EXCLUDED.winner_team,
-			final_score = EXCLUDED.final_score
+			final_score = EXCLUDED.final_score,
+			dealer_seat = EXCLUDED.dealer_seat,
+			dealer_id = EXCLUDED.dealer_id,
+			level = EXCLUDED.level,
+			solo_mode = EXCLUDED.solo_mode,
+			bottom_taken = EXCLUDED.bottom_taken,
+			bottom_points = EXCLUDED.bottom_points
 	`
 
-	_, err = db.Exec(query, gameID, initialStateJSON, finalStateJSON, totalActions, durationSeconds, winnerTeam, finalScore)
+	_, err = db.Exec(query, gameID, initialStateJSON, finalStateJSON, totalActions, durationSeconds, winnerTeam, finalScore,
+		facets.DealerSeat, facets.DealerID, facets.Level, facets.SoloMode, facets.BottomTaken, facets.BottomPoints)
 	if err != nil {
 		return fmt.Errorf("failed to insert/update game replay: %w", err)
 	}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// cardSuits maps the first letter of a test card to its suit
+var cardSuits = map[byte]string{'S': "spades", 'H': "hearts", 'D': "diamonds", 'C': "clubs"}
+
+// testCards builds cards from short names: "SK" is the king of spades, "H10" the ten
+// of hearts, "big" and "small" are the jokers
+func testCards(names string) []Card {
+	var cards []Card
+	for _, name := range strings.Fields(names) {
+		switch name {
+		case "big", "small":
+			cards = append(cards, Card{Suit: "joker", Value: name, Type: "joker"})
+		default:
+			cards = append(cards, Card{Suit: cardSuits[name[0]], Value: name[1:], Type: "normal"})
+		}
+	}
+	return cards
+}
+
+func TestPlayShape(t *testing.T) {
+	tests := []struct {
+		name  string
+		cards string
+		want  string
+	}{
+		{"single", "SK", "single:1"},
+		{"single joker", "big", "single:1"},
+		{"pair", "S9 S9", "pair:2"},
+		{"pair of level cards", "D2 D2", "pair:2"},
+		{"pair of big jokers", "big big", "pair:2"},
+		{"big and small joker", "big small", "throw:1,1"},
+		{"same value different suits", "S9 H9", "throw:1,1"},
+		{"triple", "C5 C5 C5", "triple:3"},
+		{"tractor of pairs", "S7 S7 S8 S8", "tractor:2,2"},
+		{"tractor unordered", "S8 S7 S8 S7", "tractor:2,2"},
+		{"tractor of three pairs", "HQ HQ HK HK HA HA", "tractor:2,2,2"},
+		{"tractor of triples", "D3 D3 D3 D4 D4 D4", "tractor:3,3"},
+		{"pairs not consecutive", "S9 S9 SJ SJ", "throw:2,2"},
+		{"pairs of different suits", "S7 S7 H8 H8", "throw:2,2"},
+		{"pair and triple", "S7 S7 S8 S8 S8", "throw:3,2"},
+		{"throw of singles", "SA SK SQ", "throw:1,1,1"},
+		{"throw with a pair", "SA SA SK", "throw:2,1"},
+		{"throw with a pair first", "SK SA SA SQ", "throw:2,1,1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := playShape(testCards(tt.cards)); got != tt.want {
+				t.Errorf("playShape(%s) = %s, want %s", tt.cards, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCardPlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		lead    string // empty when leading
+		hand    string // the current player's hand, played cards included
+		rank    string // the level, 2 if empty
+		cards   string
+		wantErr string // "" if the play is legal
+	}{
+		// 首家出牌
+		{name: "lead nothing", cards: "", wantErr: "no cards to play"},
+		{name: "lead single", cards: "SK"},
+		{name: "lead joker", cards: "big"},
+		{name: "lead pair", cards: "S9 S9"},
+		{name: "lead joker pair", cards: "small small"},
+		{name: "lead triple", cards: "C5 C5 C5"},
+		{name: "lead tractor", cards: "S7 S7 S8 S8"},
+		{name: "lead tractor of triples", cards: "D3 D3 D3 D4 D4 D4"},
+		{name: "lead tractor skipping the level", rank: "5", cards: "S4 S4 S6 S6"},
+		{name: "lead throw", cards: "SA SK SQ"},
+		{name: "lead throw with a pair", cards: "SA SA SK"},
+		{name: "lead pair of two suits", cards: "S9 H9", wantErr: "all cards must have the same suit"},
+		{name: "lead throw of two suits", cards: "SA HA SK", wantErr: "all cards must have the same suit"},
+		{name: "lead tractor of two suits", cards: "S7 S7 H8 H8", wantErr: "all cards must have the same suit"},
+
+		// 跟牌：张数
+		{name: "follow single with two", lead: "SK", hand: "SA SQ", cards: "SA SQ", wantErr: "must play 1 cards"},
+		{name: "follow pair with one", lead: "S9 S9", hand: "SA SQ", cards: "SA", wantErr: "must play 2 cards"},
+
+		// 跟牌：单张
+		{name: "follow single", lead: "SK", hand: "SA H3", cards: "SA"},
+		{name: "follow single with trump", lead: "SK", hand: "H3 D4", cards: "H3"},
+		{name: "follow single with joker", lead: "SK", hand: "big D4", cards: "big"},
+		{name: "follow single discard", lead: "SK", hand: "D4 C5", cards: "D4"},
+
+		// 跟牌：对子
+		{name: "follow pair with pair", lead: "S9 S9", hand: "SA SA SQ", cards: "SA SA"},
+		{name: "follow pair breaking a pair", lead: "S9 S9", hand: "SA SA SQ", cards: "SA SQ", wantErr: "有相同花色的对子，必须跟对子"},
+		{name: "follow pair without a pair", lead: "S9 S9", hand: "SA SQ SJ", cards: "SA SQ"},
+		{name: "follow pair with suit and other", lead: "S9 S9", hand: "SA SQ D4", cards: "SA D4", wantErr: "must follow suit if possible"},
+		{name: "follow pair short suit", lead: "S9 S9", hand: "SA D4 C5", cards: "SA D4"},
+		{name: "follow pair with trump pair", lead: "S9 S9", hand: "H3 H3 D4", cards: "H3 H3"},
+		{name: "follow pair with joker pair", lead: "S9 S9", hand: "big big D4", cards: "big big"},
+		{name: "follow pair with trump singles", lead: "S9 S9", hand: "H3 H4 D4", cards: "H3 H4"},
+		{name: "follow pair discard", lead: "S9 S9", hand: "D4 C5 C6", cards: "D4 C5"},
+
+		// 跟牌：三张
+		{name: "follow triple with triple", lead: "C5 C5 C5", hand: "C9 C9 C9 D4", cards: "C9 C9 C9"},
+		{name: "follow triple breaking a pair", lead: "C5 C5 C5", hand: "C9 C9 CJ CQ", cards: "C9 CJ CQ", wantErr: "有相同花色的对子，必须跟对子"},
+		{name: "follow triple with trump triple", lead: "C5 C5 C5", hand: "H6 H6 H6", cards: "H6 H6 H6"},
+
+		// 跟牌：拖拉机
+		{name: "follow tractor with tractor", lead: "S7 S7 S8 S8", hand: "SJ SJ SQ SQ D4", cards: "SJ SJ SQ SQ"},
+		{name: "follow tractor with suit and other", lead: "S7 S7 S8 S8", hand: "SJ SQ SK SA D4", cards: "SJ SQ SK D4", wantErr: "must follow suit if possible"},
+		{name: "follow tractor short suit", lead: "S7 S7 S8 S8", hand: "SJ SQ D4 D5 C6", cards: "SJ SQ D4 D5"},
+		{name: "follow tractor with trump tractor", lead: "S7 S7 S8 S8", hand: "H5 H5 H6 H6", cards: "H5 H5 H6 H6"},
+
+		// 跟牌：甩牌
+		{name: "follow throw with suit", lead: "SA SK SQ", hand: "S3 S4 S5 D6", cards: "S3 S4 S5"},
+		{name: "follow throw with suit and other", lead: "SA SK SQ", hand: "S3 S4 S5 D6", cards: "S3 S4 D6", wantErr: "must follow suit if possible"},
+		{name: "follow throw with trump", lead: "SA SK SQ", hand: "H3 H5 big D6", cards: "H3 H5 big"},
+		{name: "follow throw discard", lead: "SA SK SQ", hand: "D6 D7 C8", cards: "D6 D7 C8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 默认打2，红桃是主
+			rank := tt.rank
+			if rank == "" {
+				rank = "2"
+			}
+			table := &GameTable{
+				TrumpSuit:     "hearts",
+				TrumpRank:     rank,
+				CurrentPlayer: 2,
+				PlayerHands:   map[int]*PlayerHand{2: {SeatNumber: 2, Cards: testCards(tt.hand)}},
+			}
+			if tt.lead != "" {
+				table.TrickPlays = []TrickPlay{{Seat: 1, Cards: testCards(tt.lead), IsLead: true}}
+			}
+			err := validateCardPlay(testCards(tt.cards), table)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateCardPlay(%s) = %v, want legal", tt.cards, err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("validateCardPlay(%s) is legal, want %q", tt.cards, tt.wantErr)
+			case tt.wantErr != "" && err.Error() != tt.wantErr:
+				t.Errorf("validateCardPlay(%s) = %v, want %q", tt.cards, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDetermineTrickWinner(t *testing.T) {
+	tests := []struct {
+		name  string
+		plays []string // seat 1 leads, then seats 2..5
+		want  int
+	}{
+		{"single higher", []string{"SK", "SA", "S3", "S4", "S5"}, 2},
+		{"single tie goes to first", []string{"SK", "S3", "SK", "S4", "S5"}, 1},
+		{"single discard loses", []string{"SK", "DA", "CA", "S4", "S5"}, 1},
+		{"single trump kills", []string{"SA", "S3", "H3", "S4", "S5"}, 3},
+		{"single higher trump", []string{"SA", "H3", "HA", "H4", "S5"}, 3},
+		{"single level card over trump ace", []string{"SA", "HA", "S4", "D2", "S5"}, 4},
+		{"single trump level over side level", []string{"SA", "D2", "H2", "S4", "S5"}, 3},
+		{"single small joker over level", []string{"SA", "H2", "small", "S4", "S5"}, 3},
+		{"single big joker", []string{"SA", "small", "S3", "big", "S5"}, 4},
+		{"single trump lead", []string{"H5", "H6", "S3", "big", "D2"}, 4},
+
+		{"pair higher", []string{"S9 S9", "SA SA", "S3 S4", "D4 D5", "S5 S6"}, 2},
+		{"pair beaten only by a pair", []string{"S9 S9", "SA SK", "H3 H4", "D4 D5", "S5 S6"}, 1},
+		{"pair trump kills", []string{"S9 S9", "SA SA", "H3 H3", "D4 D5", "S5 S6"}, 3},
+		{"pair joker pair over trump pair", []string{"S9 S9", "big big", "H3 H3", "D4 D5", "S5 S6"}, 2},
+		{"pair big and small jokers are not a pair", []string{"S9 S9", "big small", "S3 S4", "D4 D5", "S5 S6"}, 1},
+		{"pair mixed trump loses", []string{"S9 S9", "H3 S3", "S4 S5", "D4 D5", "S6 S7"}, 1},
+
+		{"triple higher", []string{"C5 C5 C5", "C9 C9 C9", "C3 C3 C4", "D4 D5 D6", "S5 S6 S7"}, 2},
+		{"triple not beaten by pair and one", []string{"C5 C5 C5", "CA CA CK", "C3 C4 C6", "D4 D5 D6", "S5 S6 S7"}, 1},
+		{"triple trump kills", []string{"C5 C5 C5", "C9 C9 C9", "H6 H6 H6", "D4 D5 D6", "S5 S6 S7"}, 3},
+
+		{"tractor higher", []string{"S7 S7 S8 S8", "S9 S9 S10 S10", "S3 S3 S4 S5", "D4 D5 D6 D7", "C5 C6 C7 C8"}, 2},
+		{"tractor not beaten by two pairs", []string{"S7 S7 S8 S8", "SQ SQ SA SA", "S3 S3 S4 S5", "D4 D5 D6 D7", "C5 C6 C7 C8"}, 1},
+		{"tractor trump kills", []string{"S7 S7 S8 S8", "S9 S9 S10 S10", "H5 H5 H6 H6", "D4 D5 D6 D7", "C5 C6 C7 C8"}, 3},
+		{"tractor trump higher", []string{"S7 S7 S8 S8", "HJ HJ HQ HQ", "H5 H5 H6 H6", "D4 D5 D6 D7", "C5 C6 C7 C8"}, 2},
+
+		{"throw stands", []string{"SA SK", "SQ SJ", "S3 S4", "D4 D5", "C5 C6"}, 1},
+		{"throw trump kills", []string{"SA SK", "SQ SJ", "H3 H4", "D4 D5", "C5 C6"}, 3},
+		{"throw trump decided by top card", []string{"SA SK", "H3 big", "HA HK", "D4 D5", "C5 C6"}, 2},
+		{"throw with pair trump kills", []string{"SA SA SK", "H5 H5 H3", "S3 S4 S5", "D4 D5 D6", "C5 C6 C7"}, 2},
+		{"throw with pair needs a pair", []string{"SA SA SK", "H5 H3 H4", "S3 S4 S5", "D4 D5 D6", "C5 C6 C7"}, 1},
+		{"throw decided by the pair", []string{"SA SA SK", "H5 H5 H3", "H6 H6 H4", "HA HK HK", "C5 C6 C7"}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plays := make([]TrickPlay, len(tt.plays))
+			for i, cards := range tt.plays {
+				plays[i] = TrickPlay{Seat: i + 1, Cards: testCards(cards), IsLead: i == 0}
+			}
+			if got := determineTrickWinner(plays, "hearts", "2"); got != tt.want {
+				t.Errorf("winner = seat %d, want seat %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestValidateThrowCardsKeepsSmallest checks that a failed throw leaves the
+// smallest group of the kept type on the table (RULE.md 甩牌验证流程)
+func TestValidateThrowCardsKeepsSmallest(t *testing.T) {
+	tests := []struct {
+		name  string
+		throw string
+		other string // seat 5's hand
+		want  string
+	}{
+		{"singles", "SK SQ S9 S6", "SA", "S6"},
+		{"pair kept", "SK SQ S9 S9 S6 S6", "SA", "S6 S6"},
+		{"smallest single", "S6 SK SJ", "SA SA", "S6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &GameTable{TrumpSuit: "hearts", TrumpRank: "2", PlayerHands: map[int]*PlayerHand{
+				1: {Cards: testCards(tt.throw)},
+				5: {Cards: testCards(tt.other)},
+			}}
+			result := ValidateThrowCards(testCards(tt.throw), table, 1)
+			if result.IsValid {
+				t.Fatalf("throw %s accepted, want it refused", tt.throw)
+			}
+			if got := strings.Join(CardCodes(result.ActualPlay), " "); got != tt.want {
+				t.Errorf("kept %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
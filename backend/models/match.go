@@ -0,0 +1,387 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Match is a series of hands played by the same five seats (RULE.md §8)
+// Every hand is its own game (own seed, logs and replay). Levels are kept per
+// player and carried from hand to hand; the match ends when a player wins a hand
+// while at level A.
+type Match struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	HostID        string            `json:"hostId"`
+	Status        string            `json:"status"`    // waiting, playing, finished
+	PlayerIDs     []string          `json:"playerIds"` // 座位顺序，第一局开始时固定
+	Levels        map[string]string `json:"levels"`    // 玩家 -> 当前等级（各自记级）
+	ReachedA      map[string]int    `json:"reachedA"`  // 玩家 -> 升到A的局数（多人到A时先到者胜）
+	HandNumber    int               `json:"handNumber"`
+	CurrentGameID string            `json:"currentGameId"`
+	Hands         []MatchHand       `json:"hands"`
+	ChampionID    string            `json:"championId,omitempty"`
+	SinglePlayer  bool              `json:"singlePlayer"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+
+	// AISettings 各座位 AI 的难度和思考时间（座位号 -> 设置），每局发牌时带到牌桌上
+	AISettings map[int]AISettings `json:"aiSettings,omitempty"`
+
+	// 固定种子时第n局用 baseSeed+n-1，保证整场比赛可复现
+	baseSeed *int64
+}
+
+// MatchHand is the summary of one finished hand of a match
+type MatchHand struct {
+	HandNumber         int          `json:"handNumber"`
+	GameID             string       `json:"gameId"`
+	StartingDealer     int          `json:"startingDealer"`
+	DealerSeat         int          `json:"dealerSeat"`
+	FriendSeat         int          `json:"friendSeat"` // 0 for 1v4
+	TrumpRank          string       `json:"trumpRank"`
+	WinnerTeam         string       `json:"winnerTeam"`
+	Score              int          `json:"score"`
+	Results            []GameResult `json:"results"`
+	NextStartingDealer int          `json:"nextStartingDealer,omitempty"` // 0 if the match ended
+}
+
+// createMatch creates an empty match; its first hand is created right after
+func createMatch(name, hostID string, seed *int64, singlePlayer bool) (*Match, error) {
+	now := time.Now()
+	m := &Match{
+		ID:           generateID(),
+		Name:         name,
+		HostID:       hostID,
+		Status:       "waiting",
+		PlayerIDs:    make([]string, 0, 5),
+		Levels:       make(map[string]string),
+		ReachedA:     make(map[string]int),
+		HandNumber:   1,
+		Hands:        make([]MatchHand, 0),
+		SinglePlayer: singlePlayer,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		baseSeed:     seed,
+	}
+
+	stateJSON, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal match state: %w", err)
+	}
+
+	query := `INSERT INTO matches (id, host_id, status, single_player, base_seed, state) VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := db.Exec(query, m.ID, hostID, m.Status, singlePlayer, seed, stateJSON); err != nil {
+		return nil, fmt.Errorf("failed to create match: %w", err)
+	}
+	return m, nil
+}
+
+// GetMatch retrieves a match by ID
+func GetMatch(id string) (*Match, error) {
+	var stateJSON []byte
+	var baseSeed sql.NullInt64
+	err := db.QueryRow(`SELECT state, base_seed FROM matches WHERE id = $1`, id).Scan(&stateJSON, &baseSeed)
+	if err == sql.ErrNoRows {
+		return nil, ErrMatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query match: %w", err)
+	}
+
+	m := &Match{}
+	if err := json.Unmarshal(stateJSON, m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal match state: %w", err)
+	}
+	if m.Levels == nil {
+		m.Levels = make(map[string]string)
+	}
+	if m.ReachedA == nil {
+		m.ReachedA = make(map[string]int)
+	}
+	if baseSeed.Valid {
+		seed := baseSeed.Int64
+		m.baseSeed = &seed
+	}
+	return m, nil
+}
+
+// saveMatch writes the match state back
+func saveMatch(m *Match) error {
+	m.UpdatedAt = time.Now()
+	stateJSON, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal match state: %w", err)
+	}
+
+	var championID interface{}
+	if m.ChampionID != "" {
+		championID = m.ChampionID
+	}
+
+	query := `UPDATE matches SET status = $1, champion_id = $2, state = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4`
+	if _, err := db.Exec(query, m.Status, championID, stateJSON, m.ID); err != nil {
+		return fmt.Errorf("failed to save match: %w", err)
+	}
+	return nil
+}
+
+// handSeed returns the seed for the match's current hand (nil draws a random one)
+func (m *Match) handSeed() *int64 {
+	if m.baseSeed == nil {
+		return nil
+	}
+	seed := *m.baseSeed + int64(m.HandNumber-1)
+	return &seed
+}
+
+// seatLevels returns each seat's level for the hand about to be dealt (index 0 is seat 1)
+// Hands of a match use the levels carried by the match; the first hand fixes the
+// seats and everyone starts at 2. Games outside a match use the profile levels.
+func seatLevels(game *GameState) ([]string, error) {
+	levels := make([]string, len(game.PlayerIDs))
+
+	if game.MatchID == "" {
+		for i, playerID := range game.PlayerIDs {
+			levels[i] = "2"
+			if user, err := GetUserByID(playerID); err == nil && user.Level != "" {
+				levels[i] = user.Level
+			}
+		}
+		return levels, nil
+	}
+
+	m, err := GetMatch(game.MatchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.PlayerIDs) == 0 {
+		m.PlayerIDs = append([]string(nil), game.PlayerIDs...)
+		for _, playerID := range m.PlayerIDs {
+			m.Levels[playerID] = "2"
+		}
+		m.Status = "playing"
+		if err := saveMatch(m); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, playerID := range game.PlayerIDs {
+		level, ok := m.Levels[playerID]
+		if !ok {
+			return nil, fmt.Errorf("player %s is not in match %s", playerID, m.ID)
+		}
+		levels[i] = level
+	}
+	return levels, nil
+}
+
+// seatAISettings returns the per-seat AI settings of the game's match
+// Games outside a match (or a match that cannot be read) use the defaults.
+func seatAISettings(game *GameState) map[int]AISettings {
+	if game.MatchID == "" {
+		return nil
+	}
+	m, err := GetMatch(game.MatchID)
+	if err != nil {
+		return nil
+	}
+	return m.AISettings
+}
+
+// recordHand applies a finished hand to the match: carries the new levels, checks
+// for a champion and picks the next starting dealer. Returns 0 once the match is over.
+func (m *Match) recordHand(table *GameTable, totalPoints int, winnerTeam string, results []GameResult) int {
+	summary := MatchHand{
+		HandNumber:     m.HandNumber,
+		GameID:         table.GameID,
+		StartingDealer: table.StartingDealerSeat,
+		DealerSeat:     table.DealerSeat,
+		TrumpRank:      table.TrumpRank,
+		WinnerTeam:     winnerTeam,
+		Score:          totalPoints,
+		Results:        results,
+	}
+	if table.FriendRevealed {
+		summary.FriendSeat = table.FriendSeat
+	}
+
+	// 在A级赢下本局的玩家才能夺冠
+	var winnersAtA []string
+	for _, r := range results {
+		if r.IsWinner && r.OldLevel == "A" {
+			winnersAtA = append(winnersAtA, r.UserID)
+		}
+		m.Levels[r.UserID] = r.NewLevel
+		if r.NewLevel == "A" && r.OldLevel != "A" {
+			m.ReachedA[r.UserID] = m.HandNumber
+		}
+	}
+
+	if champion := m.pickChampion(table, winnersAtA); champion != "" {
+		m.ChampionID = champion
+		m.Status = "finished"
+		m.Hands = append(m.Hands, summary)
+		return 0
+	}
+
+	summary.NextStartingDealer = nextStartingDealer(table, winnerTeam)
+	m.Hands = append(m.Hands, summary)
+	return summary.NextStartingDealer
+}
+
+// pickChampion chooses among the players who won at A: whoever reached A first,
+// then the one closest to the dealer going counter-clockwise (the dealer first)
+func (m *Match) pickChampion(table *GameTable, candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	earliest := -1
+	for _, playerID := range candidates {
+		if hand := m.ReachedA[playerID]; earliest < 0 || hand < earliest {
+			earliest = hand
+		}
+	}
+
+	var seats []int
+	for _, playerID := range candidates {
+		if m.ReachedA[playerID] == earliest {
+			seats = append(seats, table.SeatOf(playerID))
+		}
+	}
+	seat := findClosestSeatCounterClockwise(table.DealerSeat, seats)
+	return table.PlayerHands[seat].UserID
+}
+
+// nextStartingDealer picks the next hand's starting dealer (RULE.md §2.2)
+// 庄家赢（1V4）：庄家；庄家赢（2V3）：庄家的朋友；庄家输：逆时针最靠近庄家的抓分方玩家
+func nextStartingDealer(table *GameTable, winnerTeam string) int {
+	if winnerTeam == "host" {
+		if table.FriendRevealed && table.FriendSeat > 0 && table.FriendSeat != table.DealerSeat {
+			return table.FriendSeat
+		}
+		return table.DealerSeat
+	}
+	// 抓分方中逆时针（1->5->4->3->2->1）离庄家最近的一位，庄家的朋友不算
+	var defenders []int
+	for seat := 1; seat <= 5; seat++ {
+		if _, ok := table.PlayerHands[seat]; !ok || seat == table.DealerSeat {
+			continue
+		}
+		if table.FriendRevealed && seat == table.FriendSeat {
+			continue
+		}
+		defenders = append(defenders, seat)
+	}
+	return findClosestSeatCounterClockwise(table.DealerSeat, defenders)
+}
+
+// advanceMatch records a finished hand on its match and deals the next hand,
+// unless someone has just won at A. It runs once the finished hand's table is
+// unlocked (see recordFinishedHand); the next hand is a new game with its own lock.
+func advanceMatch(game *GameState, table *GameTable, totalPoints int, winnerTeam string, results []GameResult) (*Match, error) {
+	m, err := GetMatch(game.MatchID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Status == "finished" || m.CurrentGameID != game.ID {
+		// Already advanced past this hand
+		return m, nil
+	}
+
+	nextDealer := m.recordHand(table, totalPoints, winnerTeam, results)
+
+	if m.Status == "finished" {
+		if err := saveMatch(m); err != nil {
+			return nil, err
+		}
+
+		LogGameAction(GameActionLogRequest{
+			GameID:     table.GameID,
+			ActionType: "match_end",
+			PlayerSeat: table.SeatOf(m.ChampionID),
+			PlayerID:   m.ChampionID,
+			ActionData: map[string]interface{}{
+				"match_id":    m.ID,
+				"hand_number": m.HandNumber,
+			},
+			ResultData: map[string]interface{}{
+				"champion_id": m.ChampionID,
+				"levels":      m.Levels,
+			},
+		})
+
+		publishGameEvent(newTableEvent(table, "match_end", table.SeatOf(m.ChampionID), map[string]interface{}{
+			"matchId":    m.ID,
+			"handNumber": m.HandNumber,
+			"championId": m.ChampionID,
+			"levels":     m.Levels,
+		}))
+		return m, nil
+	}
+
+	// 下一局：同样的座位，新的种子
+	m.HandNumber++
+	nextGameID, err := createHandGame(m)
+	if err != nil {
+		return nil, err
+	}
+	m.CurrentGameID = nextGameID
+	if err := saveMatch(m); err != nil {
+		return nil, err
+	}
+
+	nextGame, err := GetGame(nextGameID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dealHand(nextGame, m.SinglePlayer, nextDealer); err != nil {
+		return nil, err
+	}
+
+	LogGameAction(GameActionLogRequest{
+		GameID:     table.GameID,
+		ActionType: "next_hand",
+		PlayerSeat: nextDealer,
+		PlayerID:   table.PlayerHands[nextDealer].UserID,
+		ActionData: map[string]interface{}{
+			"match_id":        m.ID,
+			"hand_number":     m.HandNumber,
+			"starting_dealer": nextDealer,
+		},
+		ResultData: map[string]interface{}{
+			"next_game_id": nextGameID,
+			"levels":       m.Levels,
+		},
+	})
+
+	publishGameEvent(newTableEvent(table, "next_hand", nextDealer, map[string]interface{}{
+		"matchId":        m.ID,
+		"handNumber":     m.HandNumber,
+		"nextGameId":     nextGameID,
+		"startingDealer": nextDealer,
+		"levels":         m.Levels,
+	}))
+
+	return m, nil
+}
+
+// createHandGame creates the game row for the match's current hand with the
+// match's seats; the hand is dealt right away by dealHand
+func createHandGame(m *Match) (string, error) {
+	gameID, err := insertGame(m.Name, m.HostID, m.handSeed(), m.ID, m.HandNumber)
+	if err != nil {
+		return "", err
+	}
+
+	for i, playerID := range m.PlayerIDs {
+		if _, err := db.Exec(`INSERT INTO game_players (game_id, user_id, seat_number) VALUES ($1, $2, $3)`, gameID, playerID, i+1); err != nil {
+			return "", fmt.Errorf("failed to seat player %s: %w", playerID, err)
+		}
+	}
+	return gameID, nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNextStartingDealer(t *testing.T) {
+	tests := []struct {
+		name       string
+		dealer     int
+		friend     int // 0 if the friend never showed
+		winnerTeam string
+		want       int
+	}{
+		{"dealer wins alone", 3, 0, "host", 3},
+		{"dealer wins with the friend", 3, 1, "host", 1},
+		{"dealer wins calling an own card", 3, 3, "host", 3},
+		{"dealer loses alone", 3, 0, "guest", 2},
+		{"dealer loses from seat 1", 1, 0, "guest", 5},
+		{"dealer loses, friend next counter-clockwise", 1, 5, "guest", 4},
+		{"dealer loses, friend elsewhere", 1, 3, "guest", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &GameTable{DealerSeat: tt.dealer, FriendSeat: tt.friend, FriendRevealed: tt.friend > 0}
+			table.PlayerHands = make(map[int]*PlayerHand)
+			for seat := 1; seat <= 5; seat++ {
+				table.PlayerHands[seat] = &PlayerHand{UserID: fmt.Sprintf("player_%d", seat), SeatNumber: seat}
+			}
+			if got := nextStartingDealer(table, tt.winnerTeam); got != tt.want {
+				t.Errorf("next starting dealer = seat %d, want seat %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,792 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Game records: a portable JSON file holding one hand of 升级 (README "对局记录").
+// A record is exported from the action log of a finished game and can be
+// imported on any server. An import is played through the same commands as a
+// live table (callDealer, discardBottomCards, playCardsGame, ...), so a record
+// the rules refuse is rejected. The actions logged while it is played are
+// stored as the imported game's log, which makes it replayable like any other game.
+//
+// Cards are written as suit letter + value: S spades, H hearts, C clubs,
+// D diamonds (e.g. "S10", "HA", "D2"), with "LJ" for the small joker (小王)
+// and "BJ" for the big joker (大王).
+
+// RecordFormat and RecordVersion identify the record format
+const (
+	RecordFormat  = "leve_up/game-record"
+	RecordVersion = 1
+)
+
+// GameRecord is one hand from the deal to the result
+type GameRecord struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	GameID   string    `json:"gameId,omitempty"` // game the record was exported from
+	Name     string    `json:"name,omitempty"`
+	PlayedAt time.Time `json:"playedAt"`
+
+	Seed           *int64         `json:"seed,omitempty"` // the deal must match it when given
+	SeedHash       string         `json:"seedHash,omitempty"`
+	Level          string         `json:"level"` // 本局级牌
+	StartingDealer int            `json:"startingDealer"`
+	Players        []RecordPlayer `json:"players"` // seats 1-5 with the hands as dealt
+	Bottom         []string       `json:"bottom"`  // 底牌，as dealt
+
+	Bids     []RecordBid   `json:"bids,omitempty"`  // 叫庄、反庄 in order
+	Flips    []string      `json:"flips,omitempty"` // 无人叫庄时翻开的底牌
+	Dealer   int           `json:"dealer"`
+	Trump    RecordTrump   `json:"trump"`
+	Discards []string      `json:"discards"` // 庄家扣的7张底牌
+	Friend   *RecordFriend `json:"friend"`
+	Tricks   []RecordTrick `json:"tricks"`
+	Result   RecordResult  `json:"result"`
+}
+
+// RecordPlayer is one seat
+type RecordPlayer struct {
+	Seat  int      `json:"seat"`
+	ID    string   `json:"id"`
+	Name  string   `json:"name,omitempty"`
+	Level string   `json:"level"` // the level the seat played the hand at
+	Hand  []string `json:"hand"`
+}
+
+// RecordBid is a call for the dealer: the level cards shown
+type RecordBid struct {
+	Seat  int      `json:"seat"`
+	Suit  string   `json:"suit"`
+	Cards []string `json:"cards"`
+}
+
+// RecordTrump is the trump of the hand
+type RecordTrump struct {
+	Suit string `json:"suit"`
+	Rank string `json:"rank"`
+}
+
+// RecordFriend is the card the dealer called (第Position张出现的Card是朋友)
+type RecordFriend struct {
+	Card     string `json:"card"`
+	Position int    `json:"position"`
+}
+
+// RecordTrick is one trick; the first play is the lead
+type RecordTrick struct {
+	Plays  []RecordPlay `json:"plays"`
+	Winner int          `json:"winner"` // checked on import when set
+}
+
+// RecordPlay is the cards one seat played to a trick
+type RecordPlay struct {
+	Seat  int      `json:"seat"`
+	Cards []string `json:"cards"`
+}
+
+// RecordResult is how the hand ended; checked on import when WinnerTeam is set
+type RecordResult struct {
+	Points     int           `json:"points"`     // 抓分方得分（含底牌）
+	WinnerTeam string        `json:"winnerTeam"` // host or guest
+	FriendSeat int           `json:"friendSeat"` // 0 if nobody was the friend
+	SoloMode   bool          `json:"soloMode"`   // 1打4
+	Levels     []RecordLevel `json:"levels"`
+}
+
+// RecordLevel is one seat's level change
+type RecordLevel struct {
+	Seat   int    `json:"seat"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Winner bool   `json:"winner"`
+}
+
+var cardSuitCodes = map[string]string{"spades": "S", "hearts": "H", "clubs": "C", "diamonds": "D"}
+
+// CardCode writes a card in record notation, e.g. "H10" or "BJ"
+func CardCode(card Card) string {
+	if card.Suit == "joker" {
+		if card.Value == "big" {
+			return "BJ"
+		}
+		return "LJ"
+	}
+	return cardSuitCodes[card.Suit] + card.Value
+}
+
+// ParseCard reads a card in record notation
+func ParseCard(code string) (Card, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	switch code {
+	case "BJ":
+		return Card{Suit: "joker", Value: "big", Type: "joker"}, nil
+	case "LJ":
+		return Card{Suit: "joker", Value: "small", Type: "joker"}, nil
+	}
+	if len(code) >= 2 {
+		for suit, letter := range cardSuitCodes {
+			if code[:1] == letter && isRecordValue(code[1:]) {
+				return Card{Suit: suit, Value: code[1:], Type: "normal"}, nil
+			}
+		}
+	}
+	return Card{}, fmt.Errorf("invalid card %q", code)
+}
+
+func isRecordValue(value string) bool {
+	for _, v := range LevelOrder {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CardCodes writes cards in record notation
+func CardCodes(cards []Card) []string {
+	codes := make([]string, len(cards))
+	for i, card := range cards {
+		codes[i] = CardCode(card)
+	}
+	return codes
+}
+
+// ParseCards reads cards in record notation
+func ParseCards(codes []string) ([]Card, error) {
+	cards := make([]Card, len(codes))
+	for i, code := range codes {
+		card, err := ParseCard(code)
+		if err != nil {
+			return nil, err
+		}
+		cards[i] = card
+	}
+	return cards, nil
+}
+
+// RecordFromActions turns the action log of a finished game into a record
+// Names are left empty; see ExportGameRecord.
+func RecordFromActions(actions []GameActionLog) (*GameRecord, error) {
+	record := &GameRecord{Format: RecordFormat, Version: RecordVersion}
+
+	var table *GameTable
+	var trick *RecordTrick
+	for i, action := range actions {
+		next, err := Apply(table, action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d (%s): %w", i+1, action.ActionType, err)
+		}
+
+		switch action.ActionType {
+		case "game_start":
+			if table != nil {
+				return nil, fmt.Errorf("action %d: game started twice", i+1)
+			}
+			var data struct {
+				gameStartAction
+				Seed     int64  `json:"seed"`
+				SeedHash string `json:"seed_hash"`
+			}
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			record.GameID = action.GameID
+			record.PlayedAt = action.Timestamp
+			record.Level = data.CurrentLevel
+			record.StartingDealer = data.StartingDealer
+			// 没有种子的旧对局记的是0
+			if data.SeedHash != "" && SeedHash(data.Seed) == data.SeedHash {
+				seed := data.Seed
+				record.Seed = &seed
+				record.SeedHash = data.SeedHash
+			}
+			for seat := 1; seat <= len(data.PlayerIDs); seat++ {
+				record.Players = append(record.Players, RecordPlayer{
+					Seat:  seat,
+					ID:    data.PlayerIDs[seat],
+					Level: data.PlayerLevels[seat],
+					Hand:  CardCodes(data.Hands[seat]),
+				})
+			}
+			record.Bottom = CardCodes(data.BottomCards)
+
+		case "call_dealer":
+			var data struct {
+				callDealerAction
+				Cards []Card `json:"cards"`
+			}
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			if !data.Auto {
+				record.Bids = append(record.Bids, RecordBid{Seat: action.PlayerSeat, Suit: data.Suit, Cards: CardCodes(data.Cards)})
+			}
+
+		case "flip_bottom":
+			var data flipBottomAction
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			record.Flips = append(record.Flips, CardCode(data.Card))
+
+		case "discard_bottom":
+			// 扣的牌从扣牌前的庄家手牌里取
+			var data cardIndicesAction
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			hand := table.PlayerHands[table.DealerSeat].Cards
+			for _, idx := range data.CardIndices {
+				record.Discards = append(record.Discards, CardCode(hand[idx]))
+			}
+
+		case "call_friend":
+			var data callFriendAction
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			record.Friend = &RecordFriend{Card: CardCode(Card{Suit: data.Suit, Value: data.Value}), Position: data.Position}
+
+		case "play_cards":
+			var data cardIndicesAction
+			if err := decodeAction(action, &data, nil); err != nil {
+				return nil, err
+			}
+			if trick == nil {
+				record.Tricks = append(record.Tricks, RecordTrick{})
+				trick = &record.Tricks[len(record.Tricks)-1]
+			}
+			trick.Plays = append(trick.Plays, RecordPlay{Seat: action.PlayerSeat, Cards: CardCodes(data.Cards)})
+
+		case "trick_complete":
+			if trick != nil {
+				trick.Winner = next.TrickHistory[len(next.TrickHistory)-1].Winner
+			}
+			trick = nil
+		}
+		table = next
+	}
+
+	if table == nil {
+		return nil, fmt.Errorf("the game never started")
+	}
+	if table.Status != "finished" {
+		return nil, fmt.Errorf("the game is not finished")
+	}
+	record.Dealer = table.DealerSeat
+	record.Trump = RecordTrump{Suit: table.TrumpSuit, Rank: table.TrumpRank}
+	record.Result, _ = tableResult(table)
+	return record, nil
+}
+
+// tableResult scores a finished table, as the record and as the level changes
+func tableResult(table *GameTable) (RecordResult, []GameResult) {
+	lastWinner := table.TrickLeader
+	if n := len(table.TrickHistory); n > 0 {
+		lastWinner = table.TrickHistory[n-1].Winner
+	}
+	points, winnerTeam := handResult(table, lastWinner)
+	results := handResults(table, points, winnerTeam)
+
+	result := RecordResult{
+		Points:     points,
+		WinnerTeam: winnerTeam,
+		SoloMode:   table.IsSoloMode || !table.FriendRevealed,
+		Levels:     make([]RecordLevel, 0, len(results)),
+	}
+	if table.FriendRevealed && !table.IsSoloMode {
+		result.FriendSeat = table.FriendSeat
+	}
+	for _, r := range results {
+		result.Levels = append(result.Levels, RecordLevel{
+			Seat:   table.SeatOf(r.UserID),
+			From:   r.OldLevel,
+			To:     r.NewLevel,
+			Winner: r.IsWinner,
+		})
+	}
+	return result, results
+}
+
+// PlayedRecord is a record played through the rules engine
+type PlayedRecord struct {
+	Table   *GameTable      // the finished table
+	Result  RecordResult    // as the rules score it
+	Results []GameResult    // level changes by player ID
+	Actions []GameActionLog // what the engine logged, game_start to game_end
+}
+
+// recordPlays numbers the tables records are played on
+var recordPlays int64
+
+// PlayGameRecord plays a record through the rules engine, as the commands of a
+// live table, and checks it against what the rules give: the seed (if any), the
+// flips, the dealer and trump, every trick's winner and the result.
+// No database is needed.
+func PlayGameRecord(record *GameRecord) (played *PlayedRecord, err error) {
+	hands, bottom, err := record.deal()
+	if err != nil {
+		return nil, err
+	}
+
+	gameID := fmt.Sprintf("record_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&recordPlays, 1))
+	stop := captureActions(gameID)
+	defer func() {
+		actions := stop()
+		if r := recover(); r != nil {
+			played, err = nil, fmt.Errorf("rules engine failed: %v", r)
+			return
+		}
+		if played != nil {
+			played.Actions = actions
+		}
+	}()
+
+	table, err := playRecord(gameID, record, hands, bottom)
+	if err != nil {
+		return nil, err
+	}
+	result, results := tableResult(table)
+	if err := record.Result.check(result); err != nil {
+		return nil, err
+	}
+	logGameEnd(gameID, results)
+	return &PlayedRecord{Table: table, Result: result, Results: results}, nil
+}
+
+// deal checks the record's header and returns the hands (by seat - 1) and bottom
+func (r *GameRecord) deal() ([][]Card, []Card, error) {
+	if r.Format != RecordFormat {
+		return nil, nil, fmt.Errorf("not a game record (format %q)", r.Format)
+	}
+	if r.Version < 1 || r.Version > RecordVersion {
+		return nil, nil, fmt.Errorf("unsupported record version %d", r.Version)
+	}
+	if !isRecordValue(r.Level) {
+		return nil, nil, fmt.Errorf("invalid level %q", r.Level)
+	}
+	if len(r.Players) != 5 {
+		return nil, nil, fmt.Errorf("a record needs 5 players, got %d", len(r.Players))
+	}
+	if r.StartingDealer < 1 || r.StartingDealer > 5 {
+		return nil, nil, fmt.Errorf("invalid starting dealer %d", r.StartingDealer)
+	}
+
+	hands := make([][]Card, 5)
+	ids := make(map[string]bool, 5)
+	for _, p := range r.Players {
+		if p.Seat < 1 || p.Seat > 5 || hands[p.Seat-1] != nil {
+			return nil, nil, fmt.Errorf("invalid or repeated seat %d", p.Seat)
+		}
+		if p.ID == "" || ids[p.ID] {
+			return nil, nil, fmt.Errorf("seat %d needs its own player id", p.Seat)
+		}
+		ids[p.ID] = true
+		if !isRecordValue(p.Level) {
+			return nil, nil, fmt.Errorf("seat %d: invalid level %q", p.Seat, p.Level)
+		}
+		cards, err := ParseCards(p.Hand)
+		if err != nil {
+			return nil, nil, fmt.Errorf("seat %d hand: %w", p.Seat, err)
+		}
+		if len(cards) != 31 {
+			return nil, nil, fmt.Errorf("seat %d was dealt %d cards, not 31", p.Seat, len(cards))
+		}
+		hands[p.Seat-1] = cards
+	}
+	bottom, err := ParseCards(r.Bottom)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bottom: %w", err)
+	}
+	if len(bottom) != 7 {
+		return nil, nil, fmt.Errorf("the bottom has %d cards, not 7", len(bottom))
+	}
+
+	// 三副牌，每张牌正好三张
+	counts := make(map[string]int)
+	for _, cards := range append(hands, bottom) {
+		for _, card := range cards {
+			counts[CardCode(card)]++
+		}
+	}
+	for code, n := range counts {
+		if n != 3 {
+			return nil, nil, fmt.Errorf("the deal has %d × %s, three decks have 3", n, code)
+		}
+	}
+
+	if r.Seed != nil {
+		if r.SeedHash != "" && SeedHash(*r.Seed) != r.SeedHash {
+			return nil, nil, fmt.Errorf("the seed does not match its hash")
+		}
+		// 起始发牌人不一定来自种子（单人模式、比赛后续局）
+		deal := DealFromSeed(*r.Seed, false)
+		if !sameCards(deal.BottomCards, bottom) {
+			return nil, nil, fmt.Errorf("the deal does not match seed %d", *r.Seed)
+		}
+		for seat, cards := range deal.Hands {
+			if !sameCards(cards, hands[seat-1]) {
+				return nil, nil, fmt.Errorf("the deal does not match seed %d", *r.Seed)
+			}
+		}
+	}
+	return hands, bottom, nil
+}
+
+// playRecord runs the record through the table commands
+func playRecord(gameID string, r *GameRecord, hands [][]Card, bottom []Card) (*GameTable, error) {
+	playerIDs := make([]string, 5)
+	levels := make([]string, 5)
+	for _, p := range r.Players {
+		playerIDs[p.Seat-1] = p.ID
+		levels[p.Seat-1] = p.Level
+	}
+	table := newDealtTable(gameID, playerIDs[0], r.Level, r.StartingDealer, playerIDs, levels, hands, bottom, time.Now())
+	game := &GameState{ID: gameID}
+	if r.Seed != nil {
+		game.DealSeed, game.HasSeed, game.SeedHash = *r.Seed, true, SeedHash(*r.Seed)
+	}
+	logGameStart(table, game)
+
+	// 叫庄、反庄，然后倒计时结束；无人叫庄则翻底牌
+	for i, bid := range r.Bids {
+		hand, indices, err := findRecordCards(table, bid.Seat, bid.Cards)
+		if err != nil {
+			return nil, fmt.Errorf("bid %d: %w", i+1, err)
+		}
+		if _, err := callDealer(table, hand.UserID, bid.Suit, indices); err != nil {
+			return nil, fmt.Errorf("bid %d (seat %d): %w", i+1, bid.Seat, err)
+		}
+	}
+	if err := endCallCountdown(table); err != nil {
+		return nil, err
+	}
+	for table.Status == "calling" {
+		if _, err := flipBottomCard(table); err != nil {
+			return nil, err
+		}
+	}
+	if flips := CardCodes(table.FlippedBottomCards); len(r.Flips) > 0 && strings.Join(flips, ",") != strings.Join(r.Flips, ",") {
+		return nil, fmt.Errorf("the bottom flips %v, the record says %v", flips, r.Flips)
+	}
+	if r.Dealer != 0 && r.Dealer != table.DealerSeat {
+		return nil, fmt.Errorf("seat %d is the dealer, the record says seat %d", table.DealerSeat, r.Dealer)
+	}
+	if (r.Trump.Suit != "" && r.Trump.Suit != table.TrumpSuit) || (r.Trump.Rank != "" && r.Trump.Rank != table.TrumpRank) {
+		return nil, fmt.Errorf("trump is %s %s, the record says %s %s", table.TrumpSuit, table.TrumpRank, r.Trump.Suit, r.Trump.Rank)
+	}
+
+	// 扣底、叫朋友
+	dealer, indices, err := findRecordCards(table, table.DealerSeat, r.Discards)
+	if err != nil {
+		return nil, fmt.Errorf("discards: %w", err)
+	}
+	if _, err := discardBottomCards(table, dealer.UserID, indices); err != nil {
+		return nil, fmt.Errorf("discards: %w", err)
+	}
+	if r.Friend == nil {
+		return nil, fmt.Errorf("the record has no friend call")
+	}
+	called, err := ParseCard(r.Friend.Card)
+	if err != nil {
+		return nil, fmt.Errorf("friend: %w", err)
+	}
+	if err := callFriendCard(table, dealer.UserID, called.Suit, called.Value, r.Friend.Position); err != nil {
+		return nil, fmt.Errorf("friend: %w", err)
+	}
+
+	// 出牌
+	for t, trick := range r.Tricks {
+		if len(trick.Plays) != len(table.PlayerHands) {
+			return nil, fmt.Errorf("trick %d has %d plays", t+1, len(trick.Plays))
+		}
+		for p, play := range trick.Plays {
+			if table.Status != "playing" {
+				return nil, fmt.Errorf("trick %d: the hand is already over", t+1)
+			}
+			if play.Seat != table.CurrentPlayer {
+				return nil, fmt.Errorf("trick %d: seat %d played out of turn (seat %d was next)", t+1, play.Seat, table.CurrentPlayer)
+			}
+			hand, indices, err := findRecordCards(table, play.Seat, play.Cards)
+			if err != nil {
+				return nil, fmt.Errorf("trick %d: %w", t+1, err)
+			}
+			// 甩牌失败时引擎会改出最小的牌，与记录不符
+			if p == 0 && len(indices) >= 2 {
+				if _, _, reason := resolveThrow(table, play.Seat, indices); reason != "" {
+					return nil, fmt.Errorf("trick %d: seat %d's throw fails: %s", t+1, play.Seat, reason)
+				}
+			}
+			if _, err := playCardsGame(table, hand.UserID, indices); err != nil {
+				return nil, fmt.Errorf("trick %d: seat %d: %w", t+1, play.Seat, err)
+			}
+		}
+		winner := table.TrickHistory[len(table.TrickHistory)-1].Winner
+		if trick.Winner != 0 && trick.Winner != winner {
+			return nil, fmt.Errorf("trick %d is won by seat %d, the record says seat %d", t+1, winner, trick.Winner)
+		}
+	}
+	if table.Status != "finished" {
+		return nil, fmt.Errorf("the record ends after %d tricks, before the hand does", len(r.Tricks))
+	}
+	return table, nil
+}
+
+// findRecordCards finds the cards in a seat's hand, each at its own index
+func findRecordCards(table *GameTable, seat int, codes []string) (*PlayerHand, []int, error) {
+	hand, ok := table.PlayerHands[seat]
+	if !ok {
+		return nil, nil, fmt.Errorf("seat %d not found", seat)
+	}
+	cards, err := ParseCards(codes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	used := make(map[int]bool, len(cards))
+	indices := make([]int, 0, len(cards))
+	for _, card := range cards {
+		found := -1
+		for i, c := range hand.Cards {
+			if !used[i] && c.Suit == card.Suit && c.Value == card.Value {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, nil, fmt.Errorf("seat %d does not hold %s", seat, CardCode(card))
+		}
+		used[found] = true
+		indices = append(indices, found)
+	}
+	return hand, indices, nil
+}
+
+// check compares the recorded result with the one the rules give
+func (r RecordResult) check(actual RecordResult) error {
+	if r.WinnerTeam == "" {
+		return nil
+	}
+	if r.Points != actual.Points || r.WinnerTeam != actual.WinnerTeam || r.FriendSeat != actual.FriendSeat || r.SoloMode != actual.SoloMode {
+		return fmt.Errorf("the hand ends %d points for %s (friend seat %d), the record says %d points for %s (friend seat %d)",
+			actual.Points, actual.WinnerTeam, actual.FriendSeat, r.Points, r.WinnerTeam, r.FriendSeat)
+	}
+	if len(r.Levels) == 0 {
+		return nil
+	}
+	recorded := make(map[int]RecordLevel, len(r.Levels))
+	for _, level := range r.Levels {
+		recorded[level.Seat] = level
+	}
+	for _, level := range actual.Levels {
+		if recorded[level.Seat] != level {
+			return fmt.Errorf("seat %d goes from %s to %s, the record says %s to %s",
+				level.Seat, level.From, level.To, recorded[level.Seat].From, recorded[level.Seat].To)
+		}
+	}
+	return nil
+}
+
+// ExportGameRecord builds the record of a finished game from its action log
+// Players are named by their usernames, or by the names kept at import.
+func ExportGameRecord(gameID string) (*GameRecord, error) {
+	game, err := GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := GetGameActionLogs(gameID)
+	if err != nil {
+		return nil, err
+	}
+	record, err := RecordFromActions(actions)
+	if err != nil {
+		return nil, err
+	}
+	record.GameID = gameID
+	record.Name = game.Name
+
+	names := importedNames(actions)
+	for i := range record.Players {
+		p := &record.Players[i]
+		if name, ok := names[p.Seat]; ok {
+			p.Name = name
+		} else if user, err := GetUserByID(p.ID); err == nil {
+			p.Name = user.Username
+		}
+	}
+	return record, nil
+}
+
+// importedNames returns the player names an imported game kept (seat -> name)
+func importedNames(actions []GameActionLog) map[int]string {
+	for _, action := range actions {
+		if action.ActionType != "game_create" {
+			continue
+		}
+		var data struct {
+			PlayerNames map[int]string `json:"player_names"`
+		}
+		json.Unmarshal(action.ActionData, &data)
+		return data.PlayerNames
+	}
+	return nil
+}
+
+// importPlayerID is the placeholder user playing an imported seat; imported
+// games never count for, or change the level of, a real account
+func importPlayerID(seat int) string {
+	return fmt.Sprintf("import_%d", seat)
+}
+
+// ensureImportUser creates the placeholder user of an imported seat if needed
+// Its username carries the reserved prefix, so no registered account can clash
+// with it, and it can't log in. The seat names stay in the game_create action.
+func ensureImportUser(seat int) error {
+	id := importPlayerID(seat)
+	_, err := db.Exec(`INSERT INTO users (id, username, password, level, wins, losses, login_disabled) VALUES ($1, $2, $3, $4, $5, $6, TRUE)
+		ON CONFLICT (id) DO UPDATE SET login_disabled = TRUE, password = ''`,
+		id, placeholderUsernamePrefix+id, "", "2", 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create import user %s: %w", id, err)
+	}
+	return nil
+}
+
+// ImportGameRecord checks a record with PlayGameRecord and stores it as a
+// finished game hosted by importerID, with the logged actions and a replay row
+// Returns the new game ID and the result.
+func ImportGameRecord(record *GameRecord, importerID string) (string, *RecordResult, error) {
+	imported := *record
+	imported.Players = make([]RecordPlayer, len(record.Players))
+	names := make(map[int]string, len(record.Players))
+	for i, p := range record.Players {
+		if p.ID == "" {
+			return "", nil, fmt.Errorf("seat %d needs a player id", p.Seat)
+		}
+		names[p.Seat] = p.Name
+		if p.Name == "" {
+			names[p.Seat] = p.ID
+		}
+		p.ID = importPlayerID(p.Seat)
+		imported.Players[i] = p
+	}
+
+	played, err := PlayGameRecord(&imported)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for seat := 1; seat <= 5; seat++ {
+		if err := ensureImportUser(seat); err != nil {
+			return "", nil, err
+		}
+	}
+
+	name := strings.TrimSpace(record.Name)
+	if name == "" {
+		name = "导入对局"
+	}
+	var dealSeed, seedHash interface{}
+	if record.Seed != nil {
+		dealSeed, seedHash = *record.Seed, SeedHash(*record.Seed)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	gameID := generateID()
+	_, err = tx.Exec(`INSERT INTO games (id, name, host_id, max_players, status, current_level, deal_seed, seed_hash, seed_fixed) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		gameID, name, importerID, 5, "finished", record.Level, dealSeed, seedHash, record.Seed != nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create imported game: %w", err)
+	}
+	for seat := 1; seat <= 5; seat++ {
+		if _, err := tx.Exec(`INSERT INTO game_players (game_id, user_id, seat_number) VALUES ($1, $2, $3)`, gameID, importPlayerID(seat), seat); err != nil {
+			return "", nil, fmt.Errorf("failed to add imported player: %w", err)
+		}
+	}
+
+	createData, _ := json.Marshal(map[string]interface{}{
+		"game_name":      name,
+		"max_players":    5,
+		"host_id":        importerID,
+		"imported":       true,
+		"source_game_id": record.GameID,
+		"player_names":   names,
+	})
+	actions := append([]GameActionLog{{
+		ActionType: "game_create",
+		PlayerID:   importerID,
+		ActionData: createData,
+		ResultData: json.RawMessage(`{"status":"finished"}`),
+		Timestamp:  time.Now(),
+	}}, played.Actions...)
+	for _, action := range actions {
+		resultData := interface{}(nil)
+		if len(action.ResultData) > 0 {
+			resultData = []byte(action.ResultData)
+		}
+		_, err := tx.Exec(`INSERT INTO game_action_logs (game_id, action_type, player_seat, player_id, action_data, result_data, timestamp) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)`,
+			gameID, action.ActionType, action.PlayerSeat, action.PlayerID, []byte(action.ActionData), resultData, action.Timestamp)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to store imported action %s: %w", action.ActionType, err)
+		}
+	}
+
+	initialState, _ := json.Marshal(map[string]interface{}{
+		"dealerSeat": played.Table.DealerSeat,
+		"trumpSuit":  played.Table.TrumpSuit,
+		"trumpRank":  played.Table.TrumpRank,
+	})
+	finalState, _ := json.Marshal(map[string]interface{}{
+		"totalPoints": played.Result.Points,
+		"winnerTeam":  played.Result.WinnerTeam,
+		"results":     played.Results,
+	})
+	// 导入的对局没有真实的时长
+	facets := replayFacets(played.Table)
+	_, err = tx.Exec(`INSERT INTO game_replays (game_id, initial_state, final_state, total_actions, duration_seconds, winner_team, final_score,
+		dealer_seat, dealer_id, level, solo_mode, bottom_taken, bottom_points) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		gameID, initialState, finalState, len(actions), 0, played.Result.WinnerTeam, played.Result.Points,
+		facets.DealerSeat, facets.DealerID, facets.Level, facets.SoloMode, facets.BottomTaken, facets.BottomPoints)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create imported replay: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return gameID, &played.Result, nil
+}
+
+// RecordSimulatedHand plays a self-play hand (see SimulateHand) and returns its record
+// Hands with the same seed must not be recorded at the same time.
+func RecordSimulatedHand(seed int64, seats map[int]AISettings) (*GameRecord, *SimulatedHand, error) {
+	stop := captureActions(simulatedGameID(seed))
+	hand := SimulateHand(seed, seats)
+	actions := stop()
+	if hand.Error != "" {
+		return nil, hand, fmt.Errorf("seed %d: %s", seed, hand.Error)
+	}
+
+	// 自对弈不记 game_end；结果由牌桌算出
+	record, err := RecordFromActions(actions)
+	if err != nil {
+		return nil, hand, err
+	}
+	record.GameID = ""
+	record.Name = fmt.Sprintf("self-play seed %d", seed)
+	for i := range record.Players {
+		record.Players[i].Name = strategyLabel(aiSettingsFor(&PlayerHand{AI: settingsOf(seats, record.Players[i].Seat)}))
+	}
+	return record, hand, nil
+}
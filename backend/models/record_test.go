@@ -0,0 +1,191 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simulatedRecord records a self-play hand and passes it through JSON, as a
+// record file would
+func simulatedRecord(t *testing.T, seed int64) *GameRecord {
+	t.Helper()
+	record, _, err := RecordSimulatedHand(seed, nil)
+	if err != nil {
+		t.Fatalf("RecordSimulatedHand: %v", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("marshal record: %v", err)
+	}
+	var decoded GameRecord
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal record: %v", err)
+	}
+	return &decoded
+}
+
+// recordJSON is a record without what differs between two plays of the same hand
+func recordJSON(t *testing.T, record GameRecord) string {
+	t.Helper()
+	record.GameID, record.Name, record.PlayedAt = "", "", time.Time{}
+	players := make([]RecordPlayer, len(record.Players))
+	for i, p := range record.Players {
+		p.Name = ""
+		players[i] = p
+	}
+	record.Players = players
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("marshal record: %v", err)
+	}
+	return string(data)
+}
+
+func TestGameRecordRoundTrip(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		record := simulatedRecord(t, seed)
+		played, err := PlayGameRecord(record)
+		if err != nil {
+			t.Fatalf("seed %d: PlayGameRecord: %v", seed, err)
+		}
+		if played.Table.Status != "finished" {
+			t.Fatalf("seed %d: the played table is %s", seed, played.Table.Status)
+		}
+
+		exported, err := RecordFromActions(played.Actions)
+		if err != nil {
+			t.Fatalf("seed %d: RecordFromActions: %v", seed, err)
+		}
+		if got, want := recordJSON(t, *exported), recordJSON(t, *record); got != want {
+			t.Errorf("seed %d: the record changed on a round trip\n got %s\nwant %s", seed, got, want)
+		}
+	}
+}
+
+func TestPlayGameRecordRejects(t *testing.T) {
+	const seed = 1
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, r *GameRecord) string // returns what the error must mention
+	}{
+		{"illegal play", revokeInRecord},
+		{"six discards", func(t *testing.T, r *GameRecord) string {
+			r.Discards = r.Discards[:6]
+			return "discards"
+		}},
+		{"eight discards", func(t *testing.T, r *GameRecord) string {
+			r.Discards = append(r.Discards, r.Players[r.Dealer-1].Hand[0])
+			return "discards"
+		}},
+		{"points", func(t *testing.T, r *GameRecord) string {
+			r.Result.Points += 5
+			return "the record says"
+		}},
+		{"winner", func(t *testing.T, r *GameRecord) string {
+			if r.Result.WinnerTeam == "host" {
+				r.Result.WinnerTeam = "guest"
+			} else {
+				r.Result.WinnerTeam = "host"
+			}
+			return "the record says"
+		}},
+		{"levels", func(t *testing.T, r *GameRecord) string {
+			r.Result.Levels[0].To = "A"
+			return "the record says"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := simulatedRecord(t, seed)
+			want := tt.tamper(t, record)
+			_, err := PlayGameRecord(record)
+			if err == nil {
+				t.Fatal("the tampered record was accepted")
+			}
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not mention %q", err, want)
+			}
+		})
+	}
+}
+
+// revokeInRecord swaps a card of a two-card follow in the suit led with a single
+// the seat plays later in another suit, so the seat plays off suit while still
+// holding the suit led
+func revokeInRecord(t *testing.T, r *GameRecord) string {
+	t.Helper()
+	suitOf := func(code string) string {
+		card, err := ParseCard(code)
+		if err != nil {
+			t.Fatalf("ParseCard(%q): %v", code, err)
+		}
+		return card.Suit
+	}
+
+	for ti, trick := range r.Tricks {
+		lead := trick.Plays[0].Cards
+		if len(lead) != 2 {
+			continue
+		}
+		led := suitOf(lead[0])
+		for pi, play := range trick.Plays[1:] {
+			if suitOf(play.Cards[0]) != led || suitOf(play.Cards[1]) != led || play.Cards[0] == play.Cards[1] {
+				continue
+			}
+			for ui := ti + 1; ui < len(r.Tricks); ui++ {
+				for qi, later := range r.Tricks[ui].Plays {
+					if later.Seat != play.Seat || len(later.Cards) != 1 || suitOf(later.Cards[0]) == led {
+						continue
+					}
+					first := &r.Tricks[ti].Plays[pi+1].Cards[1]
+					second := &r.Tricks[ui].Plays[qi].Cards[0]
+					*first, *second = *second, *first
+					return fmt.Sprintf("trick %d: seat %d: must follow suit", ti+1, play.Seat)
+				}
+			}
+		}
+	}
+	t.Fatal("no trick to revoke in")
+	return ""
+}
+
+func TestCardCodes(t *testing.T) {
+	var deck []Card
+	for suit := range cardSuitCodes {
+		for _, value := range LevelOrder {
+			deck = append(deck, Card{Suit: suit, Value: value, Type: "normal"})
+		}
+	}
+	deck = append(deck, Card{Suit: "joker", Value: "small", Type: "joker"}, Card{Suit: "joker", Value: "big", Type: "joker"})
+
+	seen := make(map[string]bool, len(deck))
+	for _, card := range deck {
+		code := CardCode(card)
+		if seen[code] {
+			t.Errorf("%s is the code of two cards", code)
+		}
+		seen[code] = true
+		for _, written := range []string{code, strings.ToLower(code), " " + code + " "} {
+			parsed, err := ParseCard(written)
+			if err != nil || parsed != card {
+				t.Errorf("ParseCard(%q) = %+v, %v; want %+v", written, parsed, err, card)
+			}
+		}
+	}
+	if len(seen) != 54 {
+		t.Errorf("%d card codes, want 54", len(seen))
+	}
+
+	for _, bad := range []string{"", "S", "S1", "S11", "X10", "10S", "SJJ", "JK", "BJJ", "joker"} {
+		if card, err := ParseCard(bad); err == nil {
+			t.Errorf("ParseCard(%q) = %+v, want an error", bad, card)
+		}
+	}
+	if _, err := ParseCards([]string{"S10", "??"}); err == nil {
+		t.Error("ParseCards accepted a bad code")
+	}
+}
@@ -0,0 +1,365 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Logged payloads read back by the reducer. Field names follow the
+// ActionData / ResultData maps written by the game engine.
+
+type gameStartAction struct {
+	StartingDealer int            `json:"starting_dealer"`
+	CurrentLevel   string         `json:"current_level"`
+	PlayerIDs      map[int]string `json:"player_ids"`
+	PlayerLevels   map[int]string `json:"player_levels"`
+	Hands          map[int][]Card `json:"hands"`
+	BottomCards    []Card         `json:"bottom_cards"`
+}
+
+type callDealerAction struct {
+	Suit  string `json:"suit"`
+	Rank  string `json:"rank"`
+	Count int    `json:"count"`
+	Auto  bool   `json:"auto"`
+}
+
+type flipBottomAction struct {
+	Card Card `json:"card"`
+}
+
+// transitionResult is the outcome logged by call_dealer, flip_bottom and call_countdown_end
+type transitionResult struct {
+	DealerSeat int    `json:"dealer_seat"`
+	TrumpSuit  string `json:"trump_suit"`
+	TrumpRank  string `json:"trump_rank"`
+	CallPhase  string `json:"call_phase"`
+	Status     string `json:"status"`
+}
+
+type cardIndicesAction struct {
+	CardIndices []int  `json:"card_indices"`
+	Cards       []Card `json:"cards"`
+}
+
+type callFriendAction struct {
+	Suit     string `json:"suit"`
+	Value    string `json:"value"`
+	Position int    `json:"position"`
+}
+
+type trusteeshipAction struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+type trickCompleteResult struct {
+	WinnerSeat int `json:"winner_seat"`
+}
+
+type gameEndAction struct {
+	Results []GameResult `json:"results"`
+}
+
+// Apply returns the table after one logged action; state is never modified
+// state is nil before game_start. Actions that don't change the table (game_create,
+// player_join, ...) return an unchanged copy. Outcomes that depend on data outside
+// the table (player levels, rule versions) are taken from the logged result, so a
+// replay reproduces what actually happened.
+func Apply(state *GameTable, action GameActionLog) (*GameTable, error) {
+	if action.ActionType == "game_start" {
+		return applyGameStart(action)
+	}
+	if state == nil {
+		if isTableAction(action.ActionType) {
+			return nil, fmt.Errorf("%s before game_start", action.ActionType)
+		}
+		return nil, nil
+	}
+
+	table := state.Clone()
+	now := action.Timestamp
+
+	switch action.ActionType {
+	case "call_dealer":
+		var data callDealerAction
+		var result transitionResult
+		if err := decodeAction(action, &data, &result); err != nil {
+			return nil, err
+		}
+		if !data.Auto {
+			table.CallRecords = append(table.CallRecords, CallRecord{
+				Seat:      action.PlayerSeat,
+				Suit:      data.Suit,
+				Rank:      data.Rank,
+				Count:     data.Count,
+				Timestamp: now.UnixNano(),
+			})
+		}
+		if err := applyTransition(table, result); err != nil {
+			return nil, err
+		}
+		table.UpdatedAt = now
+
+	case "flip_bottom":
+		var data flipBottomAction
+		var result transitionResult
+		if err := decodeAction(action, &data, &result); err != nil {
+			return nil, err
+		}
+		table.FlippedBottomCards = append(table.FlippedBottomCards, data.Card)
+		// 翻到级牌或翻完底牌才定庄
+		if result.Status == "discarding" {
+			if err := applyTransition(table, result); err != nil {
+				return nil, err
+			}
+		}
+		table.UpdatedAt = now
+
+	case "call_countdown_end":
+		var result transitionResult
+		if err := decodeAction(action, nil, &result); err != nil {
+			return nil, err
+		}
+		// 有人叫庄则定庄进入扣牌，否则开始翻底牌
+		if result.Status == "discarding" {
+			if err := applyTransition(table, result); err != nil {
+				return nil, err
+			}
+		} else {
+			table.CallPhase = result.CallPhase
+		}
+		table.UpdatedAt = now
+
+	case "discard_bottom":
+		var data cardIndicesAction
+		if err := decodeAction(action, &data, nil); err != nil {
+			return nil, err
+		}
+		if table.Status != "discarding" {
+			return nil, fmt.Errorf("discard_bottom while %s", table.Status)
+		}
+		if err := checkIndices(table, table.DealerSeat, data.CardIndices, nil); err != nil {
+			return nil, err
+		}
+		applyDiscard(table, data.CardIndices, now)
+
+	case "call_friend":
+		var data callFriendAction
+		if err := decodeAction(action, &data, nil); err != nil {
+			return nil, err
+		}
+		if _, err := applyFriendCall(table, data.Suit, data.Value, data.Position, now); err != nil {
+			return nil, err
+		}
+
+	case "play_cards":
+		var data cardIndicesAction
+		if err := decodeAction(action, &data, nil); err != nil {
+			return nil, err
+		}
+		if table.Status != "playing" {
+			return nil, fmt.Errorf("play_cards while %s", table.Status)
+		}
+		if len(data.Cards) == 0 {
+			return nil, fmt.Errorf("play_cards logged without its cards")
+		}
+		if err := checkIndices(table, action.PlayerSeat, data.CardIndices, data.Cards); err != nil {
+			return nil, err
+		}
+		applyPlay(table, action.PlayerSeat, data.CardIndices, now)
+
+		result := &PlayResult{
+			Success: true,
+			Message: fmt.Sprintf("Played %d cards", len(data.Cards)),
+		}
+		if !isTrickComplete(table) {
+			result.NextPlayer = advanceTurn(table, action.PlayerSeat)
+		}
+		table.LastPlay = result
+
+	case "trick_complete":
+		var result trickCompleteResult
+		if err := decodeAction(action, nil, &result); err != nil {
+			return nil, err
+		}
+		if !isTrickComplete(table) {
+			return nil, fmt.Errorf("trick_complete after %d of %d plays", len(table.TrickPlays), len(table.PlayerHands))
+		}
+		completeTrick(table, result.WinnerSeat)
+
+		lastPlay := &PlayResult{Success: true}
+		if table.LastPlay != nil {
+			*lastPlay = *table.LastPlay
+		}
+		lastPlay.TrickComplete = true
+		lastPlay.TrickWinner = result.WinnerSeat
+		if table.Status == "finished" {
+			lastPlay.GameEnded = true
+			lastPlay.FinalScore, lastPlay.WinnerTeam = handResult(table, result.WinnerSeat)
+		}
+		table.LastPlay = lastPlay
+
+	case "trusteeship":
+		var data trusteeshipAction
+		if err := decodeAction(action, &data, nil); err != nil {
+			return nil, err
+		}
+		hand, ok := table.PlayerHands[action.PlayerSeat]
+		if !ok {
+			return nil, fmt.Errorf("seat %d not found", action.PlayerSeat)
+		}
+		hand.Trusteeship = data.Enabled
+		hand.TrusteeshipReason = ""
+		if data.Enabled {
+			hand.TrusteeshipReason = data.Reason
+		}
+
+	case "game_end":
+		var data gameEndAction
+		if err := decodeAction(action, &data, nil); err != nil {
+			return nil, err
+		}
+		table.Status = "finished"
+		if table.LastPlay != nil {
+			table.LastPlay.GameResults = data.Results
+		}
+	}
+
+	return table, nil
+}
+
+// ReplayActions folds a game's actions into its table, starting before game_start
+func ReplayActions(actions []GameActionLog) (*GameTable, error) {
+	var table *GameTable
+	for i, action := range actions {
+		next, err := Apply(table, action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d (%s): %w", i+1, action.ActionType, err)
+		}
+		table = next
+	}
+	return table, nil
+}
+
+// BuildTableAtStep rebuilds the table after the first step logged actions of a game
+// Returns the table (nil if the game had not started yet at that step) and the
+// total number of actions.
+func BuildTableAtStep(gameID string, step int) (*GameTable, int, error) {
+	actions, err := GetGameActionLogs(gameID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if step < 0 || step > len(actions) {
+		return nil, len(actions), fmt.Errorf("step must be between 0 and %d", len(actions))
+	}
+
+	table, err := ReplayActions(actions[:step])
+	if err != nil {
+		return nil, len(actions), err
+	}
+	return table, len(actions), nil
+}
+
+// applyGameStart rebuilds the freshly dealt table from the logged deal
+func applyGameStart(action GameActionLog) (*GameTable, error) {
+	var data gameStartAction
+	if err := decodeAction(action, &data, nil); err != nil {
+		return nil, err
+	}
+	if len(data.Hands) == 0 {
+		return nil, fmt.Errorf("game was logged without its deal and cannot be replayed")
+	}
+
+	seatCount := len(data.PlayerIDs)
+	playerIDs := make([]string, seatCount)
+	levels := make([]string, seatCount)
+	hands := make([][]Card, seatCount)
+	for seat := 1; seat <= seatCount; seat++ {
+		playerID, ok := data.PlayerIDs[seat]
+		if !ok {
+			return nil, fmt.Errorf("game_start is missing seat %d", seat)
+		}
+		playerIDs[seat-1] = playerID
+		levels[seat-1] = data.PlayerLevels[seat]
+		hands[seat-1] = copyCards(data.Hands[seat])
+	}
+
+	return newDealtTable(action.GameID, action.PlayerID, data.CurrentLevel, data.StartingDealer,
+		playerIDs, levels, hands, copyCards(data.BottomCards), action.Timestamp), nil
+}
+
+// applyTransition applies a logged dealer decision; the dealer picks up the
+// bottom when the log says the game moved on to discarding
+func applyTransition(table *GameTable, result transitionResult) error {
+	dealerHand, ok := table.PlayerHands[result.DealerSeat]
+	if !ok {
+		return fmt.Errorf("dealer seat %d not found", result.DealerSeat)
+	}
+
+	table.DealerSeat = result.DealerSeat
+	table.TrumpSuit = result.TrumpSuit
+	if result.TrumpRank != "" {
+		table.TrumpRank = result.TrumpRank
+	}
+	table.HostID = dealerHand.UserID
+	table.CallPhase = "finished"
+
+	if result.Status == "discarding" {
+		finalizeDealerAndStartPlaying(table)
+	} else if result.CallPhase != "" {
+		table.CallPhase = result.CallPhase
+	}
+	return nil
+}
+
+// checkIndices makes sure logged card indices still point at the logged cards
+// (cards may be nil when only the indices were logged)
+func checkIndices(table *GameTable, seat int, indices []int, cards []Card) error {
+	hand, ok := table.PlayerHands[seat]
+	if !ok {
+		return fmt.Errorf("seat %d not found", seat)
+	}
+	if len(indices) == 0 {
+		return fmt.Errorf("no card indices logged")
+	}
+	if cards != nil && len(cards) != len(indices) {
+		return fmt.Errorf("logged %d cards for %d indices", len(cards), len(indices))
+	}
+
+	used := make(map[int]bool, len(indices))
+	for i, idx := range indices {
+		if idx < 0 || idx >= len(hand.Cards) || used[idx] {
+			return fmt.Errorf("invalid card index %d for seat %d", idx, seat)
+		}
+		used[idx] = true
+		if cards != nil && (hand.Cards[idx].Suit != cards[i].Suit || hand.Cards[idx].Value != cards[i].Value) {
+			return fmt.Errorf("seat %d card %d does not match the log", seat, idx)
+		}
+	}
+	return nil
+}
+
+// decodeAction unmarshals the action and result payloads (either may be nil)
+func decodeAction(action GameActionLog, data, result interface{}) error {
+	if data != nil && len(action.ActionData) > 0 {
+		if err := json.Unmarshal(action.ActionData, data); err != nil {
+			return fmt.Errorf("invalid %s action data: %w", action.ActionType, err)
+		}
+	}
+	if result != nil && len(action.ResultData) > 0 {
+		if err := json.Unmarshal(action.ResultData, result); err != nil {
+			return fmt.Errorf("invalid %s result data: %w", action.ActionType, err)
+		}
+	}
+	return nil
+}
+
+// isTableAction reports whether the action type changes the game table
+func isTableAction(actionType string) bool {
+	switch actionType {
+	case "call_dealer", "flip_bottom", "call_countdown_end", "discard_bottom", "call_friend", "play_cards", "trick_complete", "trusteeship", "game_end":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+// TestReplayActionsMatchesSelfPlay folds the action log of self-play hands back
+// into a table and checks it ends where the live table did
+func TestReplayActionsMatchesSelfPlay(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		stop := captureActions(simulatedGameID(seed))
+		hand := SimulateHand(seed, nil)
+		actions := stop()
+		if hand.Error != "" {
+			t.Fatalf("seed %d: %s", seed, hand.Error)
+		}
+
+		table, err := ReplayActions(actions)
+		if err != nil {
+			t.Fatalf("seed %d: ReplayActions: %v", seed, err)
+		}
+		if table.Status != "finished" || table.LastPlay == nil || !table.LastPlay.GameEnded {
+			t.Fatalf("seed %d: replayed table is %s, not finished", seed, table.Status)
+		}
+		if table.DealerSeat != hand.DealerSeat {
+			t.Errorf("seed %d: dealer %d, self-play had %d", seed, table.DealerSeat, hand.DealerSeat)
+		}
+		if hand.FriendSeat != 0 && (!table.FriendRevealed || table.FriendSeat != hand.FriendSeat) {
+			t.Errorf("seed %d: friend %d (revealed %t), self-play had %d", seed, table.FriendSeat, table.FriendRevealed, hand.FriendSeat)
+		}
+		if table.LastPlay.FinalScore != hand.Points || table.LastPlay.WinnerTeam != hand.WinnerTeam {
+			t.Errorf("seed %d: %d points (%s wins), self-play had %d (%s wins)", seed,
+				table.LastPlay.FinalScore, table.LastPlay.WinnerTeam, hand.Points, hand.WinnerTeam)
+		}
+		if len(table.TrickHistory) == 0 {
+			t.Errorf("seed %d: no tricks replayed", seed)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Step-by-step replay: the table rebuilt after every logged action (see Apply),
+// with what the replay view shows next to it, and an index of the tricks so a
+// viewer can seek by trick as well as by action.
+
+// ReplayFrame is the table after one logged action
+type ReplayFrame struct {
+	Step       int       `json:"step"` // actions applied so far (1 = the first action)
+	ActionType string    `json:"actionType"`
+	Seat       int       `json:"seat"` // seat that acted (0 = system)
+	Timestamp  time.Time `json:"timestamp"`
+
+	// Derived from the table after the action
+	TrickNumber    int `json:"trickNumber"`    // trick in progress, or the one just completed (0 before play)
+	TrickPoints    int `json:"trickPoints"`    // points on the table in the current trick
+	TrickWinner    int `json:"trickWinner"`    // seat winning the current trick so far (0 if nobody played)
+	DefenderPoints int `json:"defenderPoints"` // 抓分方已得分（不含底牌）
+
+	Table *TableView `json:"table"` // nil before game_start
+}
+
+// ReplayTrick locates one trick in the replay
+type ReplayTrick struct {
+	Number    int `json:"number"`
+	FirstStep int `json:"firstStep"` // the lead
+	LastStep  int `json:"lastStep"`  // trick_complete
+	Leader    int `json:"leader"`
+	Winner    int `json:"winner"`
+	Points    int `json:"points"`
+}
+
+// Replay is a whole game as frames
+type Replay struct {
+	GameID     string        `json:"gameId"`
+	Open       bool          `json:"open"` // every hand is shown (finished games)
+	TotalSteps int           `json:"totalSteps"`
+	Tricks     []ReplayTrick `json:"tricks"`
+	Frames     []ReplayFrame `json:"frames"`
+}
+
+// BuildReplay rebuilds every step of a game from its action log
+// view projects each table; open replays pass (*GameTable).PostGameView so all
+// five hands are shown.
+func BuildReplay(gameID string, view func(*GameTable) *TableView, open bool) (*Replay, error) {
+	actions, err := GetGameActionLogs(gameID)
+	if err != nil {
+		return nil, err
+	}
+	return replayFrames(gameID, actions, view, open)
+}
+
+// replayFrames folds the actions into frames
+func replayFrames(gameID string, actions []GameActionLog, view func(*GameTable) *TableView, open bool) (*Replay, error) {
+	replay := &Replay{
+		GameID:     gameID,
+		Open:       open,
+		TotalSteps: len(actions),
+		Tricks:     make([]ReplayTrick, 0),
+		Frames:     make([]ReplayFrame, 0, len(actions)),
+	}
+
+	var table *GameTable
+	for i, action := range actions {
+		next, err := Apply(table, action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d (%s): %w", i+1, action.ActionType, err)
+		}
+		table = next
+		step := i + 1
+
+		frame := ReplayFrame{
+			Step:       step,
+			ActionType: action.ActionType,
+			Seat:       action.PlayerSeat,
+			Timestamp:  action.Timestamp,
+		}
+		if table != nil {
+			frame.TrickNumber = len(table.TrickHistory)
+			if len(table.TrickPlays) > 0 {
+				frame.TrickNumber++
+				frame.TrickWinner = determineTrickWinner(table.TrickPlays, table.TrumpSuit, table.TrumpRank)
+				for _, play := range table.TrickPlays {
+					frame.TrickPoints += cardPoints(play.Cards)
+				}
+			}
+			frame.DefenderPoints = defenderPoints(table)
+			frame.Table = view(table)
+		}
+		replay.Frames = append(replay.Frames, frame)
+
+		switch {
+		case action.ActionType == "play_cards" && table != nil && len(table.TrickPlays) == 1:
+			replay.Tricks = append(replay.Tricks, ReplayTrick{
+				Number:    len(table.TrickHistory) + 1,
+				FirstStep: step,
+				Leader:    action.PlayerSeat,
+			})
+		case action.ActionType == "trick_complete" && len(replay.Tricks) > 0:
+			trick := &replay.Tricks[len(replay.Tricks)-1]
+			completed := table.TrickHistory[len(table.TrickHistory)-1]
+			trick.LastStep = step
+			trick.Winner = completed.Winner
+			for _, play := range completed.Plays {
+				trick.Points += cardPoints(play.Cards)
+			}
+		}
+	}
+	return replay, nil
+}
+
+// defenderPoints counts the points caught so far by the seats known to be
+// defenders (everyone but the dealer and the revealed friend), without the bottom
+func defenderPoints(table *GameTable) int {
+	points := 0
+	for seat, hand := range table.PlayerHands {
+		if seat != table.DealerSeat && (!table.FriendRevealed || seat != table.FriendSeat) {
+			points += cardPoints(hand.Collected)
+		}
+	}
+	return points
+}
+
+// FrameAt returns the frame after step actions (1..TotalSteps)
+func (r *Replay) FrameAt(step int) (*ReplayFrame, error) {
+	if step < 1 || step > len(r.Frames) {
+		return nil, fmt.Errorf("step must be between 1 and %d", len(r.Frames))
+	}
+	return &r.Frames[step-1], nil
+}
+
+// TrickFrame returns the frame where trick number is complete
+// Trick 0 is the table just before the first lead.
+func (r *Replay) TrickFrame(number int) (*ReplayFrame, error) {
+	if number < 0 || number > len(r.Tricks) {
+		return nil, fmt.Errorf("trick must be between 0 and %d", len(r.Tricks))
+	}
+	if number == 0 {
+		if len(r.Tricks) == 0 {
+			return r.FrameAt(len(r.Frames))
+		}
+		return r.FrameAt(r.Tricks[0].FirstStep - 1)
+	}
+	trick := r.Tricks[number-1]
+	if trick.LastStep == 0 {
+		return r.FrameAt(len(r.Frames)) // 未完成的一墩：停在最后一步
+	}
+	return r.FrameAt(trick.LastStep)
+}
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Replay search: finished games newest first, filtered on the facts stored with
+// each replay (see ReplayFacets) and paged by keyset. A page ends with the cursor
+// of its last row; the next page starts strictly after it, so rows added while
+// paging never shift or repeat a page.
+
+// DefaultReplayPageSize and MaxReplayPageSize bound the replays on one page
+const (
+	DefaultReplayPageSize = 20
+	MaxReplayPageSize     = 100
+)
+
+// ErrInvalidReplayCursor is returned for a cursor that SearchGameReplays didn't make
+var ErrInvalidReplayCursor = errors.New("invalid cursor")
+
+// ReplayFilter selects replays; zero fields don't filter
+type ReplayFilter struct {
+	PlayerID   string     // the user played in the game
+	PlayerWon  *bool      // with PlayerID: the user was on the winning side
+	DealerID   string     // the user was the dealer
+	From       *time.Time // finished at or after
+	To         *time.Time // finished before
+	WinnerTeam string     // host or guest
+	SoloMode   *bool      // 1打4
+	Bottom     *bool      // 抠底
+	MinScore   *int       // 抓分方得分
+	MaxScore   *int
+	Level      string // 打的级
+	Cursor     string // NextCursor of the previous page
+	Limit      int
+}
+
+// ReplaySummary is one game in a replay listing
+type ReplaySummary struct {
+	ID              int       `json:"id"`
+	GameID          string    `json:"gameId"`
+	TotalActions    int       `json:"totalActions"`
+	DurationSeconds int       `json:"durationSeconds"`
+	WinnerTeam      string    `json:"winnerTeam"`
+	FinalScore      int       `json:"finalScore"`
+	CreatedAt       time.Time `json:"createdAt"`
+	ReplayFacets
+	Players []ReplayPlayer `json:"players"`
+}
+
+// ReplayPlayer is a seat of a listed game
+type ReplayPlayer struct {
+	Seat     int    `json:"seat"`
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+// ReplayPage is one page of a replay search
+type ReplayPage struct {
+	Replays    []ReplaySummary `json:"replays"`
+	NextCursor string          `json:"nextCursor,omitempty"` // empty on the last page
+}
+
+// SearchGameReplays returns the replays matching the filter, newest first
+func SearchGameReplays(filter ReplayFilter) (*ReplayPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultReplayPageSize
+	}
+	if limit > MaxReplayPageSize {
+		limit = MaxReplayPageSize
+	}
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.PlayerID != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM game_players gp WHERE gp.game_id = r.game_id AND gp.user_id = `+arg(filter.PlayerID)+`)`)
+		if filter.PlayerWon != nil {
+			conditions = append(conditions, `EXISTS (SELECT 1 FROM game_records gr WHERE gr.game_id = r.game_id AND gr.user_id = `+arg(filter.PlayerID)+` AND gr.is_winner = `+arg(*filter.PlayerWon)+`)`)
+		}
+	}
+	if filter.DealerID != "" {
+		conditions = append(conditions, "r.dealer_id = "+arg(filter.DealerID))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "r.created_at >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "r.created_at < "+arg(*filter.To))
+	}
+	if filter.WinnerTeam != "" {
+		conditions = append(conditions, "r.winner_team = "+arg(filter.WinnerTeam))
+	}
+	if filter.SoloMode != nil {
+		conditions = append(conditions, "r.solo_mode = "+arg(*filter.SoloMode))
+	}
+	if filter.Bottom != nil {
+		conditions = append(conditions, "r.bottom_taken = "+arg(*filter.Bottom))
+	}
+	if filter.MinScore != nil {
+		conditions = append(conditions, "r.final_score >= "+arg(*filter.MinScore))
+	}
+	if filter.MaxScore != nil {
+		conditions = append(conditions, "r.final_score <= "+arg(*filter.MaxScore))
+	}
+	if filter.Level != "" {
+		conditions = append(conditions, "r.level = "+arg(filter.Level))
+	}
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeReplayCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "(r.created_at, r.id) < ("+arg(createdAt)+", "+arg(id)+")")
+	}
+
+	query := `
+		SELECT r.id, r.game_id, r.total_actions, r.duration_seconds, r.winner_team, r.final_score, r.created_at,
+			r.dealer_seat, r.dealer_id, r.level, r.solo_mode, r.bottom_taken, r.bottom_points
+		FROM game_replays r`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	// 多取一条，判断是否还有下一页
+	query += "\n\t\tORDER BY r.created_at DESC, r.id DESC\n\t\tLIMIT " + arg(limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search game replays: %w", err)
+	}
+	defer rows.Close()
+
+	page := &ReplayPage{Replays: make([]ReplaySummary, 0, limit)}
+	for rows.Next() {
+		var r ReplaySummary
+		var winnerTeam, dealerID, level sql.NullString
+		var dealerSeat, bottomPoints sql.NullInt64
+		var soloMode, bottomTaken sql.NullBool
+		err := rows.Scan(&r.ID, &r.GameID, &r.TotalActions, &r.DurationSeconds, &winnerTeam, &r.FinalScore, &r.CreatedAt,
+			&dealerSeat, &dealerID, &level, &soloMode, &bottomTaken, &bottomPoints)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan game replay: %w", err)
+		}
+		r.WinnerTeam = winnerTeam.String
+		r.DealerSeat = int(dealerSeat.Int64)
+		r.DealerID = dealerID.String
+		r.Level = level.String
+		r.SoloMode = soloMode.Bool
+		r.BottomTaken = bottomTaken.Bool
+		r.BottomPoints = int(bottomPoints.Int64)
+		r.Players = make([]ReplayPlayer, 0, 5)
+		page.Replays = append(page.Replays, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating game replays: %w", err)
+	}
+
+	if len(page.Replays) > limit {
+		page.Replays = page.Replays[:limit]
+		last := page.Replays[limit-1]
+		page.NextCursor = encodeReplayCursor(last.CreatedAt, last.ID)
+	}
+	if err := loadReplayPlayers(page.Replays); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// backfillReplayFacets fills in the facets of replays stored before they were
+// recorded (solo_mode still NULL) by replaying their action logs
+// Replays whose log can't be replayed stay unknown and are marked facets_failed,
+// so later boots don't replay them again. Returns how many were filled in.
+func backfillReplayFacets() (int, error) {
+	rows, err := db.Query(`SELECT game_id FROM game_replays WHERE solo_mode IS NULL AND NOT facets_failed`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query replays without facets: %w", err)
+	}
+	var gameIDs []string
+	for rows.Next() {
+		var gameID string
+		if err := rows.Scan(&gameID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan game replay: %w", err)
+		}
+		gameIDs = append(gameIDs, gameID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating game replays: %w", err)
+	}
+
+	filled := 0
+	for _, gameID := range gameIDs {
+		actions, err := GetGameActionLogs(gameID)
+		if err != nil {
+			return filled, err
+		}
+		table, err := ReplayActions(actions)
+		if err != nil || table == nil || table.Status != "finished" {
+			if _, err := db.Exec(`UPDATE game_replays SET facets_failed = TRUE WHERE game_id = $1`, gameID); err != nil {
+				return filled, fmt.Errorf("failed to mark the facets of game %s as unknown: %w", gameID, err)
+			}
+			continue
+		}
+		facets := replayFacets(table)
+		_, err = db.Exec(`
+			UPDATE game_replays SET dealer_seat = $2, dealer_id = NULLIF($3, ''), level = $4,
+				solo_mode = $5, bottom_taken = $6, bottom_points = $7
+			WHERE game_id = $1
+		`, gameID, facets.DealerSeat, facets.DealerID, facets.Level, facets.SoloMode, facets.BottomTaken, facets.BottomPoints)
+		if err != nil {
+			return filled, fmt.Errorf("failed to update facets of game %s: %w", gameID, err)
+		}
+		filled++
+	}
+	return filled, nil
+}
+
+// loadReplayPlayers fills in the seats of the listed games
+func loadReplayPlayers(replays []ReplaySummary) error {
+	if len(replays) == 0 {
+		return nil
+	}
+	gameIDs := make([]string, len(replays))
+	byGame := make(map[string]*ReplaySummary, len(replays))
+	for i := range replays {
+		gameIDs[i] = replays[i].GameID
+		byGame[replays[i].GameID] = &replays[i]
+	}
+
+	rows, err := db.Query(`
+		SELECT gp.game_id, gp.seat_number, gp.user_id, COALESCE(u.username, gp.user_id)
+		FROM game_players gp
+		LEFT JOIN users u ON u.id = gp.user_id
+		WHERE gp.game_id = ANY($1)
+		ORDER BY gp.game_id, gp.seat_number
+	`, pq.Array(gameIDs))
+	if err != nil {
+		return fmt.Errorf("failed to query replay players: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gameID string
+		var p ReplayPlayer
+		if err := rows.Scan(&gameID, &p.Seat, &p.UserID, &p.Username); err != nil {
+			return fmt.Errorf("failed to scan replay player: %w", err)
+		}
+		if r, ok := byGame[gameID]; ok {
+			r.Players = append(r.Players, p)
+		}
+	}
+	return rows.Err()
+}
+
+// encodeReplayCursor makes the opaque cursor of a listed replay
+func encodeReplayCursor(createdAt time.Time, id int) string {
+	raw := fmt.Sprintf("%d:%d", createdAt.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeReplayCursor reads a cursor made by encodeReplayCursor
+func decodeReplayCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidReplayCursor
+	}
+	microsStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidReplayCursor
+	}
+	micros, err := strconv.ParseInt(microsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidReplayCursor
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidReplayCursor
+	}
+	return time.UnixMicro(micros).UTC(), id, nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplayCursor(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 20, 30, 15, 123456000, time.FixedZone("CST", 8*3600))
+	gotAt, gotID, err := decodeReplayCursor(encodeReplayCursor(createdAt, 42))
+	if err != nil || !gotAt.Equal(createdAt) || gotID != 42 {
+		t.Errorf("round trip = %v, %d, %v; want %v, 42", gotAt, gotID, err, createdAt)
+	}
+
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	for _, bad := range []string{
+		"",
+		"not base64!",
+		encode("1714566615123456"),
+		encode("now:42"),
+		encode("1714566615123456:"),
+		encode("1714566615123456:id"),
+		base64.StdEncoding.EncodeToString([]byte("1714566615123456:42")) + "=",
+	} {
+		if _, _, err := decodeReplayCursor(bad); !errors.Is(err, ErrInvalidReplayCursor) {
+			t.Errorf("decodeReplayCursor(%q) = %v, want ErrInvalidReplayCursor", bad, err)
+		}
+	}
+}
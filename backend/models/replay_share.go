@@ -0,0 +1,251 @@
+package models
+
+import (
+	cryptorand "crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Share links: a player of a finished game makes an unguessable token that
+// serves the open replay without login. Shared replays never carry user or game
+// IDs; seats are labelled seat_1..seat_5 and, when the share is anonymized, players
+// are named 座位1..座位5 instead of by username. A token works until revoked.
+
+// shareTokenBytes is the randomness in a share token (base64url, 43 characters)
+const shareTokenBytes = 32
+
+// sharedReplayCacheSize is how many built shared replays are kept; a finished
+// game never changes, so a popular link is rebuilt only once
+var sharedReplayCacheSize = max(1, getEnvInt("SHARE_CACHE_SIZE", 64))
+
+// sharedReplays caches built shared replays by game and anonymize flag, oldest
+// evicted first
+var sharedReplays = struct {
+	sync.Mutex
+	entries map[sharedReplayKey]*SharedReplay
+	order   []sharedReplayKey
+}{entries: make(map[sharedReplayKey]*SharedReplay)}
+
+// sharedReplayKey identifies a shared replay: every token of a game with the
+// same anonymize flag serves the same replay
+type sharedReplayKey struct {
+	gameID    string
+	anonymize bool
+}
+
+// ErrShareNotFound is returned for unknown or revoked share tokens
+var ErrShareNotFound = errors.New("share not found")
+
+// ReplayShare is a share link of one game
+type ReplayShare struct {
+	Token     string     `json:"token"`
+	GameID    string     `json:"gameId"`
+	CreatedBy string     `json:"createdBy"`
+	Anonymize bool       `json:"anonymize"` // seat labels instead of usernames
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// SharedPlayer is a seat as a shared replay shows it
+type SharedPlayer struct {
+	Seat  int    `json:"seat"`
+	Label string `json:"label"` // stands in for the user ID in the frames
+	Name  string `json:"name"`
+}
+
+// SharedReplay is a replay served by share token
+type SharedReplay struct {
+	*Replay
+	Anonymize bool           `json:"anonymize"`
+	Players   []SharedPlayer `json:"players"`
+}
+
+// CreateReplayShare makes a new share token for a game
+func CreateReplayShare(gameID, userID string, anonymize bool) (*ReplayShare, error) {
+	var buf [shareTokenBytes]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	share := &ReplayShare{
+		Token:     base64.RawURLEncoding.EncodeToString(buf[:]),
+		GameID:    gameID,
+		CreatedBy: userID,
+		Anonymize: anonymize,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := db.Exec(`INSERT INTO replay_shares (token, game_id, created_by, anonymize, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		share.Token, share.GameID, share.CreatedBy, share.Anonymize, share.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replay share: %w", err)
+	}
+	return share, nil
+}
+
+// GetReplayShare returns the share of a token, or ErrShareNotFound if it was revoked
+func GetReplayShare(token string) (*ReplayShare, error) {
+	var share ReplayShare
+	err := db.QueryRow(`
+		SELECT token, game_id, created_by, anonymize, created_at
+		FROM replay_shares
+		WHERE token = $1 AND revoked_at IS NULL
+	`, token).Scan(&share.Token, &share.GameID, &share.CreatedBy, &share.Anonymize, &share.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query replay share: %w", err)
+	}
+	return &share, nil
+}
+
+// ListReplayShares returns the shares a user made of a game, newest first
+func ListReplayShares(gameID, userID string) ([]ReplayShare, error) {
+	rows, err := db.Query(`
+		SELECT token, game_id, created_by, anonymize, created_at, revoked_at
+		FROM replay_shares
+		WHERE game_id = $1 AND created_by = $2
+		ORDER BY created_at DESC
+	`, gameID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query replay shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := make([]ReplayShare, 0)
+	for rows.Next() {
+		var share ReplayShare
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&share.Token, &share.GameID, &share.CreatedBy, &share.Anonymize, &share.CreatedAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan replay share: %w", err)
+		}
+		if revokedAt.Valid {
+			share.RevokedAt = &revokedAt.Time
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating replay shares: %w", err)
+	}
+	return shares, nil
+}
+
+// RevokeReplayShare stops a token from working; only its creator can revoke it
+func RevokeReplayShare(gameID, token, userID string) error {
+	result, err := db.Exec(`
+		UPDATE replay_shares SET revoked_at = CURRENT_TIMESTAMP
+		WHERE token = $1 AND game_id = $2 AND created_by = $3 AND revoked_at IS NULL
+	`, token, gameID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke replay share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// BuildSharedReplay returns the open replay of a shared game without user or game
+// IDs, from the cache if it was built before; callers must not modify it
+func BuildSharedReplay(share *ReplayShare) (*SharedReplay, error) {
+	key := sharedReplayKey{gameID: share.GameID, anonymize: share.Anonymize}
+	sharedReplays.Lock()
+	cached, ok := sharedReplays.entries[key]
+	sharedReplays.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	replay, err := buildSharedReplay(share)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedReplays.Lock()
+	defer sharedReplays.Unlock()
+	if _, ok := sharedReplays.entries[key]; !ok {
+		if len(sharedReplays.order) >= sharedReplayCacheSize {
+			delete(sharedReplays.entries, sharedReplays.order[0])
+			sharedReplays.order = sharedReplays.order[1:]
+		}
+		sharedReplays.entries[key] = replay
+		sharedReplays.order = append(sharedReplays.order, key)
+	}
+	return replay, nil
+}
+
+// buildSharedReplay rebuilds the replay of a shared game from its action log
+func buildSharedReplay(share *ReplayShare) (*SharedReplay, error) {
+	actions, err := GetGameActionLogs(share.GameID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 座位和用户ID的对应从发牌日志取得
+	var dealt *GameTable
+	for _, action := range actions {
+		if action.ActionType == "game_start" {
+			if dealt, err = Apply(nil, action); err != nil {
+				return nil, fmt.Errorf("action game_start: %w", err)
+			}
+			break
+		}
+	}
+	if dealt == nil {
+		return nil, fmt.Errorf("the game was never dealt")
+	}
+
+	labels := make(map[string]string, len(dealt.PlayerHands))
+	players := make([]SharedPlayer, 0, len(dealt.PlayerHands))
+	names := importedNames(actions)
+	for seat := 1; seat <= 5; seat++ {
+		hand, ok := dealt.PlayerHands[seat]
+		if !ok {
+			continue
+		}
+		player := SharedPlayer{Seat: seat, Label: fmt.Sprintf("seat_%d", seat), Name: fmt.Sprintf("座位%d", seat)}
+		if !share.Anonymize {
+			if name, ok := names[seat]; ok {
+				player.Name = name
+			} else if user, err := GetUserByID(hand.UserID); err == nil {
+				player.Name = user.Username
+			}
+		}
+		labels[hand.UserID] = player.Label
+		players = append(players, player)
+	}
+
+	replay, err := replayFrames(share.GameID, actions, sharedView(labels), true)
+	if err != nil {
+		return nil, err
+	}
+	replay.GameID = ""
+	return &SharedReplay{Replay: replay, Anonymize: share.Anonymize, Players: players}, nil
+}
+
+// sharedView projects a table post-game with every user ID replaced by its seat
+// label and the game ID left out
+func sharedView(labels map[string]string) func(*GameTable) *TableView {
+	return func(t *GameTable) *TableView {
+		view := t.PostGameView()
+		view.GameID = ""
+		view.HostID = labels[view.HostID]
+		for i := range view.Seats {
+			view.Seats[i].UserID = labels[view.Seats[i].UserID]
+		}
+		if view.LastPlay != nil && len(view.LastPlay.GameResults) > 0 {
+			lastPlay := *view.LastPlay
+			lastPlay.GameResults = make([]GameResult, len(view.LastPlay.GameResults))
+			for i, result := range view.LastPlay.GameResults {
+				result.UserID = labels[result.UserID]
+				lastPlay.GameResults[i] = result
+			}
+			view.LastPlay = &lastPlay
+		}
+		return view
+	}
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// Deal is everything decided by a game's seed: the hands, the bottom and the
+// starting dealer
+type Deal struct {
+	Seed           int64          `json:"seed"`
+	SeedHash       string         `json:"seedHash"`
+	StartingDealer int            `json:"startingDealer"`
+	Hands          map[int][]Card `json:"hands"` // seat -> cards
+	BottomCards    []Card         `json:"bottomCards"`
+}
+
+// SeedHash is the published commitment to a seed: sha256 of its decimal form
+// Anyone can check a revealed seed with: echo -n <seed> | sha256sum
+func SeedHash(seed int64) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(seed, 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// DealFromSeed deals a 5-player game from a seed
+// The shuffle comes first and the starting dealer is drawn afterwards, so a
+// single player game (dealer fixed to seat 1) gets the same cards as a
+// multiplayer game with the same seed.
+func DealFromSeed(seed int64, singlePlayer bool) *Deal {
+	rng := rand.New(rand.NewSource(seed))
+	hands, bottomCards := DealCardsWithRand(5, rng)
+
+	startingDealer := rng.Intn(5) + 1 // Random seat 1-5
+	if singlePlayer {
+		startingDealer = 1
+	}
+
+	deal := &Deal{
+		Seed:           seed,
+		SeedHash:       SeedHash(seed),
+		StartingDealer: startingDealer,
+		Hands:          make(map[int][]Card, len(hands)),
+		BottomCards:    bottomCards,
+	}
+	for i, hand := range hands {
+		deal.Hands[i+1] = hand
+	}
+	return deal
+}
+
+// handList returns the hands ordered by seat (index 0 is seat 1)
+func (d *Deal) handList() [][]Card {
+	hands := make([][]Card, len(d.Hands))
+	for seat, cards := range d.Hands {
+		hands[seat-1] = cards
+	}
+	return hands
+}
+
+// pickDealSeed returns the requested seed, or a fresh one from crypto/rand
+// The second value reports whether the seed was chosen by the caller.
+func pickDealSeed(seed *int64) (int64, bool, error) {
+	if seed != nil {
+		return *seed, true, nil
+	}
+	var buf [8]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		return 0, false, fmt.Errorf("failed to generate deal seed: %w", err)
+	}
+	return int64(binary.BigEndian.Uint64(buf[:]) >> 1), false, nil
+}
+
+// ensureDealSeed gives games created before seeding existed a seed at start time
+func ensureDealSeed(game *GameState) error {
+	if game.HasSeed {
+		return nil
+	}
+	seed, _, err := pickDealSeed(nil)
+	if err != nil {
+		return err
+	}
+	hash := SeedHash(seed)
+	if _, err := db.Exec(`UPDATE games SET deal_seed = $1, seed_hash = $2 WHERE id = $3`, seed, hash, game.ID); err != nil {
+		return fmt.Errorf("failed to store deal seed: %w", err)
+	}
+	game.DealSeed = seed
+	game.HasSeed = true
+	game.SeedHash = hash
+	return nil
+}
+
+// RedealResult is a past game dealt again from its revealed seed
+type RedealResult struct {
+	GameID string `json:"gameId"`
+	Deal   *Deal  `json:"deal"`
+	// MatchesLog reports whether the re-deal equals the deal logged at game_start
+	MatchesLog bool `json:"matchesLog"`
+}
+
+// RedealGame deals a finished game again from its seed and checks it against the log
+// The seed stays secret while the game is running, so unfinished games are refused.
+func RedealGame(gameID string) (*RedealResult, error) {
+	game, err := GetGame(gameID)
+	if err != nil {
+		return nil, err
+	}
+	if !game.HasSeed {
+		return nil, fmt.Errorf("game has no deal seed")
+	}
+	if game.Status != "finished" {
+		return nil, fmt.Errorf("the seed is revealed when the game ends")
+	}
+
+	actions, err := GetGameActionLogs(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, action := range actions {
+		if action.ActionType != "game_start" {
+			continue
+		}
+		var logged struct {
+			gameStartAction
+			SinglePlayer bool `json:"single_player"`
+			HandNumber   int  `json:"hand_number"`
+		}
+		if err := json.Unmarshal(action.ActionData, &logged); err != nil {
+			return nil, fmt.Errorf("invalid game_start action data: %w", err)
+		}
+
+		deal := DealFromSeed(game.DealSeed, logged.SinglePlayer)
+		if logged.HandNumber > 1 {
+			// 后续局的起始发牌人由上一局结果决定，不来自种子
+			deal.StartingDealer = logged.StartingDealer
+		}
+		return &RedealResult{
+			GameID:     gameID,
+			Deal:       deal,
+			MatchesLog: dealMatches(deal, &logged.gameStartAction),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("game_start not found in the action log")
+}
+
+// dealMatches compares a deal card by card with the one logged at game_start
+func dealMatches(deal *Deal, logged *gameStartAction) bool {
+	if deal.StartingDealer != logged.StartingDealer || !sameCards(deal.BottomCards, logged.BottomCards) {
+		return false
+	}
+	if len(deal.Hands) != len(logged.Hands) {
+		return false
+	}
+	for seat, cards := range deal.Hands {
+		if !sameCards(cards, logged.Hands[seat]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameCards compares two card sequences in order
+func sameCards(a, b []Card) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Self-play: all-AI hands run through the rules engine in-process, with no
+// database, for tuning AIPlayer and catching engine errors at scale (cmd/simulate).
+// Every phase goes through the same commands the server runs on a timeout, so a
+// simulated hand is played exactly like an AI hand on a live table.
+
+// maxSimulationSteps bounds the commands of one hand (bids, flips, plays)
+const maxSimulationSteps = 2000
+
+// SimulatedHand is the outcome of one self-play hand
+type SimulatedHand struct {
+	Seed        int64  `json:"seed"`
+	DealerSeat  int    `json:"dealerSeat"`
+	FriendSeat  int    `json:"friendSeat"` // 0 if the friend never showed up
+	SoloMode    bool   `json:"soloMode"`   // 1打4
+	Points      int    `json:"points"`     // 抓分方得分（含底牌）
+	WinnerTeam  string `json:"winnerTeam"` // host or guest
+	BottomTaken bool   `json:"bottomTaken"`
+	// Violations are plays a strategy chose that the rules refused; the seat then
+	// plays the first legal play instead
+	Violations []PlayViolation `json:"violations,omitempty"`
+	Error      string          `json:"error,omitempty"` // engine error or panic; the hand is not scored
+}
+
+// PlayViolation is a play the rules engine refused
+type PlayViolation struct {
+	Trick       int    `json:"trick"`
+	Seat        int    `json:"seat"`
+	Strategy    string `json:"strategy"`
+	CardIndices []int  `json:"cardIndices"`
+	Error       string `json:"error"`
+}
+
+// IsHostTeam reports whether seat played on the dealer's side
+func (h *SimulatedHand) IsHostTeam(seat int) bool {
+	return seat == h.DealerSeat || seat == h.FriendSeat
+}
+
+// SimulateHand deals seed and lets the AI play all five seats to the end
+// seats holds each seat's settings (seat -> settings); missing seats play the
+// default difficulty. The hand is played at level 2 with the seed's starting dealer.
+func SimulateHand(seed int64, seats map[int]AISettings) (hand *SimulatedHand) {
+	hand = &SimulatedHand{Seed: seed}
+	defer func() {
+		if r := recover(); r != nil {
+			hand.Error = fmt.Sprintf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	deal := DealFromSeed(seed, false)
+	playerIDs := make([]string, 5)
+	levels := make([]string, 5)
+	for i := range playerIDs {
+		playerIDs[i] = fmt.Sprintf("ai_player_%d", i+1)
+		levels[i] = "2"
+	}
+	table := newDealtTable(simulatedGameID(seed), playerIDs[0], "2", deal.StartingDealer,
+		playerIDs, levels, deal.handList(), deal.BottomCards, time.Now())
+	logGameStart(table, &GameState{DealSeed: seed, HasSeed: true, SeedHash: deal.SeedHash})
+	for seat, settings := range seats {
+		if h, ok := table.PlayerHands[seat]; ok {
+			settings := settings
+			h.AI = &settings
+		}
+	}
+
+	for step := 0; table.Status != "finished"; step++ {
+		if step >= maxSimulationSteps {
+			hand.Error = fmt.Sprintf("hand not finished after %d steps (status %s)", step, table.Status)
+			return hand
+		}
+		if err := simulateStep(table, hand); err != nil {
+			hand.Error = fmt.Sprintf("%s: %v", table.Status, err)
+			return hand
+		}
+	}
+
+	lastWinner := table.TrickLeader
+	if n := len(table.TrickHistory); n > 0 {
+		lastWinner = table.TrickHistory[n-1].Winner
+	}
+	hand.DealerSeat = table.DealerSeat
+	if table.FriendRevealed && !table.IsSoloMode {
+		hand.FriendSeat = table.FriendSeat
+	}
+	hand.SoloMode = table.IsSoloMode || !table.FriendRevealed
+	hand.Points, hand.WinnerTeam = handResult(table, lastWinner)
+	hand.BottomTaken = !hand.IsHostTeam(lastWinner)
+	return hand
+}
+
+// simulatedGameID is the table ID of a self-play hand; nothing is stored under it
+func simulatedGameID(seed int64) string {
+	return fmt.Sprintf("sim_%d", seed)
+}
+
+// simulateStep does what the table waits for, like a turn timeout on the server
+// Plays are made here rather than by autoPlay so refused choices are recorded.
+func simulateStep(table *GameTable, hand *SimulatedHand) error {
+	if table.Status != "playing" {
+		return onTurnTimeout(table, nil)
+	}
+
+	seat := table.CurrentPlayer
+	player, ok := table.PlayerHands[seat]
+	if !ok {
+		return fmt.Errorf("player %d not found", seat)
+	}
+	indices := strategyFor(player).DecidePlay(table, seat)
+	_, err := playCardsGame(table, player.UserID, indices)
+	if err == nil {
+		return nil
+	}
+	hand.Violations = append(hand.Violations, PlayViolation{
+		Trick:       len(table.TrickHistory) + 1,
+		Seat:        seat,
+		Strategy:    strategyLabel(aiSettingsFor(player)),
+		CardIndices: indices,
+		Error:       err.Error(),
+	})
+
+	indices = findLegalPlay(table, player)
+	if indices == nil {
+		return fmt.Errorf("no legal play found for seat %d", seat)
+	}
+	_, err = playCardsGame(table, player.UserID, indices)
+	return err
+}
+
+// strategyLabel names a seat's settings in reports, e.g. "normal" or "hard/aggressive"
+func strategyLabel(settings AISettings) string {
+	if settings.Personality == "" || settings.Personality == AIPersonalityBalanced {
+		return settings.Difficulty
+	}
+	return settings.Difficulty + "/" + settings.Personality
+}
+
+// SimulationOptions configures a self-play run
+type SimulationOptions struct {
+	Hands    int                // number of hands
+	BaseSeed int64              // hand i is dealt from BaseSeed+i
+	Seats    map[int]AISettings // seat -> settings
+	Workers  int                // hands played in parallel, 0 = GOMAXPROCS
+}
+
+// StrategyStats is how one strategy did over a run, counted per seat and hand
+type StrategyStats struct {
+	Strategy      string  `json:"strategy"`
+	Hands         int     `json:"hands"`
+	Wins          int     `json:"wins"`
+	WinRate       float64 `json:"winRate"`
+	DealerHands   int     `json:"dealerHands"` // 在庄家方（庄家或朋友）
+	DealerWins    int     `json:"dealerWins"`
+	DefenderHands int     `json:"defenderHands"`
+	DefenderWins  int     `json:"defenderWins"`
+	Violations    int     `json:"violations"`
+}
+
+// SimulationReport sums up a self-play run
+type SimulationReport struct {
+	Hands         int              `json:"hands"`  // hands played to the end
+	Failed        int              `json:"failed"` // hands stopped by an engine error or panic
+	BaseSeed      int64            `json:"baseSeed"`
+	Seats         map[int]string   `json:"seats"` // seat -> strategy label
+	Strategies    []*StrategyStats `json:"strategies"`
+	HostWins      int              `json:"hostWins"`
+	GuestWins     int              `json:"guestWins"`
+	AveragePoints float64          `json:"averagePoints"` // 抓分方平均得分
+	SoloHands     int              `json:"soloHands"`
+	SoloRate      float64          `json:"soloRate"`
+	BottomTaken   int              `json:"bottomTaken"` // 抠底
+	BottomRate    float64          `json:"bottomRate"`
+	Violations    int              `json:"violations"`
+	Errors        []string         `json:"errors,omitempty"` // one line per failed hand, with its seed
+	Duration      time.Duration    `json:"duration"`
+}
+
+// Simulate plays opts.Hands self-play hands and reports the totals
+func Simulate(opts SimulationOptions) *SimulationReport {
+	start := time.Now()
+	hands := playHands(opts.Hands, opts.Workers, func(i int) *SimulatedHand {
+		return SimulateHand(opts.BaseSeed+int64(i), opts.Seats)
+	})
+
+	report := SummarizeHands(hands, opts.Seats)
+	report.BaseSeed = opts.BaseSeed
+	report.Duration = time.Since(start)
+	return report
+}
+
+// playHands runs play(0..n-1) on workers goroutines (0 = GOMAXPROCS) and returns
+// the hands in order
+func playHands(n, workers int, play func(i int) *SimulatedHand) []*SimulatedHand {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	hands := make([]*SimulatedHand, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				hands[i] = play(i)
+			}
+		}()
+	}
+	for i := range hands {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return hands
+}
+
+// SummarizeHands adds up simulated hands into a report
+func SummarizeHands(hands []*SimulatedHand, seats map[int]AISettings) *SimulationReport {
+	report := &SimulationReport{Seats: make(map[int]string, 5)}
+	labels := make(map[int]string, 5)
+	stats := make(map[string]*StrategyStats)
+	for seat := 1; seat <= 5; seat++ {
+		label := strategyLabel(aiSettingsFor(&PlayerHand{AI: settingsOf(seats, seat)}))
+		labels[seat] = label
+		report.Seats[seat] = label
+		if stats[label] == nil {
+			stats[label] = &StrategyStats{Strategy: label}
+		}
+	}
+
+	totalPoints := 0
+	for _, hand := range hands {
+		if hand.Error != "" {
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("seed %d: %s", hand.Seed, hand.Error))
+			continue
+		}
+		report.Hands++
+		totalPoints += hand.Points
+		report.Violations += len(hand.Violations)
+		if hand.WinnerTeam == "host" {
+			report.HostWins++
+		} else {
+			report.GuestWins++
+		}
+		if hand.SoloMode {
+			report.SoloHands++
+		}
+		if hand.BottomTaken {
+			report.BottomTaken++
+		}
+
+		for seat := 1; seat <= 5; seat++ {
+			s := stats[labels[seat]]
+			s.Hands++
+			won := hand.IsHostTeam(seat) == (hand.WinnerTeam == "host")
+			if won {
+				s.Wins++
+			}
+			if hand.IsHostTeam(seat) {
+				s.DealerHands++
+				if won {
+					s.DealerWins++
+				}
+			} else {
+				s.DefenderHands++
+				if won {
+					s.DefenderWins++
+				}
+			}
+		}
+		for _, v := range hand.Violations {
+			stats[labels[v.Seat]].Violations++
+		}
+	}
+
+	for _, s := range stats {
+		if s.Hands > 0 {
+			s.WinRate = float64(s.Wins) / float64(s.Hands)
+		}
+		report.Strategies = append(report.Strategies, s)
+	}
+	sort.Slice(report.Strategies, func(i, j int) bool {
+		return report.Strategies[i].Strategy < report.Strategies[j].Strategy
+	})
+	if report.Hands > 0 {
+		report.AveragePoints = float64(totalPoints) / float64(report.Hands)
+		report.SoloRate = float64(report.SoloHands) / float64(report.Hands)
+		report.BottomRate = float64(report.BottomTaken) / float64(report.Hands)
+	}
+	return report
+}
+
+// settingsOf returns a copy of seat's settings, or nil if it has none
+func settingsOf(seats map[int]AISettings, seat int) *AISettings {
+	settings, ok := seats[seat]
+	if !ok {
+		return nil
+	}
+	return &settings
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestSimulateHandReproducible checks that a seeded self-play hand with the
+// heuristic AI plays out the same way every time (cmd/simulate relies on it)
+func TestSimulateHandReproducible(t *testing.T) {
+	seats := map[int]AISettings{
+		1: {Personality: AIPersonalityAggressive},
+		2: {Personality: AIPersonalityConservative},
+	}
+	plays := func(seed int64) []string {
+		stop := captureActions(simulatedGameID(seed))
+		hand := SimulateHand(seed, seats)
+		actions := stop()
+		if hand.Error != "" {
+			t.Fatalf("seed %d: %s", seed, hand.Error)
+		}
+		var logged []string
+		for _, action := range actions {
+			logged = append(logged, action.ActionType+" "+string(action.ActionData))
+		}
+		return logged
+	}
+
+	for seed := int64(1); seed <= 10; seed++ {
+		first := plays(seed)
+		for run := 0; run < 3; run++ {
+			if again := plays(seed); !slices.Equal(first, again) {
+				t.Fatalf("seed %d played differently on run %d", seed, run+2)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// TableSnapshotter persists in-flight tables so games survive a server restart
+type TableSnapshotter interface {
+	// SaveTable stores the latest state of a table
+	SaveTable(table *GameTable) error
+	// LoadTable returns the stored table, or ErrGameNotFound if there is none
+	LoadTable(gameID string) (*GameTable, error)
+	// ActiveGameIDs lists the games that were still being played
+	ActiveGameIDs() ([]string, error)
+	// DeleteTable removes the stored table of a game that left the store
+	DeleteTable(gameID string) error
+}
+
+// postgresSnapshotter stores tables as JSONB in game_table_snapshots
+type postgresSnapshotter struct{}
+
+// SaveTable upserts the table state
+func (postgresSnapshotter) SaveTable(table *GameTable) error {
+	stateJSON, err := json.Marshal(table)
+	if err != nil {
+		return fmt.Errorf("failed to marshal table state: %w", err)
+	}
+
+	query := `
+		INSERT INTO game_table_snapshots (game_id, state, status, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (game_id) DO UPDATE SET
+			state = EXCLUDED.state,
+			status = EXCLUDED.status,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	if _, err := db.Exec(query, table.GameID, stateJSON, table.Status); err != nil {
+		return fmt.Errorf("failed to save table snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadTable reads the latest stored state of a table
+func (postgresSnapshotter) LoadTable(gameID string) (*GameTable, error) {
+	var stateJSON []byte
+	err := db.QueryRow(`SELECT state FROM game_table_snapshots WHERE game_id = $1`, gameID).Scan(&stateJSON)
+	if err == sql.ErrNoRows {
+		return nil, ErrGameNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load table snapshot: %w", err)
+	}
+
+	table := &GameTable{}
+	if err := json.Unmarshal(stateJSON, table); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal table snapshot: %w", err)
+	}
+	if table.PlayerHands == nil {
+		table.PlayerHands = make(map[int]*PlayerHand)
+	}
+	if len(table.TrickPlays) == 0 && len(table.CurrentTrick) > 0 {
+		// Snapshot taken before plays were tracked per seat: rebuild them from the cards
+		table.TrickPlays = trickPlaysFromCards(table.CurrentTrick)
+	}
+	return table, nil
+}
+
+// DeleteTable removes a table snapshot
+func (postgresSnapshotter) DeleteTable(gameID string) error {
+	if _, err := db.Exec(`DELETE FROM game_table_snapshots WHERE game_id = $1`, gameID); err != nil {
+		return fmt.Errorf("failed to delete table snapshot: %w", err)
+	}
+	return nil
+}
+
+// ActiveGameIDs lists snapshots of games that are still marked as playing
+func (postgresSnapshotter) ActiveGameIDs() ([]string, error) {
+	query := `
+		SELECT s.game_id
+		FROM game_table_snapshots s
+		JOIN games g ON g.id = s.game_id
+		WHERE g.status = 'playing' AND s.status != 'finished'
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecoverActiveGames loads every in-flight table back into memory
+// Called once at startup so games survive restarts and deploys; tables that
+// are not recovered here are still rehydrated lazily on first access.
+func RecoverActiveGames() (int, error) {
+	return activeGames.Recover()
+}
+
+// Recover loads all active snapshots into the store
+func (s *GameStore) Recover() (int, error) {
+	if s.snapshots == nil {
+		return 0, nil
+	}
+
+	ids, err := s.snapshots.ActiveGameIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, id := range ids {
+		if _, err := s.load(id); err != nil {
+			log.Printf("Warning: failed to recover game %s: %v", id, err)
+			continue
+		}
+		recovered++
+	}
+	return recovered, nil
+}
+
+// trickPlaysFromCards groups a trick's cards into plays (consecutive cards of a seat)
+func trickPlaysFromCards(trick []PlayedCard) []TrickPlay {
+	plays := make([]TrickPlay, 0, 5)
+	for _, pc := range trick {
+		if n := len(plays); n > 0 && plays[n-1].Seat == pc.Seat {
+			plays[n-1].Cards = append(plays[n-1].Cards, pc.Card)
+			continue
+		}
+		plays = append(plays, TrickPlay{
+			Seat:      pc.Seat,
+			Cards:     []Card{pc.Card},
+			IsLead:    pc.IsLead,
+			Timestamp: pc.Timestamp,
+		})
+	}
+	return plays
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySnapshotter keeps snapshots as JSON in memory, like game_table_snapshots
+type memorySnapshotter struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func newMemorySnapshotter() *memorySnapshotter {
+	return &memorySnapshotter{states: make(map[string][]byte)}
+}
+
+func (m *memorySnapshotter) SaveTable(table *GameTable) error {
+	state, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[table.GameID] = state
+	return nil
+}
+
+func (m *memorySnapshotter) LoadTable(gameID string) (*GameTable, error) {
+	m.mu.Lock()
+	state, ok := m.states[gameID]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrGameNotFound
+	}
+	table := &GameTable{}
+	if err := json.Unmarshal(state, table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func (m *memorySnapshotter) DeleteTable(gameID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, gameID)
+	return nil
+}
+
+func (m *memorySnapshotter) ActiveGameIDs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, state := range m.states {
+		var table struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(state, &table); err != nil {
+			return nil, err
+		}
+		if table.Status != "finished" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// playLegal makes the current seat play the first legal play it holds
+func playLegal(t *testing.T, gameID string) *GameTable {
+	t.Helper()
+	table, err := GetTableGame(gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hand := table.PlayerHands[table.CurrentPlayer]
+	if _, err := PlayCardsGame(gameID, hand.UserID, findLegalPlay(table, hand)); err != nil {
+		t.Fatalf("seat %d: %v", table.CurrentPlayer, err)
+	}
+	table, err = GetTableGame(gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+// TestStoreRestartMidHand snapshots a hand in play, starts a new store on the same
+// snapshots as a restarted server would, and plays the hand to the end
+func TestStoreRestartMidHand(t *testing.T) {
+	const gameID = "store_test_restart"
+	snapshots := newMemorySnapshotter()
+
+	before := NewGameStore(snapshots, nil)
+	useTestStore(t, before)
+	if !before.PutIfAbsent(playingTestTable(t, gameID, 11), nil) {
+		t.Fatal("table already stored")
+	}
+	var table *GameTable
+	for i := 0; i < 12; i++ {
+		table = playLegal(t, gameID)
+	}
+	if table.Deadline == 0 {
+		t.Fatal("the turn has no deadline")
+	}
+
+	// 重启：内存里的牌桌没了，只剩快照
+	after := NewGameStore(snapshots, nil)
+	useTestStore(t, after)
+	if after.Has(gameID) {
+		t.Fatal("new store already holds the table")
+	}
+	recovered, err := after.Recover()
+	if err != nil || recovered != 1 {
+		t.Fatalf("recovered %d games: %v", recovered, err)
+	}
+
+	restored, err := GetTableGame(gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.CurrentPlayer != table.CurrentPlayer || len(restored.TrickHistory) != len(table.TrickHistory) ||
+		len(restored.TrickPlays) != len(table.TrickPlays) || restored.Deadline != table.Deadline {
+		t.Fatalf("restored table differs: player %d/%d, tricks %d/%d, plays %d/%d, deadline %d/%d",
+			restored.CurrentPlayer, table.CurrentPlayer, len(restored.TrickHistory), len(table.TrickHistory),
+			len(restored.TrickPlays), len(table.TrickPlays), restored.Deadline, table.Deadline)
+	}
+	for seat, hand := range table.PlayerHands {
+		if len(restored.PlayerHands[seat].Cards) != len(hand.Cards) {
+			t.Fatalf("seat %d holds %d cards, had %d", seat, len(restored.PlayerHands[seat].Cards), len(hand.Cards))
+		}
+	}
+
+	// 不换手的命令不能重置当前回合的计时
+	time.Sleep(5 * time.Millisecond)
+	reconnected, err := PlayerConnected(gameID, "player_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconnected.Deadline != table.Deadline {
+		t.Errorf("deadline moved from %d to %d after the restart", table.Deadline, reconnected.Deadline)
+	}
+
+	for step := 0; table.Status != "finished"; step++ {
+		if step > 5*31 {
+			t.Fatal("hand not finished")
+		}
+		table = playLegal(t, gameID)
+	}
+	played := 0
+	for _, trick := range table.TrickHistory {
+		for _, play := range trick.Plays {
+			played += len(play.Cards)
+		}
+	}
+	if played != 5*31 {
+		t.Errorf("%d cards played, want %d", played, 5*31)
+	}
+}
+
+// TestStoreEvictsFinishedTable drops a finished table from memory and from the
+// snapshots once its time is up
+func TestStoreEvictsFinishedTable(t *testing.T) {
+	ttl := finishedTableTTL
+	finishedTableTTL = 20 * time.Millisecond
+	t.Cleanup(func() { finishedTableTTL = ttl })
+
+	const gameID = "store_test_evict"
+	snapshots := newMemorySnapshotter()
+	store := NewGameStore(snapshots, nil)
+	useTestStore(t, store)
+	store.PutIfAbsent(playingTestTable(t, gameID, 5), nil)
+
+	table, _ := GetTableGame(gameID)
+	for table.Status != "finished" {
+		table = playLegal(t, gameID)
+	}
+	if !store.Has(gameID) {
+		t.Fatal("finished table left the store right away")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); store.Has(gameID); {
+		if time.Now().After(deadline) {
+			t.Fatal("finished table still in the store")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := snapshots.LoadTable(gameID); err != ErrGameNotFound {
+		t.Errorf("snapshot of the evicted table: %v, want %v", err, ErrGameNotFound)
+	}
+	if _, err := GetTableGame(gameID); err == nil {
+		t.Error("evicted table still served")
+	}
+}
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// GameStore holds the active game tables in memory
+// Every game has its own mutex: all commands on a table (HTTP handlers, AI turns,
+// timers) run one at a time, while different games never block each other.
+// If a snapshotter is set, every successful command is persisted and tables
+// missing from memory (e.g. after a restart) are rehydrated on first access.
+// If timers is set, each stored table's Deadline is armed as a turn timer.
+type GameStore struct {
+	mu        sync.RWMutex
+	tables    map[string]*storedTable
+	snapshots TableSnapshotter
+	timers    *turnScheduler
+}
+
+// storedTable is a table together with the lock that serializes its commands
+type storedTable struct {
+	mu      sync.Mutex
+	table   *GameTable
+	version uint64 // commands persisted so far, under mu
+
+	evicting bool // the table has finished and is due to leave the store, under mu
+
+	// Snapshots are written after the table is unlocked; saveMu keeps a slow
+	// write of an older version from overwriting a newer one
+	saveMu  sync.Mutex
+	saved   uint64
+	deleted bool // the snapshot is gone with the table, under saveMu
+}
+
+// NewGameStore creates an empty game store
+// snapshots may be nil for a purely in-memory store, timers nil for no turn limits.
+func NewGameStore(snapshots TableSnapshotter, timers *turnScheduler) *GameStore {
+	return &GameStore{
+		tables:    make(map[string]*storedTable),
+		snapshots: snapshots,
+		timers:    timers,
+	}
+}
+
+// Active games live in memory, are snapshotted to Postgres and time out turns
+var activeGames = NewGameStore(postgresSnapshotter{}, newTurnScheduler())
+
+// finishedTableTTL is how long a finished table stays in the store, so players
+// can still see the last trick and the result; the replay takes over after that
+var finishedTableTTL = seconds(getEnvInt("FINISHED_TABLE_TTL", 600))
+
+// PutIfAbsent stores a new table; returns false if the game is already active
+// onStored (may be nil) runs under the table lock before any command can see the
+// table, e.g. to log game_start ahead of every other action.
+func (s *GameStore) PutIfAbsent(table *GameTable, onStored func(table *GameTable)) bool {
+	s.mu.Lock()
+
+	if _, exists := s.tables[table.GameID]; exists {
+		s.mu.Unlock()
+		return false
+	}
+	entry := &storedTable{table: table}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	s.tables[table.GameID] = entry
+	s.mu.Unlock()
+
+	s.save(table)
+	if onStored != nil {
+		onStored(table)
+	}
+	s.timers.arm(table.GameID, table.Deadline)
+	return true
+}
+
+// Delete removes a table from the store, together with its snapshot
+func (s *GameStore) Delete(gameID string) {
+	s.mu.Lock()
+	entry, exists := s.tables[gameID]
+	delete(s.tables, gameID)
+	s.timers.arm(gameID, 0)
+	s.mu.Unlock()
+	if !exists || s.snapshots == nil {
+		return
+	}
+
+	// 之后才写完的旧快照不能把牌桌写回去
+	entry.saveMu.Lock()
+	defer entry.saveMu.Unlock()
+	entry.deleted = true
+	if err := s.snapshots.DeleteTable(gameID); err != nil {
+		log.Printf("Warning: failed to delete snapshot of game %s: %v", gameID, err)
+	}
+}
+
+// evictLater deletes a finished table once finishedTableTTL has passed; the
+// caller holds entry.mu
+func (s *GameStore) evictLater(gameID string, entry *storedTable) {
+	if entry.evicting || entry.table.Status != "finished" {
+		return
+	}
+	entry.evicting = true
+	time.AfterFunc(finishedTableTTL, func() {
+		s.mu.RLock()
+		current := s.tables[gameID]
+		s.mu.RUnlock()
+		if current == entry {
+			s.Delete(gameID)
+		}
+	})
+}
+
+// Has reports whether the game is active in memory
+func (s *GameStore) Has(gameID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.tables[gameID]
+	return exists
+}
+
+// GameIDs returns the IDs of all active games
+func (s *GameStore) GameIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.tables))
+	for id := range s.tables {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Update runs fn with exclusive access to the table
+// The table is persisted if fn succeeds. Returns false if the game is neither
+// in memory nor in the snapshot store, and an error if fn fails or the snapshot
+// store can't be read.
+func (s *GameStore) Update(gameID string, fn func(table *GameTable) error) (bool, error) {
+	return s.run(gameID, true, fn)
+}
+
+// run locks the table and calls fn, persisting the result if asked to
+// Only fn itself runs under the lock: the snapshot write and the work fn left with
+// afterCommand run once the table is unlocked, and the actions fn logs are
+// written in the background (see actionLogs), so other commands on the table
+// don't wait for the database.
+func (s *GameStore) run(gameID string, persist bool, fn func(table *GameTable) error) (bool, error) {
+	entry, err := s.load(gameID)
+	if errors.Is(err, ErrGameNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var pending []func()
+	var snapshot *GameTable
+	var version uint64
+	func() {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		err = fn(entry.table)
+		pending, entry.table.afterUnlock = entry.table.afterUnlock, nil
+		if err == nil && persist {
+			entry.version++
+			version = entry.version
+			if s.snapshots != nil {
+				snapshot = entry.table.Clone()
+			}
+			s.timers.arm(gameID, entry.table.Deadline)
+			s.evictLater(gameID, entry)
+		}
+	}()
+
+	if snapshot != nil {
+		s.saveVersion(entry, snapshot, version)
+	}
+	for _, work := range pending {
+		work()
+	}
+	return true, err
+}
+
+// saveVersion persists a copy of the table taken at version, unless a newer
+// version has been written meanwhile
+func (s *GameStore) saveVersion(entry *storedTable, table *GameTable, version uint64) {
+	entry.saveMu.Lock()
+	defer entry.saveMu.Unlock()
+	if version <= entry.saved || entry.deleted {
+		return
+	}
+	s.save(table)
+	entry.saved = version
+}
+
+// load returns the in-memory entry, rehydrating it from the snapshot store if needed
+func (s *GameStore) load(gameID string) (*storedTable, error) {
+	s.mu.RLock()
+	entry, exists := s.tables[gameID]
+	s.mu.RUnlock()
+	if exists {
+		return entry, nil
+	}
+
+	if s.snapshots == nil {
+		return nil, ErrGameNotFound
+	}
+	table, err := s.snapshots.LoadTable(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another request may have rehydrated the table meanwhile
+	if entry, exists := s.tables[gameID]; exists {
+		return entry, nil
+	}
+	restoreTurnKey(table)
+	entry = &storedTable{table: table}
+	s.tables[gameID] = entry
+	// 重启前的计时继续有效，已过期的会立即触发
+	s.timers.arm(gameID, table.Deadline)
+	s.evictLater(gameID, entry)
+	return entry, nil
+}
+
+// save persists the table; failures are logged, the in-memory game keeps going
+func (s *GameStore) save(table *GameTable) {
+	if s.snapshots == nil {
+		return
+	}
+	if err := s.snapshots.SaveTable(table); err != nil {
+		log.Printf("Warning: failed to snapshot game %s: %v", table.GameID, err)
+	}
+}
+
+// Snapshot returns a deep copy of the table taken under its lock
+func (s *GameStore) Snapshot(gameID string) (*GameTable, bool, error) {
+	var snapshot *GameTable
+	found, err := s.run(gameID, false, func(table *GameTable) error {
+		snapshot = table.Clone()
+		return nil
+	})
+	return snapshot, found, err
+}
+
+// afterCommand queues fn to run once the command has released the table lock
+// For slow work that needs no lock (database writes, dealing the next hand); fn
+// must not touch the table. Tables outside the store never run it.
+func (t *GameTable) afterCommand(fn func()) {
+	t.afterUnlock = append(t.afterUnlock, fn)
+}
+
+// updateTable runs a command against an active table and returns a snapshot of
+// the resulting state, which callers may read without holding the lock
+func updateTable(gameID string, fn func(table *GameTable) error) (*GameTable, error) {
+	var snapshot *GameTable
+	found, err := activeGames.Update(gameID, func(table *GameTable) error {
+		if err := fn(table); err != nil {
+			return err
+		}
+		refreshDeadline(table, time.Now())
+		snapshot = table.Clone()
+		return nil
+	})
+	if !found {
+		if err != nil {
+			return nil, err
+		}
+		// Not in memory: let the command run against the placeholder table
+		// (e.g. a waiting room) so it reports the usual state errors
+		table, err := GetTableGame(gameID)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(table); err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Clone returns a deep copy of the table
+func (t *GameTable) Clone() *GameTable {
+	clone := *t
+	clone.afterUnlock = nil
+
+	if t.HostCalledCard != nil {
+		called := *t.HostCalledCard
+		clone.HostCalledCard = &called
+	}
+	if t.LastPlay != nil {
+		lastPlay := *t.LastPlay
+		lastPlay.GameResults = append([]GameResult(nil), t.LastPlay.GameResults...)
+		clone.LastPlay = &lastPlay
+	}
+
+	clone.BottomCards = copyCards(t.BottomCards)
+	clone.FlippedBottomCards = copyCards(t.FlippedBottomCards)
+	clone.CurrentTrick = append([]PlayedCard{}, t.CurrentTrick...)
+	clone.TrickPlays = copyTrickPlays(t.TrickPlays)
+	clone.CallRecords = append([]CallRecord{}, t.CallRecords...)
+
+	clone.TrickHistory = copyTrickHistory(t.TrickHistory)
+
+	clone.TricksWon = make([][]Card, 0, len(t.TricksWon))
+	for _, trick := range t.TricksWon {
+		clone.TricksWon = append(clone.TricksWon, copyCards(trick))
+	}
+
+	clone.PlayerHands = make(map[int]*PlayerHand, len(t.PlayerHands))
+	for seat, hand := range t.PlayerHands {
+		handCopy := *hand
+		handCopy.Cards = copyCards(hand.Cards)
+		handCopy.Collected = copyCards(hand.Collected)
+		if hand.AI != nil {
+			settings := *hand.AI
+			handCopy.AI = &settings
+		}
+		clone.PlayerHands[seat] = &handCopy
+	}
+
+	return &clone
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// playingTestTable deals a hand from seed to human seats and settles bidding,
+// discard and friend call the way the turn timer would, so play can start
+func playingTestTable(t *testing.T, gameID string, seed int64) *GameTable {
+	t.Helper()
+	deal := DealFromSeed(seed, false)
+	playerIDs := make([]string, 5)
+	levels := make([]string, 5)
+	for i := range playerIDs {
+		playerIDs[i] = fmt.Sprintf("player_%d", i+1)
+		levels[i] = "2"
+	}
+	table := newDealtTable(gameID, playerIDs[0], "2", deal.StartingDealer, playerIDs, levels, deal.handList(), deal.BottomCards, time.Now())
+
+	for step := 0; table.Status != "playing"; step++ {
+		if step > 100 {
+			t.Fatalf("hand did not reach play, status %s", table.Status)
+		}
+		if err := onTurnTimeout(table, nil); err != nil {
+			t.Fatalf("%s: %v", table.Status, err)
+		}
+	}
+	// 超时代打会托管座位，测试里的玩家都在线
+	for _, hand := range table.PlayerHands {
+		hand.Trusteeship, hand.TrusteeshipReason, hand.Timeouts = false, "", 0
+	}
+	refreshDeadline(table, time.Now())
+	return table
+}
+
+// useTestStore makes store the active game store for the rest of the test
+func useTestStore(t *testing.T, store *GameStore) {
+	t.Helper()
+	previous := activeGames
+	activeGames = store
+	t.Cleanup(func() { activeGames = previous })
+}
+
+// TestGameStoreConcurrentPlays plays a whole hand with every seat and a few
+// spectators hammering the same table; run with -race
+func TestGameStoreConcurrentPlays(t *testing.T) {
+	store := NewGameStore(nil, newTurnScheduler())
+	useTestStore(t, store)
+
+	const gameID = "store_test_concurrent"
+	if !store.PutIfAbsent(playingTestTable(t, gameID, 7), nil) {
+		t.Fatal("table already stored")
+	}
+	t.Cleanup(func() { store.Delete(gameID) })
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	plays, refused := 0, 0
+
+	for seat := 1; seat <= 5; seat++ {
+		wg.Add(1)
+		go func(seat int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("player_%d", seat)
+			for {
+				table, err := GetTableGame(gameID)
+				if err != nil {
+					t.Errorf("seat %d: %v", seat, err)
+					return
+				}
+				if table.Status == "finished" {
+					return
+				}
+				if table.CurrentPlayer != seat {
+					continue
+				}
+				// 在快照上选的牌可能已经过时，被拒绝也没关系
+				indices := findLegalPlay(table, table.PlayerHands[seat])
+				_, err = PlayCardsGame(gameID, userID, indices)
+				mu.Lock()
+				if err == nil {
+					plays++
+				} else {
+					refused++
+				}
+				mu.Unlock()
+			}
+		}(seat)
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if table, err := GetTableGame(gameID); err == nil {
+					_ = table.ViewFor(0)
+				}
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		for {
+			if table, err := GetTableGame(gameID); err != nil || table.Status == "finished" {
+				close(finished)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(60 * time.Second):
+		t.Fatal("hand not finished in time")
+	}
+	close(done)
+	wg.Wait()
+
+	table, err := GetTableGame(gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	played := 0
+	for _, trick := range table.TrickHistory {
+		for _, play := range trick.Plays {
+			played += len(play.Cards)
+		}
+	}
+	for seat, hand := range table.PlayerHands {
+		if len(hand.Cards) != 0 {
+			t.Errorf("seat %d still holds %d cards", seat, len(hand.Cards))
+		}
+	}
+	if played != 5*31 {
+		t.Errorf("%d cards played, want %d", played, 5*31)
+	}
+	if plays != len(table.TrickHistory)*5 {
+		t.Errorf("%d plays accepted for %d tricks", plays, len(table.TrickHistory))
+	}
+	t.Logf("%d plays accepted, %d refused as stale", plays, refused)
+}
+
+// brokenSnapshotter fails every read, like a database that is down
+type brokenSnapshotter struct{ *memorySnapshotter }
+
+var errSnapshotsDown = errors.New("snapshot store down")
+
+func (brokenSnapshotter) LoadTable(gameID string) (*GameTable, error) {
+	return nil, errSnapshotsDown
+}
+
+// TestGameStoreLoadError reports a failing snapshot store as such, not as a
+// game that doesn't exist
+func TestGameStoreLoadError(t *testing.T) {
+	useTestStore(t, NewGameStore(brokenSnapshotter{newMemorySnapshotter()}, nil))
+
+	if _, err := GetTableGame("store_test_broken"); !errors.Is(err, errSnapshotsDown) {
+		t.Errorf("GetTableGame: %v, want %v", err, errSnapshotsDown)
+	}
+	if _, err := AIPlayTurn("store_test_broken"); !errors.Is(err, errSnapshotsDown) {
+		t.Errorf("AIPlayTurn: %v, want %v", err, errSnapshotsDown)
+	}
+}
+
+// TestGameStoreAfterCommand runs the work a command leaves behind once the table
+// is unlocked, so it can use the table like any other request
+func TestGameStoreAfterCommand(t *testing.T) {
+	store := NewGameStore(nil, nil)
+	useTestStore(t, store)
+
+	const gameID = "store_test_after"
+	store.PutIfAbsent(&GameTable{GameID: gameID, Status: "playing"}, nil)
+
+	ran := false
+	_, err := store.Update(gameID, func(table *GameTable) error {
+		table.afterCommand(func() {
+			// 还在锁里的话这里会死锁
+			if _, found, err := store.Snapshot(gameID); !found || err != nil {
+				t.Errorf("table not readable after the command: %v", err)
+			}
+			ran = true
+		})
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("after-command work ran %t: %v", ran, err)
+	}
+	if table, _, _ := store.Snapshot(gameID); len(table.afterUnlock) != 0 {
+		t.Errorf("%d after-command work items left on the table", len(table.afterUnlock))
+	}
+}
+
+func TestActionLogsKeepOrder(t *testing.T) {
+	var mu sync.Mutex
+	var written []string
+	queue := newActionLogQueue(func(action GameActionLog) error {
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, action.ActionType)
+		return nil
+	})
+
+	const gameID = "store_test_logs"
+	want := make([]string, 200)
+	for i := range want {
+		want[i] = fmt.Sprintf("action_%d", i)
+		queue.queue(GameActionLog{GameID: gameID, ActionType: want[i]})
+	}
+	queue.flush(gameID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(written) != fmt.Sprint(want) {
+		t.Fatalf("rows written out of order: %v", written)
+	}
+}
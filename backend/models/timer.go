@@ -0,0 +1,505 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TurnTimeouts are the per-phase time limits; 0 disables the limit
+// 抢庄倒计时按规则 §3.1：首次10秒，每次叫庄/反庄后重新计5秒
+type TurnTimeouts struct {
+	Calling       time.Duration // 无人叫庄时的倒计时
+	CounterCall   time.Duration // 有人叫庄后，等待反庄的倒计时
+	Flipping      time.Duration // 翻底牌的间隔
+	Discarding    time.Duration // 庄家扣牌
+	CallingFriend time.Duration // 庄家叫朋友
+	Playing       time.Duration // 每次出牌
+	// Trusteeship is the delay before acting for a seat in trusteeship (and AI seats)
+	Trusteeship time.Duration
+	// TrusteeshipAfter is how many timeouts in a row put a seat in trusteeship (托管)
+	TrusteeshipAfter int
+}
+
+// turnTimeouts is read once from the environment (values in seconds)
+var turnTimeouts = TurnTimeouts{
+	Calling:          seconds(getEnvInt("TURN_TIMEOUT_CALLING", 10)),
+	CounterCall:      seconds(getEnvInt("TURN_TIMEOUT_COUNTER_CALL", 5)),
+	Flipping:         seconds(getEnvInt("TURN_TIMEOUT_FLIPPING", 1)),
+	Discarding:       seconds(getEnvInt("TURN_TIMEOUT_DISCARDING", 60)),
+	CallingFriend:    seconds(getEnvInt("TURN_TIMEOUT_CALLING_FRIEND", 30)),
+	Playing:          seconds(getEnvInt("TURN_TIMEOUT_PLAYING", 30)),
+	Trusteeship:      seconds(getEnvInt("TURN_TIMEOUT_TRUSTEESHIP", 1)),
+	TrusteeshipAfter: getEnvInt("TRUSTEESHIP_AFTER_TIMEOUTS", 2),
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// errStaleDeadline means the turn moved on before its timer fired
+var errStaleDeadline = errors.New("deadline no longer current")
+
+// turnScheduler keeps one timer per game, firing at the table's Deadline
+type turnScheduler struct {
+	mu     sync.Mutex
+	timers map[string]*turnTimer
+}
+
+type turnTimer struct {
+	deadline int64
+	timer    *time.Timer
+}
+
+func newTurnScheduler() *turnScheduler {
+	return &turnScheduler{timers: make(map[string]*turnTimer)}
+}
+
+// arm (re)schedules the game's timer; deadline 0 cancels it
+// A deadline already in the past fires right away (e.g. after a restart).
+func (s *turnScheduler) arm(gameID string, deadline int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.timers[gameID]; ok {
+		if current.deadline == deadline {
+			return
+		}
+		current.timer.Stop()
+		delete(s.timers, gameID)
+	}
+	if deadline == 0 {
+		return
+	}
+
+	delay := time.Until(time.UnixMilli(deadline))
+	s.timers[gameID] = &turnTimer{
+		deadline: deadline,
+		timer:    time.AfterFunc(delay, func() { s.fire(gameID, deadline) }),
+	}
+}
+
+// fire runs the timeout; the table lock is taken by expireTurn, never under s.mu
+func (s *turnScheduler) fire(gameID string, deadline int64) {
+	s.mu.Lock()
+	if current, ok := s.timers[gameID]; ok && current.deadline == deadline {
+		delete(s.timers, gameID)
+	}
+	s.mu.Unlock()
+
+	expireTurn(gameID, deadline)
+}
+
+// timeoutRetryDelay is how long a turn waits before a failed timeout is retried
+const timeoutRetryDelay = 5 * time.Second
+
+// expireTurn acts for whoever let the deadline pass
+// If that fails, the turn gets a new deadline to try again: with the timer gone
+// and nobody to act, the table would otherwise wait forever.
+func expireTurn(gameID string, deadline int64) {
+	chosen := decideTimedOutPlay(gameID, deadline)
+	_, err := activeGames.Update(gameID, func(table *GameTable) error {
+		if table.Deadline != deadline {
+			return errStaleDeadline
+		}
+		if err := onTurnTimeout(table, chosen); err != nil {
+			log.Printf("Warning: turn timeout in game %s failed, retrying in %v: %v", gameID, timeoutRetryDelay, err)
+			table.Deadline = time.Now().Add(timeoutRetryDelay).UnixMilli()
+			seat, _ := turnTimeout(table)
+			publishGameEvent(newTableEvent(table, "turn_deadline", seat, map[string]interface{}{
+				"deadline": table.Deadline,
+			}))
+			return nil
+		}
+		refreshDeadline(table, time.Now())
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStaleDeadline) {
+		log.Printf("Warning: turn timeout in game %s failed: %v", gameID, err)
+	}
+}
+
+// decideTimedOutPlay asks the Strategy of the seat whose turn ran out for its play
+// on a copy of the table, without holding the table lock: the hard AI's search and
+// external bots take a while. Returns nil if the table doesn't wait for a play at
+// that deadline; the play is checked again when it is made.
+func decideTimedOutPlay(gameID string, deadline int64) []int {
+	table, found, err := activeGames.Snapshot(gameID)
+	if err != nil || !found || table.Deadline != deadline || table.Status != "playing" {
+		return nil
+	}
+	hand, ok := table.PlayerHands[table.CurrentPlayer]
+	if !ok {
+		return nil
+	}
+	return strategyFor(hand).DecidePlay(table, table.CurrentPlayer)
+}
+
+// turnTimeout returns the seat the table is waiting for (0 = nobody in
+// particular) and how long it may take
+func turnTimeout(table *GameTable) (int, time.Duration) {
+	var seat int
+	var timeout time.Duration
+
+	switch table.Status {
+	case "calling":
+		switch table.CallPhase {
+		case "counting":
+			if len(table.CallRecords) == 0 {
+				return 0, turnTimeouts.Calling
+			}
+			return 0, turnTimeouts.CounterCall
+		case "flipping":
+			return 0, turnTimeouts.Flipping
+		}
+		return 0, 0
+	case "discarding":
+		seat, timeout = table.DealerSeat, turnTimeouts.Discarding
+	case "calling_friend":
+		seat, timeout = table.DealerSeat, turnTimeouts.CallingFriend
+	case "playing":
+		seat, timeout = table.CurrentPlayer, turnTimeouts.Playing
+	default:
+		return 0, 0
+	}
+
+	// 托管或AI座位不用等满时限
+	if hand, ok := table.PlayerHands[seat]; ok && timeout > 0 && (hand.Trusteeship || isAIPlayerID(hand.UserID)) {
+		timeout = turnTimeouts.Trusteeship
+	}
+	return seat, timeout
+}
+
+// refreshDeadline starts a new deadline whenever the table waits for something new
+// Called after every successful command under the table lock; the store arms the
+// timer from table.Deadline when it saves the table.
+func refreshDeadline(table *GameTable, now time.Time) {
+	key, seat, timeout := currentTurn(table)
+	if key == table.turnKey {
+		return
+	}
+	table.turnKey = key
+
+	if timeout <= 0 {
+		table.Deadline = 0
+		return
+	}
+	table.Deadline = now.Add(timeout).UnixMilli()
+	if table.Status == "calling" && table.CallPhase == "counting" {
+		table.CallCountdown = int(timeout / time.Second)
+	}
+
+	publishGameEvent(newTableEvent(table, "turn_deadline", seat, map[string]interface{}{
+		"deadline": table.Deadline,
+	}))
+}
+
+// currentTurn identifies what the table waits for: a key that changes whenever
+// a new turn starts, the seat (see turnTimeout) and its time limit
+func currentTurn(table *GameTable) (string, int, time.Duration) {
+	seat, timeout := turnTimeout(table)
+
+	trusteeship := false
+	if hand, ok := table.PlayerHands[seat]; ok {
+		trusteeship = hand.Trusteeship
+	}
+	key := fmt.Sprintf("%s/%s/%d/%d/%d/%d/%d/%t", table.Status, table.CallPhase, seat,
+		len(table.CallRecords), len(table.FlippedBottomCards), len(table.TricksWon), len(table.TrickPlays), trusteeship)
+	return key, seat, timeout
+}
+
+// restoreTurnKey re-derives the unexported turn key of a table read back from a
+// snapshot, so the running turn keeps its Deadline instead of getting a fresh one
+// on the next command. A turn that should have a deadline but has none (e.g. a
+// snapshot from before turn limits) is left to refreshDeadline to start.
+func restoreTurnKey(table *GameTable) {
+	key, _, timeout := currentTurn(table)
+	if timeout > 0 && table.Deadline == 0 {
+		table.turnKey = ""
+		return
+	}
+	table.turnKey = key
+}
+
+// onTurnTimeout does what the table was waiting for: ends the calling countdown,
+// flips the next bottom card, or acts for the seat whose turn it is
+// chosen is the play decided for the seat beforehand (see decideTimedOutPlay), or
+// nil to decide it now.
+func onTurnTimeout(table *GameTable, chosen []int) error {
+	switch table.Status {
+	case "calling":
+		if table.CallPhase == "flipping" {
+			_, err := flipBottomCard(table)
+			return err
+		}
+		// AI 座位在倒计时结束前叫庄或反庄，叫了就重新倒计时
+		if aiCallDealer(table) {
+			return nil
+		}
+		return endCallCountdown(table)
+
+	case "discarding":
+		hand := markTimeout(table, table.DealerSeat)
+		if hand == nil {
+			return fmt.Errorf("dealer hand not found")
+		}
+		ai := &AIPlayer{
+			UserID:     hand.UserID,
+			SeatNumber: table.DealerSeat,
+			Hand:       hand.Cards,
+		}
+		_, err := discardBottomCards(table, hand.UserID, ai.DecideDiscard(table))
+		return err
+
+	case "calling_friend":
+		hand := markTimeout(table, table.DealerSeat)
+		if hand == nil {
+			return fmt.Errorf("dealer hand not found")
+		}
+		return autoCallFriend(table, hand)
+
+	case "playing":
+		hand := markTimeout(table, table.CurrentPlayer)
+		if hand == nil {
+			return fmt.Errorf("player %d not found", table.CurrentPlayer)
+		}
+		return autoPlay(table, hand, chosen)
+	}
+	return fmt.Errorf("nothing to time out while %s", table.Status)
+}
+
+// markTimeout counts a missed turn and puts the seat in trusteeship after too many
+// in a row. AI seats are never counted.
+func markTimeout(table *GameTable, seat int) *PlayerHand {
+	hand := table.PlayerHands[seat]
+	if hand == nil || isAIPlayerID(hand.UserID) {
+		return hand
+	}
+
+	hand.Timeouts++
+
+	LogGameAction(GameActionLogRequest{
+		GameID:     table.GameID,
+		ActionType: "turn_timeout",
+		PlayerSeat: seat,
+		PlayerID:   hand.UserID,
+		ActionData: map[string]interface{}{
+			"status":   table.Status,
+			"deadline": table.Deadline,
+		},
+		ResultData: map[string]interface{}{
+			"timeouts": hand.Timeouts,
+		},
+	})
+
+	if turnTimeouts.TrusteeshipAfter > 0 && hand.Timeouts >= turnTimeouts.TrusteeshipAfter {
+		setTrusteeship(table, seat, true, "timeout")
+	}
+	return hand
+}
+
+// playerActed resets the timeout count of a player who acted in time
+// Trusteeship also ends: acting yourself means you are back.
+func playerActed(table *GameTable, userID string) {
+	seat := table.SeatOf(userID)
+	hand, ok := table.PlayerHands[seat]
+	if !ok {
+		return
+	}
+	hand.Timeouts = 0
+	setTrusteeship(table, seat, false, "resumed")
+}
+
+// endCallCountdown closes the calling countdown (RULE.md §3.1)
+// If a call stands, its caller becomes dealer; otherwise the bottom is flipped.
+func endCallCountdown(table *GameTable) error {
+	if table.Status != "calling" || table.CallPhase != "counting" {
+		return fmt.Errorf("not in calling countdown")
+	}
+
+	if len(table.CallRecords) > 0 {
+		// 倒计时结束无人再反庄，临时庄家成为庄家
+		table.CallPhase = "finished"
+		finalizeDealerAndStartPlaying(table)
+	} else {
+		table.CallPhase = "flipping"
+	}
+	table.UpdatedAt = time.Now()
+
+	LogGameAction(GameActionLogRequest{
+		GameID:     table.GameID,
+		ActionType: "call_countdown_end",
+		PlayerSeat: 0,
+		PlayerID:   "",
+		ActionData: map[string]interface{}{
+			"call_count": len(table.CallRecords),
+		},
+		ResultData: map[string]interface{}{
+			"dealer_seat": table.DealerSeat,
+			"trump_suit":  table.TrumpSuit,
+			"trump_rank":  table.TrumpRank,
+			"call_phase":  table.CallPhase,
+			"status":      table.Status,
+		},
+	})
+
+	evt := newTableEvent(table, "call_countdown_end", 0, nil)
+	if table.Status == "discarding" {
+		evt = evt.withHandUpdate(table, table.DealerSeat)
+	}
+	publishGameEvent(evt)
+	return nil
+}
+
+// autoCallFriend calls a friend card for the dealer: the AI's choice if it is
+// accepted, otherwise the first A or K the rules allow
+func autoCallFriend(table *GameTable, hand *PlayerHand) error {
+	ai := &AIPlayer{
+		UserID:     hand.UserID,
+		SeatNumber: table.DealerSeat,
+		Hand:       hand.Cards,
+	}
+	suit, value, position := ai.DecideFriendCall(table)
+	if err := callFriendCard(table, hand.UserID, suit, value, position); err == nil {
+		return nil
+	}
+
+	var lastErr error
+	for _, value := range []string{"A", "K"} {
+		for _, suit := range []string{"spades", "hearts", "diamonds", "clubs"} {
+			if lastErr = callFriendCard(table, hand.UserID, suit, value, 1); lastErr == nil {
+				return nil
+			}
+		}
+	}
+	return lastErr
+}
+
+// autoPlay plays for the current seat: chosen (decided by the seat's Strategy
+// outside the lock), or the first legal play found if the rules refuse it, or
+// failing that the normal AI's choice
+// It runs under the table lock, so it never asks the seat's Strategy itself: the
+// hard AI's search and external bots would hold up the table.
+func autoPlay(table *GameTable, hand *PlayerHand, chosen []int) error {
+	normal := newHeuristicStrategy(AISettings{Difficulty: AIDifficultyNormal})
+	indices := chosen
+	if indices == nil {
+		indices = normal.DecidePlay(table, hand.SeatNumber)
+	}
+	if _, err := playCardsGame(table, hand.UserID, indices); err == nil {
+		return nil
+	}
+
+	if indices = findLegalPlay(table, hand); indices != nil {
+		if _, err := playCardsGame(table, hand.UserID, indices); err == nil {
+			return nil
+		}
+	}
+
+	// 搜索预算用完也没找到时，交给启发式 AI
+	indices = normal.DecidePlay(table, hand.SeatNumber)
+	if _, err := playCardsGame(table, hand.UserID, indices); err != nil {
+		return fmt.Errorf("no legal play found for seat %d: %w", hand.SeatNumber, err)
+	}
+	return nil
+}
+
+// maxPlaySearch bounds how many combinations findLegalPlay tries
+const maxPlaySearch = 200000
+
+// findLegalPlay returns the lowest-ranked play the rules accept
+// Leading, that is the lowest single card. Following, cards of the lead suit come
+// first (they must be followed), then the cheapest combinations of the rest.
+func findLegalPlay(table *GameTable, hand *PlayerHand) []int {
+	order := make([]int, len(hand.Cards))
+	for i := range hand.Cards {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return getCardRank(hand.Cards[order[a]], table.TrumpSuit, table.TrumpRank) <
+			getCardRank(hand.Cards[order[b]], table.TrumpSuit, table.TrumpRank)
+	})
+
+	if len(table.TrickPlays) == 0 {
+		if len(order) == 0 {
+			return nil
+		}
+		return order[:1]
+	}
+
+	leadCards := table.TrickPlays[0].Cards
+	need := len(leadCards)
+	if need > len(order) {
+		return nil
+	}
+
+	// 王、级牌和主花色同属主牌一门（同 CardMemory.Group）
+	group := func(card Card) string {
+		if isTrumpCard(card, table.TrumpSuit, table.TrumpRank) {
+			return "trump"
+		}
+		return card.Suit
+	}
+	leadGroup := group(leadCards[0])
+	var suited, others []int
+	for _, idx := range order {
+		if group(hand.Cards[idx]) == leadGroup {
+			suited = append(suited, idx)
+		} else {
+			others = append(others, idx)
+		}
+	}
+
+	// 有色必须跟色：够数时只在同花色里找，不够时同花色全出再补其他牌
+	pool, fixed := others, suited
+	if len(suited) >= need {
+		pool, fixed = suited, nil
+	}
+
+	tried := 0
+	var found []int
+	chosen := make([]int, 0, need)
+	var search func(start int) bool
+	search = func(start int) bool {
+		if len(fixed)+len(chosen) == need {
+			tried++
+			indices := append(append([]int{}, fixed...), chosen...)
+			cards := make([]Card, len(indices))
+			for i, idx := range indices {
+				cards[i] = hand.Cards[idx]
+			}
+			if validateCardPlay(cards, table) == nil {
+				found = indices
+				return true
+			}
+			return false
+		}
+		for i := start; i < len(pool) && tried < maxPlaySearch; i++ {
+			chosen = append(chosen, pool[i])
+			if search(i + 1) {
+				return true
+			}
+			chosen = chosen[:len(chosen)-1]
+		}
+		return false
+	}
+	search(0)
+	if found == nil && fixed != nil {
+		// 校验不允许混出时（如缺对子），在整手牌里重找
+		pool, fixed = order, nil
+		tried = 0
+		search(0)
+	}
+	return found
+}
+
+// isAIPlayerID reports whether the seat is played by the built-in AI
+func isAIPlayerID(userID string) bool {
+	return len(userID) >= 3 && userID[:3] == "ai_"
+}
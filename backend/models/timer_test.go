@@ -0,0 +1,80 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindLegalPlay(t *testing.T) {
+	tests := []struct {
+		name string
+		lead string
+		hand string
+		want string
+	}{
+		{"lead the smallest card", "", "SA D4 big", "D4"},
+		{"follow suit", "SK", "SA D4 H3", "SA"},
+		{"follow a pair with a pair", "S9 S9", "SA SA S3 D4", "SA SA"},
+		{"short suit and discard", "S9 S9", "SA D4 C5", "SA D4"},
+		{"discard when void", "SK", "D4 H3", "D4"},
+		{"follow trump with a level card", "H5", "DK SA S2", "S2"},
+		{"follow a level card lead with trump", "S2", "S5 H3", "H3"},
+		{"follow trump with a joker", "small", "SA big", "big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 打2，红桃是主
+			hand := &PlayerHand{SeatNumber: 2, Cards: testCards(tt.hand)}
+			table := &GameTable{
+				TrumpSuit:     "hearts",
+				TrumpRank:     "2",
+				CurrentPlayer: 2,
+				PlayerHands:   map[int]*PlayerHand{2: hand},
+			}
+			if tt.lead != "" {
+				table.TrickPlays = []TrickPlay{{Seat: 1, Cards: testCards(tt.lead), IsLead: true}}
+			}
+
+			indices := findLegalPlay(table, hand)
+			got := make([]Card, len(indices))
+			for i, idx := range indices {
+				got[i] = hand.Cards[idx]
+			}
+			want := testCards(tt.want)
+			if len(got) != len(want) {
+				t.Fatalf("played %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("played %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestExpireTurnRetries gives a turn whose timeout fails a new deadline, so the
+// timer keeps coming back instead of leaving the table waiting
+func TestExpireTurnRetries(t *testing.T) {
+	store := NewGameStore(nil, nil)
+	useTestStore(t, store)
+
+	// 轮到的座位不存在，代打必然失败
+	const gameID = "timer_test_retry"
+	deadline := time.Now().UnixMilli()
+	store.PutIfAbsent(&GameTable{GameID: gameID, Status: "playing", CurrentPlayer: 3, Deadline: deadline}, nil)
+
+	expireTurn(gameID, deadline)
+	table, _, _ := store.Snapshot(gameID)
+	if table.Deadline <= deadline {
+		t.Fatalf("deadline %d not moved past %d", table.Deadline, deadline)
+	}
+
+	// 新的期限到了会再试一次
+	retry := table.Deadline
+	time.Sleep(2 * time.Millisecond)
+	expireTurn(gameID, retry)
+	if table, _, _ = store.Snapshot(gameID); table.Deadline <= retry {
+		t.Errorf("deadline %d not moved past %d on the retry", table.Deadline, retry)
+	}
+}
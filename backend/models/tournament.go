@@ -0,0 +1,237 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Tournament: strategies play each other over many seeded deals (cmd/simulate
+// -tournament). Every deal is played in several rotations: the line-up is shifted
+// one seat at a time and, when the entrants don't divide the five seats evenly,
+// the entrants also swap places in it. So over one deal every strategy sits at
+// every seat equally often with the same cards, and gets the dealer's and the
+// defenders' hands alike.
+// The five seat-hands of one play, and the rotations of one deal, share their
+// cards and are far from independent; only the deals are. Win rates and their 95%
+// confidence intervals are therefore computed per deal (clustered), and shown as
+// an Elo-style rating against the field's average win rate.
+
+// tournamentSeatShifts is how often a line-up is shifted: once per seat
+const tournamentSeatShifts = 5
+
+// ratingBase is the rating of a strategy that wins as often as the field average
+const ratingBase = 1500
+
+// TournamentOptions configures a tournament
+type TournamentOptions struct {
+	Entrants []AISettings // at least two, each with its own strategy label
+	Deals    int          // deal i uses seed BaseSeed+i and is played in every rotation
+	BaseSeed int64
+	Workers  int // hands played in parallel, 0 = GOMAXPROCS
+}
+
+// EntrantResult is how one strategy did in a tournament, counted per seat and hand
+type EntrantResult struct {
+	Rank          int     `json:"rank"`
+	Strategy      string  `json:"strategy"`
+	Hands         int     `json:"hands"`
+	Wins          int     `json:"wins"`
+	WinRate       float64 `json:"winRate"`
+	WinRateLow    float64 `json:"winRateLow"` // 95% interval, clustered by deal
+	WinRateHigh   float64 `json:"winRateHigh"`
+	Rating        float64 `json:"rating"` // ratingBase + 400·log10 of the odds against the baseline
+	RatingLow     float64 `json:"ratingLow"`
+	RatingHigh    float64 `json:"ratingHigh"`
+	DealerHands   int     `json:"dealerHands"` // 在庄家方（庄家或朋友）
+	DealerWins    int     `json:"dealerWins"`
+	DefenderHands int     `json:"defenderHands"`
+	DefenderWins  int     `json:"defenderWins"`
+	Violations    int     `json:"violations"` // plays the rules refused
+}
+
+// TournamentReport is the ranking of a tournament
+type TournamentReport struct {
+	Deals     int `json:"deals"`
+	Rotations int `json:"rotations"` // plays of each deal
+	Hands     int `json:"hands"`     // hands played to the end
+	// BaselineWinRate is the average win rate of a seat-hand, the rating's 1500:
+	// the winning side has 1 to 4 seats, so it isn't 50%
+	BaselineWinRate float64          `json:"baselineWinRate"`
+	Failed          int              `json:"failed"` // hands stopped by an engine error or panic
+	BaseSeed        int64            `json:"baseSeed"`
+	Ranking         []*EntrantResult `json:"ranking"` // best first
+	Errors          []string         `json:"errors,omitempty"`
+	Duration        time.Duration    `json:"duration"`
+}
+
+// RunTournament plays every deal in every rotation and ranks the entrants
+func RunTournament(opts TournamentOptions) (*TournamentReport, error) {
+	if len(opts.Entrants) < 2 {
+		return nil, fmt.Errorf("a tournament needs at least two strategies")
+	}
+	if len(opts.Entrants) > tournamentSeatShifts {
+		return nil, fmt.Errorf("at most %d strategies fit at one table", tournamentSeatShifts)
+	}
+	results := make([]*EntrantResult, len(opts.Entrants))
+	for i, settings := range opts.Entrants {
+		label := strategyLabel(settings)
+		for _, other := range results[:i] {
+			if other.Strategy == label {
+				return nil, fmt.Errorf("%s entered twice", label)
+			}
+		}
+		results[i] = &EntrantResult{Strategy: label}
+	}
+
+	start := time.Now()
+	rotations := tournamentRotations(len(opts.Entrants))
+	hands := playHands(opts.Deals*rotations, opts.Workers, func(i int) *SimulatedHand {
+		return SimulateHand(opts.BaseSeed+int64(i/rotations), tournamentSeats(opts.Entrants, i%rotations))
+	})
+
+	// 每个策略在每副牌上的胜场和座位局数
+	dealWins := make([][]int, len(opts.Entrants))
+	dealHands := make([][]int, len(opts.Entrants))
+	for i := range opts.Entrants {
+		dealWins[i] = make([]int, opts.Deals)
+		dealHands[i] = make([]int, opts.Deals)
+	}
+
+	report := &TournamentReport{Deals: opts.Deals, Rotations: rotations, BaseSeed: opts.BaseSeed}
+	totalWins := 0
+	for i, hand := range hands {
+		if hand.Error != "" {
+			report.Failed++
+			report.Errors = append(report.Errors, fmt.Sprintf("seed %d rotation %d: %s", hand.Seed, i%rotations, hand.Error))
+			continue
+		}
+		report.Hands++
+
+		deal := i / rotations
+		lineup := tournamentLineup(len(opts.Entrants), i%rotations)
+		for seat := 1; seat <= 5; seat++ {
+			entrant := lineup[seat]
+			r := results[entrant]
+			r.Hands++
+			dealHands[entrant][deal]++
+			won := hand.IsHostTeam(seat) == (hand.WinnerTeam == "host")
+			if won {
+				r.Wins++
+				dealWins[entrant][deal]++
+				totalWins++
+			}
+			if hand.IsHostTeam(seat) {
+				r.DealerHands++
+				if won {
+					r.DealerWins++
+				}
+			} else {
+				r.DefenderHands++
+				if won {
+					r.DefenderWins++
+				}
+			}
+		}
+		for _, v := range hand.Violations {
+			results[lineup[v.Seat]].Violations++
+		}
+	}
+
+	if report.Hands > 0 {
+		report.BaselineWinRate = float64(totalWins) / float64(report.Hands*5)
+	}
+	for i, r := range results {
+		if r.Hands == 0 {
+			continue
+		}
+		r.WinRate = float64(r.Wins) / float64(r.Hands)
+		r.WinRateLow, r.WinRateHigh = clusteredInterval(dealWins[i], dealHands[i])
+		r.Rating = winRateRating(r.WinRate, report.BaselineWinRate)
+		r.RatingLow = winRateRating(r.WinRateLow, report.BaselineWinRate)
+		r.RatingHigh = winRateRating(r.WinRateHigh, report.BaselineWinRate)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].WinRate > results[j].WinRate
+	})
+	for i, r := range results {
+		r.Rank = i + 1
+	}
+	report.Ranking = results
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// tournamentRotations is how often each deal is played for the number of entrants
+// Shifting the line-up through the five seats is enough when the entrants fill the
+// seats evenly (five of them); otherwise the line-up is also relabelled once per
+// entrant, e.g. with two the 3-seat and the 2-seat side swap.
+func tournamentRotations(entrants int) int {
+	if tournamentSeatShifts%entrants == 0 {
+		return tournamentSeatShifts
+	}
+	return tournamentSeatShifts * entrants
+}
+
+// tournamentLineup maps seat -> entrant index for one rotation
+// The five seats are filled by cycling through the entrants, shifted by rotation,
+// and every tournamentSeatShifts rotations the entrants move up one place in the
+// cycle, so over all rotations each entrant sits at each seat equally often.
+func tournamentLineup(entrants, rotation int) map[int]int {
+	shift, relabel := rotation%tournamentSeatShifts, rotation/tournamentSeatShifts
+	lineup := make(map[int]int, 5)
+	for seat := 1; seat <= 5; seat++ {
+		lineup[seat] = ((seat-1+shift)%tournamentSeatShifts + relabel) % entrants
+	}
+	return lineup
+}
+
+// tournamentSeats returns the seat settings for one rotation
+func tournamentSeats(entrants []AISettings, rotation int) map[int]AISettings {
+	seats := make(map[int]AISettings, 5)
+	for seat, i := range tournamentLineup(len(entrants), rotation) {
+		seats[seat] = entrants[i]
+	}
+	return seats
+}
+
+// clusteredInterval is the 95% interval of a win rate whose seat-hands come in
+// clusters, one per deal: wins[d] out of hands[d]. Seat-hands of one deal are
+// correlated, so the variance is taken between deals (cluster-robust standard
+// error of the ratio Σwins/Σhands), not between seat-hands.
+func clusteredInterval(wins, hands []int) (float64, float64) {
+	totalWins, totalHands, clusters := 0, 0, 0
+	for d := range hands {
+		if hands[d] > 0 {
+			totalWins += wins[d]
+			totalHands += hands[d]
+			clusters++
+		}
+	}
+	if clusters < 2 {
+		return 0, 1
+	}
+
+	p := float64(totalWins) / float64(totalHands)
+	sum := 0.0
+	for d := range hands {
+		if hands[d] > 0 {
+			residual := float64(wins[d]) - p*float64(hands[d])
+			sum += residual * residual
+		}
+	}
+	c := float64(clusters)
+	se := math.Sqrt(c/(c-1)*sum) / float64(totalHands)
+
+	const z = 1.96
+	return math.Max(0, p-z*se), math.Min(1, p+z*se)
+}
+
+// winRateRating turns a win rate into an Elo-style rating against the baseline
+// win rate (400 points = 10:1 odds against a strategy that wins as often)
+func winRateRating(p, baseline float64) float64 {
+	clamp := func(x float64) float64 { return math.Min(math.Max(x, 0.001), 0.999) }
+	p, baseline = clamp(p), clamp(baseline)
+	return ratingBase + 400*math.Log10(p/(1-p)*(1-baseline)/baseline)
+}
@@ -0,0 +1,44 @@
+package models
+
+import "testing"
+
+// TestTournamentLineupBalanced checks that over the rotations of one deal every
+// entrant sits at every seat equally often
+func TestTournamentLineupBalanced(t *testing.T) {
+	for entrants := 2; entrants <= 5; entrants++ {
+		rotations := tournamentRotations(entrants)
+		counts := make(map[[2]int]int)
+		for rotation := 0; rotation < rotations; rotation++ {
+			for seat, entrant := range tournamentLineup(entrants, rotation) {
+				counts[[2]int{seat, entrant}]++
+			}
+		}
+		want := rotations / entrants
+		for seat := 1; seat <= 5; seat++ {
+			for entrant := 0; entrant < entrants; entrant++ {
+				if n := counts[[2]int{seat, entrant}]; n != want {
+					t.Errorf("%d entrants: entrant %d sits at seat %d %d times in %d rotations, want %d",
+						entrants, entrant, seat, n, rotations, want)
+				}
+			}
+		}
+	}
+}
+
+func TestClusteredInterval(t *testing.T) {
+	// 每副牌都赢一半：区间收紧在 50% 附近
+	low, high := clusteredInterval([]int{5, 5, 5, 5}, []int{10, 10, 10, 10})
+	if low != 0.5 || high != 0.5 {
+		t.Errorf("interval %.3f-%.3f, want 0.5-0.5", low, high)
+	}
+
+	// 同样的胜场，但整副牌要么全赢要么全输：座位局之间不独立，区间要宽得多
+	low, high = clusteredInterval([]int{10, 0, 10, 0}, []int{10, 10, 10, 10})
+	if low > 0.1 || high < 0.9 {
+		t.Errorf("interval %.3f-%.3f is too narrow for all-or-nothing deals", low, high)
+	}
+
+	if low, high = clusteredInterval([]int{3}, []int{5}); low != 0 || high != 1 {
+		t.Errorf("one deal gives %.3f-%.3f, want 0-1", low, high)
+	}
+}
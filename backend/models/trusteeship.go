@@ -0,0 +1,164 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 托管：座位在玩家和 AI 之间切换
+// A seat in trusteeship is played by AIPlayer through the turn timer (after
+// TurnTimeouts.Trusteeship); the player keeps the seat and its current hand and
+// takes it back by acting, reconnecting or switching trusteeship off.
+
+// disconnectGrace is how long a seated player may be offline before the seat goes
+// into trusteeship, so a page reload does not hand the turn to the AI
+var disconnectGrace = seconds(getEnvInt("DISCONNECT_GRACE", 10))
+
+var (
+	presenceMu sync.Mutex
+	presence   = make(map[string]map[string]int) // gameID -> userID -> open connections
+)
+
+// SetTrusteeship lets a seated player hand their seat to the AI or take it back
+func SetTrusteeship(gameID, userID string, enabled bool) (*GameTable, error) {
+	return updateTable(gameID, func(table *GameTable) error {
+		seat := table.SeatOf(userID)
+		if seat == 0 {
+			return fmt.Errorf("player not in game")
+		}
+		if !isHandInProgress(table.Status) {
+			return fmt.Errorf("game not in progress")
+		}
+		if !enabled {
+			table.PlayerHands[seat].Timeouts = 0
+		}
+		setTrusteeship(table, seat, enabled, "manual")
+		return nil
+	})
+}
+
+// PlayerConnected records an open connection of a player and gives them their
+// seat back if it was put in trusteeship because they went offline
+// Returns the table after the reconnect.
+func PlayerConnected(gameID, userID string) (*GameTable, error) {
+	presenceMu.Lock()
+	if presence[gameID] == nil {
+		presence[gameID] = make(map[string]int)
+	}
+	presence[gameID][userID]++
+	presenceMu.Unlock()
+
+	return updateTable(gameID, func(table *GameTable) error {
+		seat := table.SeatOf(userID)
+		hand, ok := table.PlayerHands[seat]
+		if !ok || !isHandInProgress(table.Status) {
+			return nil
+		}
+		if hand.Trusteeship && hand.TrusteeshipReason == "disconnected" {
+			hand.Timeouts = 0
+			setTrusteeship(table, seat, false, "reconnected")
+		}
+		return nil
+	})
+}
+
+// PlayerDisconnected records a closed connection; once the player's last
+// connection has been gone for disconnectGrace, their seat goes into trusteeship
+func PlayerDisconnected(gameID, userID string) {
+	if playerOffline(gameID, userID) {
+		time.AfterFunc(disconnectGrace, func() { disconnectSeat(gameID, userID) })
+	}
+}
+
+// playerOffline drops one connection and reports whether it was the last one
+func playerOffline(gameID, userID string) bool {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+
+	users := presence[gameID]
+	if users == nil {
+		return true
+	}
+	users[userID]--
+	if users[userID] > 0 {
+		return false
+	}
+	delete(users, userID)
+	if len(users) == 0 {
+		delete(presence, gameID)
+	}
+	return true
+}
+
+// isPlayerOnline reports whether the player has an open connection to the game
+func isPlayerOnline(gameID, userID string) bool {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+	return presence[gameID][userID] > 0
+}
+
+// disconnectSeat puts a player who is still offline into trusteeship
+func disconnectSeat(gameID, userID string) {
+	if isPlayerOnline(gameID, userID) {
+		return
+	}
+	_, err := activeGames.Update(gameID, func(table *GameTable) error {
+		seat := table.SeatOf(userID)
+		hand, ok := table.PlayerHands[seat]
+		if !ok || !isHandInProgress(table.Status) || isAIPlayerID(hand.UserID) {
+			return nil
+		}
+		setTrusteeship(table, seat, true, "disconnected")
+		refreshDeadline(table, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to put seat of %s in game %s into trusteeship: %v", userID, gameID, err)
+	}
+}
+
+// setTrusteeship switches a seat between the player and the AI, logging and
+// publishing the change; nothing happens if the seat is already in that state
+// reason: timeout, disconnected, manual (on); resumed, reconnected, manual (off)
+func setTrusteeship(table *GameTable, seat int, enabled bool, reason string) {
+	hand, ok := table.PlayerHands[seat]
+	if !ok || hand.Trusteeship == enabled {
+		return
+	}
+
+	hand.Trusteeship = enabled
+	hand.TrusteeshipReason = ""
+	if enabled {
+		hand.TrusteeshipReason = reason
+	}
+
+	LogGameAction(GameActionLogRequest{
+		GameID:     table.GameID,
+		ActionType: "trusteeship",
+		PlayerSeat: seat,
+		PlayerID:   hand.UserID,
+		ActionData: map[string]interface{}{
+			"enabled": enabled,
+			"reason":  reason,
+		},
+		ResultData: map[string]interface{}{
+			"status": table.Status,
+		},
+	})
+
+	publishGameEvent(newTableEvent(table, "trusteeship", seat, map[string]interface{}{
+		"trusteeship": enabled,
+		"reason":      reason,
+	}))
+}
+
+// isHandInProgress reports whether cards have been dealt and the hand is not over
+func isHandInProgress(status string) bool {
+	switch status {
+	case "calling", "discarding", "calling_friend", "playing":
+		return true
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -10,19 +11,30 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	Level     string    `json:"level"`
-	Wins      int       `json:"wins"`
-	Losses    int       `json:"losses"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Level    string `json:"level"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+	// LoginDisabled marks placeholder users (imported seats) nobody can log in as
+	LoginDisabled bool      `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // LevelOrder defines the order of levels from lowest to highest
 var LevelOrder = []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K", "A"}
 
+// placeholderUsernamePrefix starts the usernames of placeholder users; Register
+// refuses it, so a real account can never take a placeholder's name
+const placeholderUsernamePrefix = "#"
+
+// IsReservedUsername reports whether username is kept for placeholder users
+func IsReservedUsername(username string) bool {
+	return strings.HasPrefix(username, placeholderUsernamePrefix)
+}
+
 // generateID generates a unique ID
 func generateID() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
@@ -47,12 +59,12 @@ func CreateUser(username, password string) (*User, error) {
 
 // GetUserByUsername retrieves a user by username
 func GetUserByUsername(username string) (*User, error) {
-	query := `SELECT id, username, password, level, wins, losses, created_at, updated_at FROM users WHERE username = $1`
+	query := `SELECT id, username, password, level, wins, losses, login_disabled, created_at, updated_at FROM users WHERE username = $1`
 
 	user := &User{}
 	err := db.QueryRow(query, username).Scan(
 		&user.ID, &user.Username, &user.Password, &user.Level,
-		&user.Wins, &user.Losses, &user.CreatedAt, &user.UpdatedAt,
+		&user.Wins, &user.Losses, &user.LoginDisabled, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -67,12 +79,12 @@ func GetUserByUsername(username string) (*User, error) {
 
 // GetUserByID retrieves a user by ID
 func GetUserByID(id string) (*User, error) {
-	query := `SELECT id, username, password, level, wins, losses, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, username, password, level, wins, losses, login_disabled, created_at, updated_at FROM users WHERE id = $1`
 
 	user := &User{}
 	err := db.QueryRow(query, id).Scan(
 		&user.ID, &user.Username, &user.Password, &user.Level,
-		&user.Wins, &user.Losses, &user.CreatedAt, &user.UpdatedAt,
+		&user.Wins, &user.Losses, &user.LoginDisabled, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -172,7 +184,7 @@ func indexOf(s, substr string) int {
 
 // ListUsers returns all users
 func ListUsers() ([]*User, error) {
-	query := `SELECT id, username, password, level, wins, losses, created_at, updated_at FROM users ORDER BY created_at DESC`
+	query := `SELECT id, username, password, level, wins, losses, login_disabled, created_at, updated_at FROM users ORDER BY created_at DESC`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -185,7 +197,7 @@ func ListUsers() ([]*User, error) {
 		user := &User{}
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.Password, &user.Level,
-			&user.Wins, &user.Losses, &user.CreatedAt, &user.UpdatedAt,
+			&user.Wins, &user.Losses, &user.LoginDisabled, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,213 @@
+package models
+
+import "time"
+
+// TableView is a redacted projection of GameTable that is safe to send to clients
+// Other players' hands are reduced to card counts; the bottom cards are only
+// shown to the dealer while discarding, and everything is opened after the game.
+type TableView struct {
+	GameID         string           `json:"gameId"`
+	HostID         string           `json:"hostId"`
+	Status         string           `json:"status"`
+	CurrentLevel   string           `json:"currentLevel"`
+	TrumpSuit      string           `json:"trumpSuit"`
+	HostCalledCard *CalledCard      `json:"hostCalledCard"`
+	FriendRevealed bool             `json:"friendRevealed"`
+	FriendSeat     int              `json:"friendSeat"`
+	IsSoloMode     bool             `json:"isSoloMode"`
+	CurrentPlayer  int              `json:"currentPlayer"`
+	CurrentTrick   []PlayedCard     `json:"currentTrick"`
+	TrickPlays     []TrickPlay      `json:"trickPlays"`
+	TrickLeader    int              `json:"trickLeader"`
+	TricksWon      [][]Card         `json:"tricksWon"`
+	TrickHistory   []CompletedTrick `json:"trickHistory"`
+	LastPlay       *PlayResult      `json:"lastPlay"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	UpdatedAt      time.Time        `json:"updatedAt"`
+
+	DealerSeat         int          `json:"dealerSeat"`
+	StartingDealerSeat int          `json:"startingDealerSeat"`
+	CallPhase          string       `json:"callPhase"`
+	CallCountdown      int          `json:"callCountdown"`
+	CurrentCaller      int          `json:"currentCaller"`
+	TrumpRank          string       `json:"trumpRank"`
+	FlippedBottomCards []Card       `json:"flippedBottomCards"`
+	CallRecords        []CallRecord `json:"callRecords"`
+	Deadline           int64        `json:"deadline"` // Current turn times out at (unix ms, 0 = no limit)
+
+	Seats      []SeatView `json:"seats"`      // Public per-seat info, ordered by seat
+	ViewerSeat int        `json:"viewerSeat"` // 0 for spectators
+	MyHand     []Card     `json:"myHand"`     // Viewer's own hand (empty for spectators)
+	// BottomCards is only set for the dealer during discarding and in the post-game view
+	BottomCards []Card `json:"bottomCards,omitempty"`
+	// Hands holds every seat's remaining cards, only set in the post-game view
+	Hands map[int][]Card `json:"hands,omitempty"`
+}
+
+// SeatView is the public information about one seat
+type SeatView struct {
+	SeatNumber int    `json:"seatNumber"`
+	UserID     string `json:"userId"`
+	CardCount  int    `json:"cardCount"`
+	IsFriend   bool   `json:"isFriend"`
+	Score      int    `json:"score"`
+	Collected  []Card `json:"collected"`
+	Level      string `json:"level"` // 本局开始时的等级
+	Timeouts   int    `json:"timeouts"`
+	// Trusteeship 托管中：服务器代为出牌
+	Trusteeship       bool        `json:"trusteeship"`
+	TrusteeshipReason string      `json:"trusteeshipReason,omitempty"`
+	AI                *AISettings `json:"ai,omitempty"` // AI 代打设置
+}
+
+// ViewFor returns the table as seen by the player sitting at seat
+func (t *GameTable) ViewFor(seat int) *TableView {
+	view := t.publicView()
+	view.ViewerSeat = seat
+
+	if hand, ok := t.PlayerHands[seat]; ok {
+		view.MyHand = copyCards(hand.Cards)
+	}
+
+	// 扣牌阶段只有庄家能看到底牌
+	if t.Status == "discarding" && seat == t.DealerSeat {
+		view.BottomCards = copyCards(t.BottomCards)
+	}
+
+	return view
+}
+
+// SpectatorView returns the table as seen by someone not seated at it
+func (t *GameTable) SpectatorView() *TableView {
+	return t.publicView()
+}
+
+// PostGameView returns the opened table once the game is finished
+// Remaining hands and the buried bottom cards are revealed to everyone.
+func (t *GameTable) PostGameView() *TableView {
+	view := t.publicView()
+	view.BottomCards = copyCards(t.BottomCards)
+	view.Hands = make(map[int][]Card, len(t.PlayerHands))
+	for seat, hand := range t.PlayerHands {
+		view.Hands[seat] = copyCards(hand.Cards)
+	}
+	return view
+}
+
+// ViewForUser picks the right projection for a user: post-game once the game is
+// finished, the seat view for players, and the spectator view otherwise
+func (t *GameTable) ViewForUser(userID string) *TableView {
+	if t.Status == "finished" {
+		view := t.PostGameView()
+		view.ViewerSeat = t.SeatOf(userID)
+		return view
+	}
+	if seat := t.SeatOf(userID); seat > 0 {
+		return t.ViewFor(seat)
+	}
+	return t.SpectatorView()
+}
+
+// SeatOf returns the seat number of a user, or 0 if the user is not seated
+func (t *GameTable) SeatOf(userID string) int {
+	for seat, hand := range t.PlayerHands {
+		if hand.UserID == userID {
+			return seat
+		}
+	}
+	return 0
+}
+
+// publicView copies every field that all viewers may see
+func (t *GameTable) publicView() *TableView {
+	view := &TableView{
+		GameID:             t.GameID,
+		HostID:             t.HostID,
+		Status:             t.Status,
+		CurrentLevel:       t.CurrentLevel,
+		TrumpSuit:          t.TrumpSuit,
+		FriendRevealed:     t.FriendRevealed,
+		FriendSeat:         t.FriendSeat,
+		IsSoloMode:         t.IsSoloMode,
+		CurrentPlayer:      t.CurrentPlayer,
+		CurrentTrick:       append([]PlayedCard{}, t.CurrentTrick...),
+		TrickPlays:         copyTrickPlays(t.TrickPlays),
+		TrickLeader:        t.TrickLeader,
+		TricksWon:          make([][]Card, 0, len(t.TricksWon)),
+		TrickHistory:       copyTrickHistory(t.TrickHistory),
+		LastPlay:           t.LastPlay,
+		CreatedAt:          t.CreatedAt,
+		UpdatedAt:          t.UpdatedAt,
+		DealerSeat:         t.DealerSeat,
+		StartingDealerSeat: t.StartingDealerSeat,
+		CallPhase:          t.CallPhase,
+		CallCountdown:      t.CallCountdown,
+		CurrentCaller:      t.CurrentCaller,
+		TrumpRank:          t.TrumpRank,
+		FlippedBottomCards: copyCards(t.FlippedBottomCards),
+		CallRecords:        append([]CallRecord{}, t.CallRecords...),
+		Deadline:           t.Deadline,
+		Seats:              make([]SeatView, 0, len(t.PlayerHands)),
+		MyHand:             make([]Card, 0),
+	}
+
+	if t.HostCalledCard != nil {
+		called := *t.HostCalledCard
+		view.HostCalledCard = &called
+	}
+
+	for _, trick := range t.TricksWon {
+		view.TricksWon = append(view.TricksWon, copyCards(trick))
+	}
+
+	for seat := 1; seat <= 5; seat++ {
+		hand, ok := t.PlayerHands[seat]
+		if !ok {
+			continue
+		}
+		view.Seats = append(view.Seats, SeatView{
+			SeatNumber:        seat,
+			UserID:            hand.UserID,
+			CardCount:         len(hand.Cards),
+			IsFriend:          hand.IsFriend,
+			Score:             hand.Score,
+			Collected:         copyCards(hand.Collected),
+			Level:             hand.Level,
+			Timeouts:          hand.Timeouts,
+			Trusteeship:       hand.Trusteeship,
+			TrusteeshipReason: hand.TrusteeshipReason,
+			AI:                hand.AI,
+		})
+	}
+
+	return view
+}
+
+// copyTrickPlays deep-copies the plays of a trick (never nil)
+func copyTrickPlays(plays []TrickPlay) []TrickPlay {
+	result := make([]TrickPlay, len(plays))
+	for i, play := range plays {
+		result[i] = play
+		result[i].Cards = copyCards(play.Cards)
+	}
+	return result
+}
+
+// copyTrickHistory deep-copies the finished tricks (never nil)
+func copyTrickHistory(tricks []CompletedTrick) []CompletedTrick {
+	result := make([]CompletedTrick, len(tricks))
+	for i, trick := range tricks {
+		result[i] = CompletedTrick{
+			Plays:  copyTrickPlays(trick.Plays),
+			Winner: trick.Winner,
+		}
+	}
+	return result
+}
+
+// copyCards returns a copy of cards that is never nil (so it encodes as [])
+func copyCards(cards []Card) []Card {
+	result := make([]Card, len(cards))
+	copy(result, cards)
+	return result
+}
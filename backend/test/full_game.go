@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"time"
 )
 
@@ -44,7 +45,12 @@ func main() {
 
 	// Create game
 	fmt.Println("\n[2] Create game room...")
-	req, _ := http.NewRequest("POST", baseURL+"/api/game/create", bytes.NewBufferString("name=测试房间"))
+	// GAME_SEED=<n> 复现某一局的发牌，服务器要以 ALLOW_FIXED_SEED=true 启动
+	createBody := "name=测试房间"
+	if seed := os.Getenv("GAME_SEED"); seed != "" {
+		createBody += "&seed=" + url.QueryEscape(seed)
+	}
+	req, _ := http.NewRequest("POST", baseURL+"/api/game/create", bytes.NewBufferString(createBody))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Authorization", "Bearer "+hostToken)
 	resp, _ = client.Do(req)
@@ -52,8 +58,13 @@ func main() {
 	resp.Body.Close()
 	var createResult map[string]interface{}
 	parseJSON(body, &createResult)
-	gameID := createResult["game"].(map[string]interface{})["id"].(string)
-	fmt.Printf("   ✅ Game created: %s\n", gameID)
+	game, ok := createResult["game"].(map[string]interface{})
+	if !ok {
+		fmt.Printf("   ❌ Create failed: %v\n", createResult["error"])
+		os.Exit(1)
+	}
+	gameID := game["id"].(string)
+	fmt.Printf("   ✅ Game created: %s (seed hash %v)\n", gameID, game["seedHash"])
 
 	// Login other players and join
 	fmt.Println("\n[3] Other players join game...")